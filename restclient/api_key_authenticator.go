@@ -0,0 +1,70 @@
+package restclient
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+/* APIKeyPlacement decides where APIKeyAuthenticator puts the key */
+type APIKeyPlacement int
+
+const (
+	APIKeyInHeader APIKeyPlacement = iota
+	APIKeyInQuery
+	APIKeyInCookie
+)
+
+/* APIKeyAuthenticator places the API key in the header, query parameter or cookie named Name */
+type APIKeyAuthenticator struct {
+	Name      string
+	Placement APIKeyPlacement
+	Source    TokenSource
+}
+
+func NewAPIKeyAuthenticator(placement APIKeyPlacement, name, key string) Authenticator {
+	return &APIKeyAuthenticator{
+		Name:      name,
+		Placement: placement,
+		Source:    StaticTokenSource(key),
+	}
+}
+
+/* NewAPIKeySourceAuthenticator creates an APIKeyAuthenticator that fetches the key from source for each request */
+func NewAPIKeySourceAuthenticator(placement APIKeyPlacement, name string, source TokenSource) Authenticator {
+	return &APIKeyAuthenticator{
+		Name:      name,
+		Placement: placement,
+		Source:    source,
+	}
+}
+
+func (aa APIKeyAuthenticator) Apply(request *http.Request) error {
+	if aa.Source == nil {
+		return errors.New("Token source is required to set an API key")
+	}
+	key, err := aa.Source.Token()
+	if err != nil {
+		return errors.Wrap(err, "Failed to get API key")
+	}
+	switch aa.Placement {
+	case APIKeyInHeader:
+		request.Header.Set(aa.Name, key)
+	case APIKeyInQuery:
+		query := request.URL.Query()
+		query.Set(aa.Name, key)
+		request.URL.RawQuery = query.Encode()
+	case APIKeyInCookie:
+		// Re-applying the authenticator e.g. on retries replaces the key instead of sending it twice
+		cookies := request.Cookies()
+		request.Header.Del("Cookie")
+		for _, cookie := range cookies {
+			if cookie.Name != aa.Name {
+				request.AddCookie(cookie)
+			}
+		}
+		request.AddCookie(&http.Cookie{Name: aa.Name, Value: key})
+	default:
+		return errors.Errorf("Unknown API key placement %d", aa.Placement)
+	}
+	return nil
+}
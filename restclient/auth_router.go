@@ -0,0 +1,149 @@
+package restclient
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/* AuthRouter picks the Authenticator of a request by its host, path prefix or a glob pattern, so that a single client
+can talk to several hosts that each need different auth. Routes are tried in the order they were added, Default (nil
+means no auth) is used if none of them matches. Configure the routes before the router is used by requests.
+
+A routed Authenticator keeps its optional behaviours (ChallengeAuthenticator, RefreshableAuthenticator,
+RejectionDetector) as the router is resolved before the request is authenticated. When a request is redirected to
+another host, the Authenticator routed for the new host is applied to the redirected request */
+type AuthRouter struct {
+	Default Authenticator
+	routes  []authRoute
+}
+
+type authRoute struct {
+	match func(u *url.URL) bool
+	auth  Authenticator
+}
+
+func NewAuthRouter(defaultAuth Authenticator) *AuthRouter {
+	return &AuthRouter{Default: defaultAuth}
+}
+
+/* Host routes the requests to host to auth, host is matched case-insensitively and only matches that port if it has one */
+func (ar *AuthRouter) Host(host string, auth Authenticator) *AuthRouter {
+	return ar.route(func(u *url.URL) bool {
+		return hostMatches(u, host)
+	}, auth)
+}
+
+/* PathPrefix routes the requests whose path starts with prefix to auth, an empty host matches any host */
+func (ar *AuthRouter) PathPrefix(host, prefix string, auth Authenticator) *AuthRouter {
+	return ar.route(func(u *url.URL) bool {
+		return (host == "" || hostMatches(u, host)) && strings.HasPrefix(u.EscapedPath(), prefix)
+	}, auth)
+}
+
+/* Glob routes the requests matching pattern to auth. A pattern without / is matched against the host name (e.g.
+*.example.com), otherwise against the host name followed by the path (e.g. api.example.com/v1/**). * matches any
+sequence of characters except /, ** also matches / */
+func (ar *AuthRouter) Glob(pattern string, auth Authenticator) *AuthRouter {
+	pattern = strings.ToLower(pattern)
+	return ar.route(func(u *url.URL) bool {
+		subject := strings.ToLower(u.Hostname())
+		if strings.Contains(pattern, "/") {
+			subject += u.EscapedPath()
+		}
+		return globMatch(pattern, subject)
+	}, auth)
+}
+
+func (ar *AuthRouter) route(match func(u *url.URL) bool, auth Authenticator) *AuthRouter {
+	ar.routes = append(ar.routes, authRoute{match: match, auth: auth})
+	return ar
+}
+
+/* Authenticator returns the Authenticator routed for u, nil means no auth */
+func (ar *AuthRouter) Authenticator(u *url.URL) Authenticator {
+	for _, route := range ar.routes {
+		if route.match(u) {
+			return route.auth
+		}
+	}
+	return ar.Default
+}
+
+/* Apply applies the Authenticator routed for the request URL */
+func (ar *AuthRouter) Apply(request *http.Request) error {
+	auth, err := resolveAuthenticator(ar, request.URL)
+	if err != nil || auth == nil {
+		return err
+	}
+	return auth.Apply(request)
+}
+
+/* resolveAuthenticator returns the Authenticator to apply to a request to u, resolving (nested) routers. A router that
+routes back to itself, directly or through other routers, is an error */
+func resolveAuthenticator(auth Authenticator, u *url.URL) (Authenticator, error) {
+	visited := make(map[*AuthRouter]bool)
+	for {
+		router, ok := auth.(*AuthRouter)
+		if !ok || router == nil {
+			return auth, nil
+		}
+		if visited[router] {
+			return nil, errors.Errorf("AuthRouter routes %s back to itself", u.Redacted())
+		}
+		visited[router] = true
+		auth = router.Authenticator(u)
+	}
+}
+
+func hostMatches(u *url.URL, host string) bool {
+	if strings.Contains(host, ":") {
+		return strings.EqualFold(u.Host, host)
+	}
+	return strings.EqualFold(u.Hostname(), host)
+}
+
+/* globMatch reports whether s matches pattern, * matches any sequence of characters except /, ** any sequence */
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] != '*' {
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+			continue
+		}
+		crossesSlash := strings.HasPrefix(pattern, "**")
+		pattern = strings.TrimLeft(pattern, "*")
+		for i := 0; i <= len(s); i++ {
+			if globMatch(pattern, s[i:]) {
+				return true
+			}
+			if i < len(s) && s[i] == '/' && !crossesSlash {
+				return false
+			}
+		}
+		return false
+	}
+	return len(s) == 0
+}
+
+/* ChainAuthenticator applies several authenticators to a request in order, e.g. an API key and a signature covering
+it. Only Apply of the chained authenticators is used, challenges and refreshes are not passed on */
+type ChainAuthenticator struct {
+	Authenticators []Authenticator
+}
+
+func NewChainAuthenticator(authenticators ...Authenticator) *ChainAuthenticator {
+	return &ChainAuthenticator{Authenticators: authenticators}
+}
+
+func (ca *ChainAuthenticator) Apply(request *http.Request) error {
+	for _, auth := range ca.Authenticators {
+		if err := auth.Apply(request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package restclient
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAuthRouter(t *testing.T) {
+	apiAuth := NewBearerTokenAuthenticator("api")
+	adminAuth := NewBearerTokenAuthenticator("admin")
+	wildcardAuth := NewBearerTokenAuthenticator("wildcard")
+	publicAuth := NewBearerTokenAuthenticator("public")
+	defaultAuth := NewBearerTokenAuthenticator("default")
+	router := NewAuthRouter(defaultAuth).
+		PathPrefix("", "/admin/", adminAuth).
+		Host("API.example.com", apiAuth).
+		Glob("*.example.com", wildcardAuth).
+		Glob("files.example.org/public/**", publicAuth)
+	routed := func(rawURL string) Authenticator {
+		u, _ := url.Parse(rawURL)
+		return router.Authenticator(u)
+	}
+
+	Convey("TEST authenticators are routed by path prefix, host and glob in the order they were added", t, func() {
+		So(routed("https://api.example.com/admin/users"), ShouldEqual, adminAuth)
+		So(routed("https://api.example.com:8443/v1/tasks"), ShouldEqual, apiAuth)
+		So(routed("https://www.example.com/v1/tasks"), ShouldEqual, wildcardAuth)
+		So(routed("https://example.com/"), ShouldEqual, defaultAuth)
+		So(routed("https://files.example.org/public/a/b.txt"), ShouldEqual, publicAuth)
+		So(routed("https://files.example.org/private/a.txt"), ShouldEqual, defaultAuth)
+	})
+
+	Convey("TEST routers that route back to themselves fail instead of looping", t, func() {
+		self := NewAuthRouter(nil)
+		self.Default = self
+		first := NewAuthRouter(nil)
+		second := NewAuthRouter(first)
+		first.Host("example.com", second)
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com/tasks", nil)
+
+		So(self.Apply(req), ShouldNotBeNil)
+		So(first.Apply(req), ShouldNotBeNil)
+		So(second.Apply(req), ShouldNotBeNil)
+
+		request, _ := RequestBuilder().RawUrl("https://example.com/tasks").Auth(first).Build()
+		reqErr := request.Get()
+		So(reqErr, ShouldNotBeNil)
+		So(reqErr.RequestBuildError(), ShouldBeTrue)
+		So(reqErr.GetMessage(), ShouldContainSubstring, "back to itself")
+	})
+
+	Convey("TEST glob patterns", t, func() {
+		So(globMatch("*.example.com", "a.b.example.com"), ShouldBeTrue)
+		So(globMatch("api.example.com/v1/*", "api.example.com/v1/tasks"), ShouldBeTrue)
+		So(globMatch("api.example.com/v1/*", "api.example.com/v1/tasks/1"), ShouldBeFalse)
+		So(globMatch("api.example.com/**/items", "api.example.com/v1/tasks/items"), ShouldBeTrue)
+		So(globMatch("api.example.com/v1", "api.example.com/v1/tasks"), ShouldBeFalse)
+	})
+}
+
+func TestAuthOnRedirects(t *testing.T) {
+	var received []string
+	record := func(r *http.Request) {
+		received = append(received, r.URL.Path+" "+r.Header.Get("Authorization")+" "+r.Header.Get("X-API-Key"))
+	}
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+	}))
+	defer other.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		switch r.URL.Path {
+		case "/cross-host":
+			http.Redirect(w, r, other.URL+"/landing", http.StatusFound)
+		case "/same-host":
+			http.Redirect(w, r, "/landing", http.StatusFound)
+		}
+	}))
+	defer origin.Close()
+
+	originAuth := NewChainAuthenticator(
+		NewAPIKeyAuthenticator(APIKeyInHeader, "X-API-Key", "key"),
+		NewBearerTokenAuthenticator("origin-token"),
+	)
+	doGet := func(path string, auth Authenticator) RequestError {
+		req, reqErr := RequestBuilder().RawUrl(origin.URL + path).Auth(auth).Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+		return req.Get()
+	}
+
+	Convey("TEST chained authenticators are applied in order and kept on same-host redirects", t, func() {
+		received = nil
+		reqErr := doGet("/same-host", originAuth)
+
+		Convey("Both requests should carry the API key and the token", func() {
+			So(reqErr, ShouldBeNil)
+			So(received, ShouldResemble, []string{"/same-host Bearer origin-token key", "/landing Bearer origin-token key"})
+		})
+	})
+
+	Convey("TEST headers set by the authenticator are dropped when a redirect goes to another host", t, func() {
+		received = nil
+		reqErr := doGet("/cross-host", originAuth)
+
+		Convey("Redirected request should carry no credentials", func() {
+			So(reqErr, ShouldBeNil)
+			So(received, ShouldResemble, []string{"/cross-host Bearer origin-token key", "/landing  "})
+		})
+	})
+
+	Convey("TEST authenticator routed for the new host is applied to redirected requests", t, func() {
+		received = nil
+		router := NewAuthRouter(nil).
+			Host(strings.TrimPrefix(origin.URL, "http://"), originAuth).
+			Host(strings.TrimPrefix(other.URL, "http://"), NewBearerTokenAuthenticator("other-token"))
+		reqErr := doGet("/cross-host", router)
+
+		Convey("Each host should receive its own credentials", func() {
+			So(reqErr, ShouldBeNil)
+			So(received, ShouldResemble, []string{"/cross-host Bearer origin-token key", "/landing Bearer other-token "})
+		})
+	})
+}
+
+func TestApplyAuthenticator(t *testing.T) {
+	Convey("TEST headers whose values were split differently are recorded as changed", t, func() {
+		req, _ := http.NewRequest(http.MethodGet, "https://ysyesilyurt.com/tasks/1", nil)
+		req.Header["X-Auth"] = []string{"Token a", "b"}
+		var applied sync.Map
+		So(applyAuthenticator(NewHeaderAuthenticator("X-Auth", "Token", "a b"), req, &applied), ShouldBeNil)
+
+		_, changed := applied.Load("X-Auth")
+		So(req.Header["X-Auth"], ShouldResemble, []string{"Token a b"})
+		So(changed, ShouldBeTrue)
+	})
+}
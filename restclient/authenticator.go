@@ -1,6 +1,13 @@
 package restclient
 
-import "net/http"
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+)
 
 /* Base Authenticator to authenticate http.Request objects.
  * Implement this interface to provide authentication method to your http.Request */
@@ -8,3 +15,57 @@ type Authenticator interface {
 	/* Apply applies the underlying Authenticator's auth method to provided http.Request by setting the `Authorization` header */
 	Apply(request *http.Request) error
 }
+
+/* ChallengeAuthenticator is an optional extension of Authenticator for challenge-response schemes (e.g. Digest).
+ * Implement this interface if your Authenticator needs to react to the responses of the requests it authenticated */
+type ChallengeAuthenticator interface {
+	Authenticator
+	/* Challenge is called with every response received for a request authenticated by this Authenticator. Returning
+	 * true retries the request once, Apply is called again on the request before it is resent */
+	Challenge(request *http.Request, response *http.Response) (retry bool, err error)
+}
+
+/* RefreshableAuthenticator is an optional extension of Authenticator for credentials that can expire (e.g. OAuth2
+ * access tokens). Implement this interface to get a single transparent retry when a request is rejected with 401 */
+type RefreshableAuthenticator interface {
+	Authenticator
+	/* Refresh is called once a request authenticated by this Authenticator is answered with 401 Unauthorized, the
+	 * request is retried once after Apply is called again. Concurrent refreshes of an Authenticator embedding a
+	 * RefreshGroup are de-duplicated, requests that were rejected with the credentials of an earlier refresh share a
+	 * single call */
+	Refresh(ctx context.Context, response *http.Response) error
+}
+
+/* IdentityAuthenticator is an optional extension of Authenticator for schemes that set different headers on every
+ * request (e.g. signatures, timestamps and nonces). Response caches and request groups tell the users of identical
+ * requests apart by the identity instead of the values of the headers set by Apply */
+type IdentityAuthenticator interface {
+	Authenticator
+	/* Identity returns a value that is the same for all the requests authenticated with the same credentials */
+	Identity() string
+}
+
+/* RejectionDetector is an optional extension of RefreshableAuthenticator for servers that reject credentials with
+ * something other than 401 Unauthorized (e.g. a redirect to a login page). Rejected responses are handled like 401s */
+type RejectionDetector interface {
+	/* Rejected reports whether response means that the credentials of the request were rejected */
+	Rejected(response *http.Response) bool
+}
+
+/* fingerprint returns a short hash of secret to tell credentials apart by in an identity without revealing them */
+func fingerprint(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:8])
+}
+
+/* signerFingerprint returns the fingerprint of the public key of signer, empty if there is no signer */
+func signerFingerprint(signer crypto.Signer) string {
+	if signer == nil {
+		return ""
+	}
+	public, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return ""
+	}
+	return fingerprint(public)
+}
@@ -0,0 +1,81 @@
+package restclient
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"testing"
+)
+
+func TestTokenAuthenticators(t *testing.T) {
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "https://ysyesilyurt.com/tasks/1?tenantId=1", nil)
+		return req
+	}
+
+	Convey("TEST BearerTokenAuthenticator with a rotating token source", t, func() {
+		calls := 0
+		auth := NewBearerTokenSourceAuthenticator(TokenSourceFunc(func() (string, error) {
+			calls++
+			return fmt.Sprintf("token-%d", calls), nil
+		}))
+		first, second := newRequest(), newRequest()
+		errFirst := auth.Apply(first)
+		errSecond := auth.Apply(second)
+
+		Convey("Each request should carry the current token", func() {
+			So(errFirst, ShouldBeNil)
+			So(errSecond, ShouldBeNil)
+			So(first.Header.Get("Authorization"), ShouldEqual, "Bearer token-1")
+			So(second.Header.Get("Authorization"), ShouldEqual, "Bearer token-2")
+		})
+
+		Convey("Authenticator without a token source should fail instead of panicking", func() {
+			So(BearerTokenAuthenticator{}.Apply(newRequest()), ShouldNotBeNil)
+		})
+	})
+
+	Convey("TEST APIKeyAuthenticator placements", t, func() {
+		inHeader, inQuery, inCookie := newRequest(), newRequest(), newRequest()
+		So(NewAPIKeyAuthenticator(APIKeyInHeader, "X-Api-Key", "secret").Apply(inHeader), ShouldBeNil)
+		So(NewAPIKeyAuthenticator(APIKeyInQuery, "api_key", "secret").Apply(inQuery), ShouldBeNil)
+		So(NewAPIKeyAuthenticator(APIKeyInCookie, "api_key", "secret").Apply(inCookie), ShouldBeNil)
+		cookie, err := inCookie.Cookie("api_key")
+
+		Convey("Key should be placed into the header, the query and the cookie respectively", func() {
+			So(inHeader.Header.Get("X-Api-Key"), ShouldEqual, "secret")
+			So(inQuery.URL.Query().Get("api_key"), ShouldEqual, "secret")
+			So(inQuery.URL.Query().Get("tenantId"), ShouldEqual, "1")
+			So(err, ShouldBeNil)
+			So(cookie.Value, ShouldEqual, "secret")
+		})
+
+		Convey("Authenticator without a key source should fail instead of panicking", func() {
+			So(APIKeyAuthenticator{Name: "api_key"}.Apply(newRequest()), ShouldNotBeNil)
+		})
+	})
+
+	Convey("TEST APIKeyAuthenticator replaces its cookie when applied again", t, func() {
+		req := newRequest()
+		req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+		So(NewAPIKeyAuthenticator(APIKeyInCookie, "api_key", "first").Apply(req), ShouldBeNil)
+		So(NewAPIKeyAuthenticator(APIKeyInCookie, "api_key", "second").Apply(req), ShouldBeNil)
+
+		So(req.Header.Get("Cookie"), ShouldEqual, "session=abc; api_key=second")
+	})
+
+	Convey("TEST HeaderAuthenticator with and without a scheme", t, func() {
+		withScheme, withoutScheme := newRequest(), newRequest()
+		So(NewHeaderAuthenticator("Authorization", "Token", "abc").Apply(withScheme), ShouldBeNil)
+		So(NewHeaderAuthenticator("X-Auth", "", "abc").Apply(withoutScheme), ShouldBeNil)
+
+		Convey("Header value should be prefixed with the scheme only if set", func() {
+			So(withScheme.Header.Get("Authorization"), ShouldEqual, "Token abc")
+			So(withoutScheme.Header.Get("X-Auth"), ShouldEqual, "abc")
+		})
+
+		Convey("Authenticator without a token source should fail instead of panicking", func() {
+			So((&HeaderAuthenticator{Header: "X-Auth"}).Apply(newRequest()), ShouldNotBeNil)
+		})
+	})
+}
@@ -1,11 +1,15 @@
 package restclient
 
 import (
+	"github.com/pkg/errors"
 	"net/http"
 )
 
+/* BasicAuthenticator sets the basic auth credentials Username and Password, or if Provider is set the ones it provides
+for the request host */
 type BasicAuthenticator struct {
 	Username, Password string
+	Provider           CredentialProvider
 }
 
 func NewBasicAuthenticator(username, password string) Authenticator {
@@ -15,7 +19,35 @@ func NewBasicAuthenticator(username, password string) Authenticator {
 	}
 }
 
+/* NewBasicProviderAuthenticator creates a BasicAuthenticator that looks up the credentials of each request host in provider */
+func NewBasicProviderAuthenticator(provider CredentialProvider) Authenticator {
+	return &BasicAuthenticator{
+		Provider: provider,
+	}
+}
+
 func (ba BasicAuthenticator) Apply(request *http.Request) error {
-	request.SetBasicAuth(ba.Username, ba.Password)
+	if ba.Provider == nil {
+		request.SetBasicAuth(ba.Username, ba.Password)
+		return nil
+	}
+	credentials, err := providedCredentials(ba.Provider, request)
+	if err != nil {
+		return err
+	}
+	request.SetBasicAuth(credentials.Username, credentials.Password)
 	return nil
 }
+
+/* providedCredentials returns the credentials provider has for the request host, it is an error if there are none */
+func providedCredentials(provider CredentialProvider, request *http.Request) (*Credentials, error) {
+	host := request.URL.Hostname()
+	credentials, err := provider.Credentials(host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get credentials of %s", host)
+	}
+	if credentials == nil {
+		return nil, errors.Errorf("No credentials found for %s", host)
+	}
+	return credentials, nil
+}
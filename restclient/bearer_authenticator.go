@@ -0,0 +1,80 @@
+package restclient
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+/* BearerTokenAuthenticator sets the token of Source as bearer token, or if Provider is set the token it provides for
+the request host (its password if the credentials have no token) */
+type BearerTokenAuthenticator struct {
+	Source   TokenSource
+	Provider CredentialProvider
+}
+
+func NewBearerTokenAuthenticator(token string) Authenticator {
+	return &BearerTokenAuthenticator{
+		Source: StaticTokenSource(token),
+	}
+}
+
+/* NewBearerTokenSourceAuthenticator creates a BearerTokenAuthenticator that fetches the token from source for each request */
+func NewBearerTokenSourceAuthenticator(source TokenSource) Authenticator {
+	return &BearerTokenAuthenticator{
+		Source: source,
+	}
+}
+
+/* NewBearerProviderAuthenticator creates a BearerTokenAuthenticator that looks up the token of each request host in provider */
+func NewBearerProviderAuthenticator(provider CredentialProvider) Authenticator {
+	return &BearerTokenAuthenticator{
+		Provider: provider,
+	}
+}
+
+func (ba BearerTokenAuthenticator) Apply(request *http.Request) error {
+	if ba.Provider != nil {
+		credentials, err := providedCredentials(ba.Provider, request)
+		if err != nil {
+			return err
+		}
+		token := credentials.Token
+		if token == "" {
+			token = credentials.Password
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+	if ba.Source == nil {
+		return errors.New("Token source or credential provider is required to set a bearer token")
+	}
+	token, err := ba.Source.Token()
+	if err != nil {
+		return errors.Wrap(err, "Failed to get bearer token")
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+/* RefreshableBearerAuthenticator is a bearer token Authenticator that refreshes its token source once the token is
+rejected with 401 Unauthorized, see RefreshableAuthenticator */
+type RefreshableBearerAuthenticator struct {
+	Source RefreshableTokenSource
+	*RefreshGroup
+}
+
+func NewRefreshableBearerAuthenticator(source RefreshableTokenSource) Authenticator {
+	return &RefreshableBearerAuthenticator{
+		Source:       source,
+		RefreshGroup: &RefreshGroup{},
+	}
+}
+
+func (ra RefreshableBearerAuthenticator) Apply(request *http.Request) error {
+	return BearerTokenAuthenticator{Source: ra.Source}.Apply(request)
+}
+
+func (ra RefreshableBearerAuthenticator) Refresh(ctx context.Context, response *http.Response) error {
+	return ra.Source.Refresh(ctx)
+}
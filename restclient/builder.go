@@ -131,9 +131,110 @@ func (hrb HttpRequestBuilder) LoggingEnabled(enabled bool) HttpRequestBuilder {
 	return hrb
 }
 
+/* HttpRequestBuilder.ConcurrencyLimiter sets the ConcurrencyLimiter that caps the number of in-flight requests to the
+request's destination. Share the same limiter between requests that should be limited together. Default is no limit. */
+func (hrb HttpRequestBuilder) ConcurrencyLimiter(limiter *ConcurrencyLimiter) HttpRequestBuilder {
+	hrb.hr.limiter = limiter
+	return hrb
+}
+
+/* HttpRequestBuilder.Hedging sets the HedgingPolicy for the request. Hedging only applies to idempotent methods, only
+the winning response is decoded into the ResponseReference. With a ConcurrencyLimiter each extra copy needs a free
+slot of its own. Default is no hedging. */
+func (hrb HttpRequestBuilder) Hedging(policy *HedgingPolicy) HttpRequestBuilder {
+	hrb.hr.hedging = policy
+	return hrb
+}
+
+/* HttpRequestBuilder.VerifyResponse sets the ResponseVerifier that authenticates successful responses before they are
+decoded into the ResponseReference e.g. a MessageSignatureVerifier. Default is no verification. */
+func (hrb HttpRequestBuilder) VerifyResponse(verifier ResponseVerifier) HttpRequestBuilder {
+	hrb.hr.verifier = verifier
+	return hrb
+}
+
+/* HttpRequestBuilder.CookieJar sets the http.CookieJar that cookies of the request are taken from and cookies set by
+its responses are stored in e.g. a CookieJar. Session.RequestBuilder sets the jar of the session. Default is no jar. */
+func (hrb HttpRequestBuilder) CookieJar(jar http.CookieJar) HttpRequestBuilder {
+	hrb.hr.jar = jar
+	return hrb
+}
+
+/* HttpRequestBuilder.Redirects sets the RedirectPolicy deciding whether and how redirects are followed. Default is to
+follow up to 10 redirects. Credentials are never sent along when a redirect goes to another origin. */
+func (hrb HttpRequestBuilder) Redirects(policy *RedirectPolicy) HttpRequestBuilder {
+	hrb.hr.redirects = policy
+	return hrb
+}
+
+/* HttpRequestBuilder.ResponseMetadata sets the ResponseMetadata to fill with the status code, headers, final URL and
+redirect chain of the response. Default is no metadata. */
+func (hrb HttpRequestBuilder) ResponseMetadata(metadata *ResponseMetadata) HttpRequestBuilder {
+	hrb.hr.metadata = metadata
+	return hrb
+}
+
+/* HttpRequestBuilder.Cache sets the ResponseCache that GET requests are served from and their responses are stored in.
+Share the same cache between requests, ResponseMetadata tells whether a response came from the cache. Default is no
+caching. */
+func (hrb HttpRequestBuilder) Cache(cache *ResponseCache) HttpRequestBuilder {
+	hrb.hr.cache = cache
+	return hrb
+}
+
+/* HttpRequestBuilder.TrackETags sets the ETagTracker that remembers the ETag of GET, PUT and PATCH responses and sends it
+as If-Match with the following PUT, PATCH and DELETE requests to the same URL. Share the same tracker between the
+requests of a resource. Default is no tracking. */
+func (hrb HttpRequestBuilder) TrackETags(tracker *ETagTracker) HttpRequestBuilder {
+	hrb.hr.etags = tracker
+	return hrb
+}
+
+/* HttpRequestBuilder.Dedup sets the RequestGroup that de-duplicates concurrent identical GET and HEAD requests, so that
+they share a single call. Share the same group between requests. Default is no de-duplication. */
+func (hrb HttpRequestBuilder) Dedup(group *RequestGroup) HttpRequestBuilder {
+	hrb.hr.group = group
+	return hrb
+}
+
+/* HttpRequestBuilder.Stream sets the JSONStream that decodes the response body record by record, as a JSON array or
+NDJSON, instead of reading it into the ResponseReference at once. Use it for large responses. The Timeout covers
+reading the whole body, set it to Zero (0) and cancel the context of the request instead for long streams. Streamed
+requests are neither de-duplicated nor cached, as that reads the whole body into memory. Default is no streaming. */
+func (hrb HttpRequestBuilder) Stream(stream *JSONStream) HttpRequestBuilder {
+	hrb.hr.stream = stream
+	return hrb
+}
+
+/* HttpRequestBuilder.UploadProgress sets the ProgressFunc that is called as the request body is sent. The total is
+unknown (-1) unless the length of the body is known e.g. for BodyJson. Default is no progress reporting. */
+func (hrb HttpRequestBuilder) UploadProgress(progress ProgressFunc) HttpRequestBuilder {
+	hrb.hr.uploadProgress = progress
+	return hrb
+}
+
+/* HttpRequestBuilder.DownloadProgress sets the ProgressFunc that is called as the response body is read, the total is
+the Content-Length of the response (-1 if unknown). Default is no progress reporting. */
+func (hrb HttpRequestBuilder) DownloadProgress(progress ProgressFunc) HttpRequestBuilder {
+	hrb.hr.downloadProgress = progress
+	return hrb
+}
+
+/* HttpRequestBuilder.BandwidthLimit sets the BandwidthLimiter that throttles the request and response bodies. Share the
+same limiter between requests that should be limited together, keep in mind that the timeout still applies to the
+throttled transfer. Default is no limit. */
+func (hrb HttpRequestBuilder) BandwidthLimit(limiter *BandwidthLimiter) HttpRequestBuilder {
+	hrb.hr.bandwidth = limiter
+	return hrb
+}
+
 func (hrb HttpRequestBuilder) Build() (*HttpRequest, RequestError) {
 	var err error
 
+	if hrb.hr.stream != nil && hrb.hr.stream.Handle == nil {
+		return nil, NewRequestBuildError(InvalidRequestErr, errors.New("JSONStream requires a Handle"))
+	}
+
 	if hrb.hr.request == nil {
 		err = validateRequiredRequestFields(hrb.ri)
 		if err != nil {
@@ -0,0 +1,538 @@
+package restclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* heuristicFreshnessFraction is the fraction of the time since Last-Modified a response without explicit freshness
+is considered fresh for (RFC 9111 section 4.2.2) */
+const heuristicFreshnessFraction = 10
+
+/* ResponseCache is an opt-in RFC 9111 cache for GET requests, set it with HttpRequestBuilder.Cache. Fresh responses
+are served from Store without a request, stale ones are revalidated with If-None-Match and If-Modified-Since. It
+honours the Cache-Control directives max-age, s-maxage, no-store, no-cache, private and public of responses,
+max-age, min-fresh, no-store and no-cache of requests, Expires and Vary. Successful POST, PUT, PATCH and DELETE
+requests invalidate the cached response of their URL.
+- Shared: the cache is shared between users, private responses and responses to requests with an Authorization header
+  (unless public, s-maxage or must-revalidate) are not stored. Otherwise responses are stored per credentials, the
+  requests sent with other credentials (Authorization, Cookie, the headers of the Authenticator or the cookie jar) or
+  without any do not see them
+- StaleIfError: serve a stale response up to this long past its freshness when the request fails with a connection
+  error, a timeout or a 500, 502, 503 or 504 response. The stale-if-error directive of a response (RFC 5861) can
+  allow longer. ResponseMetadata marks the response Stale and carries the StaleErr it was served in place of
+- StaleWhileRevalidate: serve a stale response up to this long past its freshness right away and revalidate it in the
+  background. The stale-while-revalidate directive of a response (RFC 5861) can allow longer
+- Now: clock of the cache, defaults to time.Now */
+type ResponseCache struct {
+	Store                              CacheStore
+	Shared                             bool
+	StaleIfError, StaleWhileRevalidate time.Duration
+	Now                                func() time.Time
+
+	revalidating sync.Map // keys of the responses being revalidated in the background
+}
+
+/* NewResponseCache creates a ResponseCache storing responses in store, nil means a NewLRUCacheStore(0) */
+func NewResponseCache(store CacheStore) *ResponseCache {
+	if store == nil {
+		store = NewLRUCacheStore(0)
+	}
+	return &ResponseCache{Store: store}
+}
+
+/* cacheStatus is how a response was served by a ResponseCache */
+type cacheStatus struct {
+	fromCache, revalidated, stale bool
+	staleErr                      RequestError // error a stale response was served in place of
+}
+
+/* cacheSender sends the requests of a ResponseCache. send sends the request being served, sendInBackground a copy of it
+to revalidate a stale response in the background and requestError converts a failed result into a RequestError */
+type cacheSender struct {
+	send             func() (*http.Response, error)
+	sendInBackground func(req *http.Request) (*http.Response, error)
+	requestError     func(resp *http.Response, err error) RequestError
+	identity         string // hash of the credentials of the request, see credentialIdentity
+}
+
+/* do returns a fresh cached response of req, or sends req and stores its response if it can be cached. Stale responses
+are served while they are revalidated in the background, or in place of an error, within their stale windows */
+func (rc *ResponseCache) do(req *http.Request, sender cacheSender) (*http.Response, cacheStatus, error) {
+	if req.Method != http.MethodGet {
+		resp, err := sender.send()
+		if err == nil && req.Method != http.MethodHead && req.Method != http.MethodOptions && resp.StatusCode < 400 {
+			rc.invalidate(req.URL, resp, sender.identity)
+		}
+		return resp, cacheStatus{}, err
+	}
+	requestDirectives := parseCacheControl(req.Header)
+	if _, noStore := requestDirectives["no-store"]; noStore || hasConditionalHeaders(req.Header) {
+		resp, err := sender.send()
+		return resp, cacheStatus{}, err
+	}
+
+	key := rc.key(req.URL, sender.identity)
+	entry := rc.load(key)
+	if entry != nil && !entry.matchesVary(req) {
+		entry = nil
+	}
+	now := rc.now()
+	if entry != nil && rc.isFresh(entry, requestDirectives, now) {
+		return entry.response(req, now), cacheStatus{fromCache: true}, nil
+	}
+	if entry != nil && sender.sendInBackground != nil && rc.isUsableStale(entry, rc.staleWhileRevalidate(entry), now) {
+		rc.revalidateInBackground(req, key, entry, sender.sendInBackground)
+		return entry.response(req, now), cacheStatus{fromCache: true, stale: true}, nil
+	}
+
+	resp, status, err := rc.fetch(req, key, entry, sender.send)
+	if entry != nil && isStaleIfErrorResult(resp, err) && rc.isUsableStale(entry, rc.staleIfError(entry), rc.now()) {
+		staleErr := sender.requestError(resp, err)
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		return entry.response(req, rc.now()), cacheStatus{fromCache: true, stale: true, staleErr: staleErr}, nil
+	}
+	return resp, status, err
+}
+
+/* fetch sends req, conditionally if entry has validators, and stores its response if it can be cached */
+func (rc *ResponseCache) fetch(req *http.Request, key string, entry *CachedResponse, send func() (*http.Response, error)) (*http.Response, cacheStatus, error) {
+	// The conditional headers are only sent this time
+	if entry != nil {
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+			defer req.Header.Del("If-None-Match")
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+			defer req.Header.Del("If-Modified-Since")
+		}
+	}
+	requestTime := rc.now()
+	resp, err := send()
+	if err != nil {
+		return resp, cacheStatus{}, err
+	}
+	responseTime := rc.now()
+	redirected := resp.Request != nil && resp.Request.Response != nil
+
+	if entry != nil && resp.StatusCode == http.StatusNotModified && !redirected {
+		_ = resp.Body.Close()
+		updated := entry.revalidated(resp.Header, requestTime, responseTime)
+		rc.save(key, updated)
+		return updated.response(req, responseTime), cacheStatus{fromCache: true, revalidated: true}, nil
+	}
+	if redirected || !rc.isStorable(req, resp) {
+		return resp, cacheStatus{}, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, cacheStatus{}, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	rc.save(key, &CachedResponse{
+		StatusCode:    resp.StatusCode,
+		Header:        resp.Header.Clone(),
+		Body:          body,
+		RequestHeader: varyHeader(req, resp.Header),
+		RequestTime:   requestTime,
+		ResponseTime:  responseTime,
+	})
+	return resp, cacheStatus{}, nil
+}
+
+/* revalidateInBackground revalidates entry with a copy of req unless it is already being revalidated */
+func (rc *ResponseCache) revalidateInBackground(req *http.Request, key string, entry *CachedResponse, send func(req *http.Request) (*http.Response, error)) {
+	if _, revalidating := rc.revalidating.LoadOrStore(key, true); revalidating {
+		return
+	}
+	background := req.Clone(context.Background())
+	go func() {
+		defer rc.revalidating.Delete(key)
+		resp, _, err := rc.fetch(background, key, entry, func() (*http.Response, error) {
+			return send(background)
+		})
+		if err != nil {
+			errorLogger.Printf("Failed to revalidate cached response, [key]: %s, [err]: %v", key, err)
+			return
+		}
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+}
+
+func (rc *ResponseCache) now() time.Time {
+	if rc.Now != nil {
+		return rc.Now()
+	}
+	return time.Now()
+}
+
+/* load returns the cached response of key, store failures are logged and treated as a miss */
+func (rc *ResponseCache) load(key string) *CachedResponse {
+	entry, err := rc.Store.Load(key)
+	if err != nil {
+		errorLogger.Printf("Failed to load cached response, [key]: %s, [err]: %v", key, err)
+		return nil
+	}
+	return entry
+}
+
+func (rc *ResponseCache) save(key string, entry *CachedResponse) {
+	if err := rc.Store.Save(key, entry); err != nil {
+		errorLogger.Printf("Failed to cache response, [key]: %s, [err]: %v", key, err)
+	}
+}
+
+/* invalidate deletes the cached responses of u and of the same-origin Location and Content-Location of resp, the ones
+stored without credentials and the ones stored with the credentials of identity */
+func (rc *ResponseCache) invalidate(u *url.URL, resp *http.Response, identity string) {
+	targets := []*url.URL{u}
+	for _, name := range []string{"Location", "Content-Location"} {
+		if value := resp.Header.Get(name); value != "" {
+			if target, err := u.Parse(value); err == nil && sameOrigin(u, target) {
+				targets = append(targets, target)
+			}
+		}
+	}
+	for _, target := range targets {
+		keys := []string{rc.key(target, "")}
+		if identity != "" && !rc.Shared {
+			keys = append(keys, rc.key(target, identity))
+		}
+		for _, key := range keys {
+			if err := rc.Store.Delete(key); err != nil {
+				errorLogger.Printf("Failed to invalidate cached response, [url]: %s, [err]: %v", target, err)
+			}
+		}
+	}
+}
+
+/* isFresh reports whether entry can be served without revalidation (RFC 9111 section 4.2) */
+func (rc *ResponseCache) isFresh(entry *CachedResponse, requestDirectives map[string]string, now time.Time) bool {
+	responseDirectives := parseCacheControl(entry.Header)
+	if _, noCache := responseDirectives["no-cache"]; noCache {
+		return false
+	}
+	if _, noCache := requestDirectives["no-cache"]; noCache {
+		return false
+	}
+	lifetime, age := rc.freshnessLifetime(entry, responseDirectives), entry.age(now)
+	if maxAge, ok := directiveSeconds(requestDirectives, "max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := directiveSeconds(requestDirectives, "min-fresh"); ok && lifetime-age < minFresh {
+		return false
+	}
+	return age < lifetime
+}
+
+/* freshnessLifetime returns how long entry is fresh for after it was generated (RFC 9111 section 4.2.1) */
+func (rc *ResponseCache) freshnessLifetime(entry *CachedResponse, directives map[string]string) time.Duration {
+	if rc.Shared {
+		if sMaxAge, ok := directiveSeconds(directives, "s-maxage"); ok {
+			return sMaxAge
+		}
+	}
+	if maxAge, ok := directiveSeconds(directives, "max-age"); ok {
+		return maxAge
+	}
+	date := entry.date()
+	if expires := entry.Header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return expiresAt.Sub(date)
+	}
+	if lastModified, err := http.ParseTime(entry.Header.Get("Last-Modified")); err == nil && date.After(lastModified) {
+		if _, public := directives["public"]; public || isHeuristicallyCacheable(entry.StatusCode) {
+			return date.Sub(lastModified) / heuristicFreshnessFraction
+		}
+	}
+	return 0
+}
+
+/* isUsableStale reports whether the stale entry can still be served within a stale window past its freshness.
+Responses that must be revalidated are never served stale (RFC 9111 section 4.2.4) */
+func (rc *ResponseCache) isUsableStale(entry *CachedResponse, window time.Duration, now time.Time) bool {
+	directives := parseCacheControl(entry.Header)
+	for _, name := range []string{"must-revalidate", "proxy-revalidate", "no-cache"} {
+		if _, ok := directives[name]; ok {
+			return false
+		}
+	}
+	if _, ok := directives["s-maxage"]; ok && rc.Shared {
+		return false
+	}
+	return window > 0 && entry.age(now) < rc.freshnessLifetime(entry, directives)+window
+}
+
+/* staleIfError returns how long past its freshness entry can be served in place of an error, the longer of the
+stale-if-error directive of the response (RFC 5861) and the StaleIfError policy */
+func (rc *ResponseCache) staleIfError(entry *CachedResponse) time.Duration {
+	window, _ := directiveSeconds(parseCacheControl(entry.Header), "stale-if-error")
+	if rc.StaleIfError > window {
+		return rc.StaleIfError
+	}
+	return window
+}
+
+/* staleWhileRevalidate returns how long past its freshness entry can be served while it is revalidated, the longer of
+the stale-while-revalidate directive of the response (RFC 5861) and the StaleWhileRevalidate policy */
+func (rc *ResponseCache) staleWhileRevalidate(entry *CachedResponse) time.Duration {
+	window, _ := directiveSeconds(parseCacheControl(entry.Header), "stale-while-revalidate")
+	if rc.StaleWhileRevalidate > window {
+		return rc.StaleWhileRevalidate
+	}
+	return window
+}
+
+/* isStaleIfErrorResult reports whether a stale response can be served in place of the result of a request, that is a
+connection error, a timeout or a 500, 502, 503 or 504 response */
+func isStaleIfErrorResult(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+/* isStorable reports whether the response of req can be stored (RFC 9111 section 3) */
+func (rc *ResponseCache) isStorable(req *http.Request, resp *http.Response) bool {
+	directives := parseCacheControl(resp.Header)
+	_, noStore := directives["no-store"]
+	_, private := directives["private"]
+	_, public := directives["public"]
+	_, sMaxAge := directives["s-maxage"]
+	_, maxAge := directives["max-age"]
+	_, mustRevalidate := directives["must-revalidate"]
+	switch {
+	case noStore, !isHeuristicallyCacheable(resp.StatusCode) && !maxAge && !sMaxAge && resp.Header.Get("Expires") == "":
+		return false
+	case rc.Shared && private:
+		return false
+	case rc.Shared && req.Header.Get("Authorization") != "" && !public && !sMaxAge && !mustRevalidate:
+		return false
+	}
+	for _, name := range varyNames(resp.Header) {
+		if name == "*" {
+			return false
+		}
+	}
+	return maxAge || sMaxAge || resp.Header.Get("Expires") != "" || resp.Header.Get("ETag") != "" ||
+		resp.Header.Get("Last-Modified") != ""
+}
+
+/* response returns a http.Response of the cached response for req with its current Age */
+func (cr *CachedResponse) response(req *http.Request, now time.Time) *http.Response {
+	header := cr.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(cr.age(now)/time.Second)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", cr.StatusCode, http.StatusText(cr.StatusCode)),
+		StatusCode:    cr.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(cr.Body)),
+		ContentLength: int64(len(cr.Body)),
+		Request:       req,
+	}
+}
+
+/* revalidated returns a copy of the cached response updated with the header of a 304 response (RFC 9111 section 4.3.4) */
+func (cr *CachedResponse) revalidated(header http.Header, requestTime, responseTime time.Time) *CachedResponse {
+	updated := *cr
+	updated.Header = cr.Header.Clone()
+	for name, values := range header {
+		if name != "Content-Length" {
+			updated.Header[name] = values
+		}
+	}
+	updated.RequestTime, updated.ResponseTime = requestTime, responseTime
+	return &updated
+}
+
+/* date returns the Date of the response, or the time it was received if it has none */
+func (cr *CachedResponse) date() time.Time {
+	if date, err := http.ParseTime(cr.Header.Get("Date")); err == nil {
+		return date
+	}
+	return cr.ResponseTime
+}
+
+/* age returns the current age of the response (RFC 9111 section 4.2.3) */
+func (cr *CachedResponse) age(now time.Time) time.Duration {
+	apparentAge := cr.ResponseTime.Sub(cr.date())
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+	ageValue, _ := strconv.Atoi(cr.Header.Get("Age"))
+	correctedAge := time.Duration(ageValue)*time.Second + cr.ResponseTime.Sub(cr.RequestTime)
+	if correctedAge > apparentAge {
+		apparentAge = correctedAge
+	}
+	return apparentAge + now.Sub(cr.ResponseTime)
+}
+
+/* matchesVary reports whether req has the same values as the stored request for the headers the response varies on */
+func (cr *CachedResponse) matchesVary(req *http.Request) bool {
+	for _, name := range varyNames(cr.Header) {
+		if strings.Join(req.Header.Values(name), ",") != strings.Join(cr.RequestHeader.Values(name), ",") {
+			return false
+		}
+	}
+	return true
+}
+
+/* varyHeader returns the headers of req that the response with header varies on */
+func varyHeader(req *http.Request, header http.Header) http.Header {
+	names := varyNames(header)
+	if len(names) == 0 {
+		return nil
+	}
+	vary := make(http.Header, len(names))
+	for _, name := range names {
+		if values := req.Header.Values(name); len(values) != 0 {
+			vary[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	return vary
+}
+
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+/* parseCacheControl returns the Cache-Control directives of header by lower case name, with unquoted values */
+func parseCacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, argument := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, argument = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				directives[name] = argument
+			}
+		}
+	}
+	return directives
+}
+
+/* directiveSeconds returns the delta-seconds value of a directive, invalid values are treated as zero */
+func directiveSeconds(directives map[string]string, name string) (time.Duration, bool) {
+	value, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, true
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+/* isHeuristicallyCacheable reports whether responses with the status code can be cached without explicit freshness */
+func isHeuristicallyCacheable(statusCode int) bool {
+	switch statusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusPermanentRedirect, http.StatusNotFound, http.StatusMethodNotAllowed,
+		http.StatusGone, http.StatusRequestURITooLong, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+func hasConditionalHeaders(header http.Header) bool {
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "Range"} {
+		if header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+/* key returns the key of the response of u. A private cache keeps the responses of each identity apart, a shared cache
+only stores responses meant for everyone */
+func (rc *ResponseCache) key(u *url.URL, identity string) string {
+	if identity == "" || rc.Shared {
+		return http.MethodGet + " " + u.String()
+	}
+	return http.MethodGet + " " + u.String() + " " + identity
+}
+
+/* credentialIdentity returns a hash of the credentials req is sent with: the identity of auth if it is an
+IdentityAuthenticator, otherwise the values of the headers it set (authHeaders), the credential headers set on req and
+the cookies jar sends along. It is empty for requests without credentials */
+func credentialIdentity(req *http.Request, auth Authenticator, authHeaders *sync.Map, jar http.CookieJar) string {
+	identityAuth, hasIdentity := auth.(IdentityAuthenticator)
+	names := []string{"Authorization", "Proxy-Authorization", "Cookie"}
+	if !hasIdentity {
+		authHeaders.Range(func(name, _ interface{}) bool {
+			names = append(names, http.CanonicalHeaderKey(name.(string)))
+			return true
+		})
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	credentialed := false
+	if hasIdentity {
+		_, _ = fmt.Fprintf(hash, "identity: %T %s\n", auth, identityAuth.Identity())
+		credentialed = true
+	}
+	for i, name := range names {
+		if i > 0 && name == names[i-1] {
+			continue
+		}
+		// The values of the headers set by an IdentityAuthenticator change with every request
+		if _, setByAuth := authHeaders.Load(name); hasIdentity && setByAuth {
+			continue
+		}
+		if values := req.Header.Values(name); len(values) > 0 {
+			_, _ = fmt.Fprintf(hash, "%s: %s\n", name, strings.Join(values, ", "))
+			credentialed = true
+		}
+	}
+	if jar != nil {
+		cookies := jar.Cookies(req.URL)
+		sort.Slice(cookies, func(i, j int) bool { return cookies[i].Name < cookies[j].Name })
+		for _, cookie := range cookies {
+			_, _ = fmt.Fprintf(hash, "jar: %s=%s\n", cookie.Name, cookie.Value)
+			credentialed = true
+		}
+	}
+	if !credentialed {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,156 @@
+package restclient
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultLRUCacheEntries = 1000
+
+/* CachedResponse is a response stored by a ResponseCache */
+type CachedResponse struct {
+	StatusCode    int         `json:"statusCode"`
+	Header        http.Header `json:"header"`
+	Body          []byte      `json:"body"`
+	RequestHeader http.Header `json:"requestHeader,omitempty"` // request headers the response varies on (Vary)
+	RequestTime   time.Time   `json:"requestTime"`             // time the request was sent
+	ResponseTime  time.Time   `json:"responseTime"`            // time the response was received
+}
+
+/* CacheStore stores the responses of a ResponseCache. Implement it to keep responses in e.g. a shared cache server */
+type CacheStore interface {
+	/* Load returns the response stored with key, nil if there is none */
+	Load(key string) (*CachedResponse, error)
+	Save(key string, response *CachedResponse) error
+	Delete(key string) error
+}
+
+/* LRUCacheStore is a CacheStore that keeps up to MaxEntries (defaults to 1000) responses in memory, the least recently
+used response is evicted once it is full */
+type LRUCacheStore struct {
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+}
+
+type lruCacheEntry struct {
+	key      string
+	response *CachedResponse
+}
+
+/* NewLRUCacheStore creates a LRUCacheStore, maxEntries defaults to 1000 */
+func NewLRUCacheStore(maxEntries int) *LRUCacheStore {
+	if maxEntries <= 0 {
+		maxEntries = defaultLRUCacheEntries
+	}
+	return &LRUCacheStore{
+		MaxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (ls *LRUCacheStore) Load(key string) (*CachedResponse, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	element, ok := ls.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	ls.order.MoveToFront(element)
+	response := *element.Value.(*lruCacheEntry).response
+	return &response, nil
+}
+
+func (ls *LRUCacheStore) Save(key string, response *CachedResponse) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	// The store may be created without NewLRUCacheStore
+	if ls.entries == nil {
+		ls.entries, ls.order = make(map[string]*list.Element), list.New()
+	}
+	saved := *response
+	if element, ok := ls.entries[key]; ok {
+		element.Value.(*lruCacheEntry).response = &saved
+		ls.order.MoveToFront(element)
+		return nil
+	}
+	ls.entries[key] = ls.order.PushFront(&lruCacheEntry{key: key, response: &saved})
+	maxEntries := ls.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultLRUCacheEntries
+	}
+	for ls.order.Len() > maxEntries {
+		oldest := ls.order.Back()
+		ls.order.Remove(oldest)
+		delete(ls.entries, oldest.Value.(*lruCacheEntry).key)
+	}
+	return nil
+}
+
+func (ls *LRUCacheStore) Delete(key string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if element, ok := ls.entries[key]; ok {
+		ls.order.Remove(element)
+		delete(ls.entries, key)
+	}
+	return nil
+}
+
+/* DiskCacheStore is a CacheStore that keeps each response as a JSON file in Dir, so that the cache survives restarts */
+type DiskCacheStore struct {
+	Dir string
+}
+
+func NewDiskCacheStore(dir string) DiskCacheStore {
+	return DiskCacheStore{Dir: dir}
+}
+
+func (ds DiskCacheStore) Load(key string) (*CachedResponse, error) {
+	path := ds.path(key)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read cached response %s", path)
+	}
+	var response CachedResponse
+	if err = json.Unmarshal(content, &response); err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode cached response %s", path)
+	}
+	return &response, nil
+}
+
+func (ds DiskCacheStore) Save(key string, response *CachedResponse) error {
+	content, err := json.Marshal(response)
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode cached response")
+	}
+	return writeFileAtomic(ds.path(key), content)
+}
+
+func (ds DiskCacheStore) Delete(key string) error {
+	if err := os.Remove(ds.path(key)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "Failed to delete cached response %s", ds.path(key))
+	}
+	return nil
+}
+
+/* path returns the file of key, keys are hashed as they are URLs */
+func (ds DiskCacheStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(ds.Dir, hex.EncodeToString(sum[:])+".json")
+}
@@ -0,0 +1,439 @@
+package restclient
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/* testCachingServer counts the requests of each path and answers them with the caching headers of the path */
+type testCachingServer struct {
+	mu           sync.Mutex
+	hits         map[string]int
+	lastModified time.Time
+	failing      bool          // answer with 503 Service Unavailable
+	delay        time.Duration // delay of the responses
+	now          func() time.Time
+}
+
+func (s *testCachingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.hits[r.URL.Path]++
+	hit, failing, delay := s.hits[r.URL.Path], s.failing, s.delay
+	s.mu.Unlock()
+	time.Sleep(delay)
+	if s.now != nil {
+		w.Header().Set("Date", s.now().UTC().Format(http.TimeFormat))
+	}
+	if failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("maintenance"))
+		return
+	}
+
+	switch r.URL.Path {
+	case "/max-age":
+		w.Header().Set("Cache-Control", "max-age=60")
+	case "/expires":
+		w.Header().Set("Expires", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	case "/etag":
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	case "/last-modified":
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Last-Modified", s.lastModified.UTC().Format(http.TimeFormat))
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !s.lastModified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	case "/heuristic":
+		w.Header().Set("Last-Modified", s.lastModified.UTC().Format(http.TimeFormat))
+	case "/no-store":
+		w.Header().Set("Cache-Control", "no-store, max-age=60")
+	case "/private":
+		w.Header().Set("Cache-Control", "private, max-age=60")
+	case "/stale-if-error":
+		w.Header().Set("Cache-Control", "max-age=60, stale-if-error=300")
+	case "/stale-while-revalidate":
+		w.Header().Set("Cache-Control", "max-age=60, stale-while-revalidate=120")
+	case "/must-revalidate":
+		w.Header().Set("Cache-Control", "max-age=60, must-revalidate")
+	case "/vary":
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+	}
+	_ = json.NewEncoder(w).Encode(testHttpResponse{StatusCode: http.StatusOK, Data: fmt.Sprintf("%s %d", r.URL.Path, hit)})
+}
+
+func TestResponseCache(t *testing.T) {
+	server := &testCachingServer{hits: make(map[string]int), lastModified: time.Now().Add(-time.Hour).Truncate(time.Second)}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	var offset time.Duration
+	newCache := func(store CacheStore) *ResponseCache {
+		cache := NewResponseCache(store)
+		cache.Now = func() time.Time { return time.Now().Add(offset) }
+		return cache
+	}
+	doRequest := func(cache *ResponseCache, method, path string, header http.Header) (string, ResponseMetadata) {
+		var testResponse testHttpResponse
+		var metadata ResponseMetadata
+		if header == nil {
+			header = http.Header{}
+		}
+		req, reqErr := RequestBuilder().
+			RawUrl(ts.URL + path).
+			Header(&header).
+			Cache(cache).
+			ResponseMetadata(&metadata).
+			ResponseReference(&testResponse).
+			Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+		if method == http.MethodPost {
+			reqErr = req.Post()
+		} else {
+			reqErr = req.Get()
+		}
+		if reqErr != nil {
+			log.Fatalf("request failed, %v", reqErr)
+		}
+		return fmt.Sprint(testResponse.Data), metadata
+	}
+
+	Convey("TEST fresh responses are served from the cache until they expire", t, func() {
+		offset, server.hits = 0, make(map[string]int)
+		cache := newCache(nil)
+		first, firstMetadata := doRequest(cache, http.MethodGet, "/max-age", nil)
+		second, secondMetadata := doRequest(cache, http.MethodGet, "/expires", nil)
+		cachedFirst, cachedMetadata := doRequest(cache, http.MethodGet, "/max-age", nil)
+		cachedSecond, _ := doRequest(cache, http.MethodGet, "/expires", nil)
+		offset = 2 * time.Minute
+		expiredFirst, expiredMetadata := doRequest(cache, http.MethodGet, "/max-age", nil)
+		expiredSecond, _ := doRequest(cache, http.MethodGet, "/expires", nil)
+
+		Convey("Cached responses should fill the response reference and be marked in the metadata", func() {
+			So(first, ShouldEqual, "/max-age 1")
+			So(firstMetadata.FromCache, ShouldBeFalse)
+			So(second, ShouldEqual, "/expires 1")
+			So(cachedFirst, ShouldEqual, "/max-age 1")
+			So(cachedSecond, ShouldEqual, "/expires 1")
+			So(cachedMetadata.FromCache, ShouldBeTrue)
+			So(cachedMetadata.Revalidated, ShouldBeFalse)
+			So(secondMetadata.FromCache, ShouldBeFalse)
+		})
+
+		Convey("Expired responses should be fetched again", func() {
+			So(expiredFirst, ShouldEqual, "/max-age 2")
+			So(expiredSecond, ShouldEqual, "/expires 2")
+			So(expiredMetadata.FromCache, ShouldBeFalse)
+		})
+	})
+
+	Convey("TEST stale responses are revalidated with their validators", t, func() {
+		offset, server.hits = 0, make(map[string]int)
+		cache := newCache(nil)
+		_, _ = doRequest(cache, http.MethodGet, "/etag", nil)
+		etagResponse, etagMetadata := doRequest(cache, http.MethodGet, "/etag", nil)
+		_, _ = doRequest(cache, http.MethodGet, "/last-modified", nil)
+		lastModifiedResponse, lastModifiedMetadata := doRequest(cache, http.MethodGet, "/last-modified", nil)
+		_, _ = doRequest(cache, http.MethodGet, "/heuristic", nil)
+		heuristicResponse, heuristicMetadata := doRequest(cache, http.MethodGet, "/heuristic", nil)
+
+		Convey("Unchanged responses should be served from the cache once the server confirms them", func() {
+			So(etagResponse, ShouldEqual, "/etag 1")
+			So(etagMetadata.FromCache, ShouldBeTrue)
+			So(etagMetadata.Revalidated, ShouldBeTrue)
+			So(etagMetadata.StatusCode, ShouldEqual, http.StatusOK)
+			So(server.hits["/etag"], ShouldEqual, 2)
+			So(lastModifiedResponse, ShouldEqual, "/last-modified 1")
+			So(lastModifiedMetadata.Revalidated, ShouldBeTrue)
+			So(server.hits["/last-modified"], ShouldEqual, 2)
+		})
+
+		Convey("Responses with only Last-Modified should be fresh for a tenth of their age", func() {
+			So(heuristicResponse, ShouldEqual, "/heuristic 1")
+			So(heuristicMetadata.FromCache, ShouldBeTrue)
+			So(heuristicMetadata.Revalidated, ShouldBeFalse)
+		})
+	})
+
+	Convey("TEST no-store, request directives, private responses and Vary", t, func() {
+		offset, server.hits = 0, make(map[string]int)
+		cache := newCache(nil)
+		_, _ = doRequest(cache, http.MethodGet, "/no-store", nil)
+		noStore, _ := doRequest(cache, http.MethodGet, "/no-store", nil)
+		_, _ = doRequest(cache, http.MethodGet, "/max-age", nil)
+		noCache, noCacheMetadata := doRequest(cache, http.MethodGet, "/max-age", http.Header{"Cache-Control": []string{"no-cache"}})
+		_, _ = doRequest(cache, http.MethodGet, "/private", nil)
+		privateResponse, _ := doRequest(cache, http.MethodGet, "/private", nil)
+		sharedCache := newCache(nil)
+		sharedCache.Shared = true
+		_, _ = doRequest(sharedCache, http.MethodGet, "/private", nil)
+		sharedResponse, _ := doRequest(sharedCache, http.MethodGet, "/private", nil)
+		english, _ := doRequest(cache, http.MethodGet, "/vary", http.Header{"Accept-Language": []string{"en"}})
+		german, _ := doRequest(cache, http.MethodGet, "/vary", http.Header{"Accept-Language": []string{"de"}})
+		cachedGerman, _ := doRequest(cache, http.MethodGet, "/vary", http.Header{"Accept-Language": []string{"de"}})
+
+		Convey("Directives should be honoured", func() {
+			So(noStore, ShouldEqual, "/no-store 2")
+			So(noCache, ShouldEqual, fmt.Sprintf("/max-age %d", server.hits["/max-age"]))
+			So(noCacheMetadata.FromCache, ShouldBeFalse)
+			So(privateResponse, ShouldEqual, "/private 1")
+			So(sharedResponse, ShouldEqual, "/private 3")
+		})
+
+		Convey("Responses should only be served to requests with the same values of the Vary headers", func() {
+			So(english, ShouldEqual, "/vary 1")
+			So(german, ShouldEqual, "/vary 2")
+			So(cachedGerman, ShouldEqual, "/vary 2")
+		})
+	})
+
+	Convey("TEST responses to requests with credentials are only served with the same credentials", t, func() {
+		offset, server.hits = 0, make(map[string]int)
+		cache := newCache(nil)
+		alice := http.Header{"Authorization": []string{"Bearer alice"}}
+		bob := http.Header{"Authorization": []string{"Bearer bob"}}
+		aliceResponse, _ := doRequest(cache, http.MethodGet, "/max-age", alice)
+		bobResponse, bobMetadata := doRequest(cache, http.MethodGet, "/max-age", bob)
+		anonymousResponse, _ := doRequest(cache, http.MethodGet, "/max-age", http.Header{"Cookie": []string{"session=carol"}})
+		cachedAlice, cachedMetadata := doRequest(cache, http.MethodGet, "/max-age", alice)
+		_, _ = doRequest(cache, http.MethodPost, "/max-age", alice)
+		afterPost, afterPostMetadata := doRequest(cache, http.MethodGet, "/max-age", alice)
+
+		Convey("Each identity should get its own response", func() {
+			So(aliceResponse, ShouldEqual, "/max-age 1")
+			So(bobResponse, ShouldEqual, "/max-age 2")
+			So(bobMetadata.FromCache, ShouldBeFalse)
+			So(anonymousResponse, ShouldEqual, "/max-age 3")
+			So(cachedAlice, ShouldEqual, "/max-age 1")
+			So(cachedMetadata.FromCache, ShouldBeTrue)
+			So(afterPostMetadata.FromCache, ShouldBeFalse)
+			So(afterPost, ShouldEqual, "/max-age 5")
+		})
+	})
+
+	Convey("TEST responses to signed requests are served to requests signed with the same key", t, func() {
+		offset, server.hits = 0, make(map[string]int)
+		cache := newCache(nil)
+		doSigned := func(auth Authenticator) (string, ResponseMetadata) {
+			var testResponse testHttpResponse
+			var metadata ResponseMetadata
+			req, reqErr := RequestBuilder().RawUrl(ts.URL + "/private").Auth(auth).Cache(cache).
+				ResponseMetadata(&metadata).ResponseReference(&testResponse).Build()
+			if reqErr != nil {
+				log.Fatalf("failed to construct testRequest, %v", reqErr)
+			}
+			if reqErr = req.Get(); reqErr != nil {
+				log.Fatalf("request failed, %v", reqErr)
+			}
+			return fmt.Sprint(testResponse.Data), metadata
+		}
+		alice := NewHMACAuthenticator("alice", []byte("secret"))
+		first, _ := doSigned(alice)
+		second, secondMetadata := doSigned(alice)
+		third, thirdMetadata := doSigned(NewHMACAuthenticator("alice", []byte("secret")))
+		otherKey, otherKeyMetadata := doSigned(NewHMACAuthenticator("alice", []byte("another secret")))
+
+		So(first, ShouldEqual, "/private 1")
+		So(second, ShouldEqual, "/private 1")
+		So(secondMetadata.FromCache, ShouldBeTrue)
+		So(third, ShouldEqual, "/private 1")
+		So(thirdMetadata.FromCache, ShouldBeTrue)
+		So(otherKey, ShouldEqual, "/private 2")
+		So(otherKeyMetadata.FromCache, ShouldBeFalse)
+	})
+
+	Convey("TEST unsafe requests invalidate the cached response", t, func() {
+		offset, server.hits = 0, make(map[string]int)
+		cache := newCache(nil)
+		_, _ = doRequest(cache, http.MethodGet, "/max-age", nil)
+		_, _ = doRequest(cache, http.MethodPost, "/max-age", nil)
+		afterPost, metadata := doRequest(cache, http.MethodGet, "/max-age", nil)
+
+		Convey("Next GET should be sent to the server", func() {
+			So(metadata.FromCache, ShouldBeFalse)
+			So(afterPost, ShouldEqual, fmt.Sprintf("/max-age %d", server.hits["/max-age"]))
+		})
+	})
+
+	Convey("TEST disk cache store keeps responses between caches", t, func() {
+		offset, server.hits = 0, make(map[string]int)
+		dir, _ := ioutil.TempDir("", "restclient-cache")
+		defer os.RemoveAll(dir)
+		fetched, _ := doRequest(newCache(NewDiskCacheStore(dir)), http.MethodGet, "/expires", nil)
+		cached, metadata := doRequest(newCache(NewDiskCacheStore(dir)), http.MethodGet, "/expires", nil)
+
+		Convey("Response should be served from the disk", func() {
+			So(cached, ShouldEqual, fetched)
+			So(metadata.FromCache, ShouldBeTrue)
+		})
+	})
+}
+
+func TestResponseCacheStale(t *testing.T) {
+	server := &testCachingServer{hits: make(map[string]int)}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	var offset int64 // clock offset of the cache and the server, changed while requests may be in flight
+	setOffset := func(d time.Duration) {
+		atomic.StoreInt64(&offset, int64(d))
+	}
+	now := func() time.Time {
+		return time.Now().Add(time.Duration(atomic.LoadInt64(&offset)))
+	}
+	var cache *ResponseCache
+	reset := func() {
+		setOffset(0)
+		server.mu.Lock()
+		server.hits, server.failing, server.delay = make(map[string]int), false, 0
+		server.mu.Unlock()
+		cache = NewResponseCache(nil)
+		cache.Now = now
+	}
+	server.now = now
+	setServer := func(failing bool, delay time.Duration) {
+		server.mu.Lock()
+		server.failing, server.delay = failing, delay
+		server.mu.Unlock()
+	}
+	hitsOf := func(path string) int {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return server.hits[path]
+	}
+	doGet := func(path string, timeout time.Duration) (string, ResponseMetadata, RequestError) {
+		var testResponse testHttpResponse
+		var metadata ResponseMetadata
+		req, reqErr := RequestBuilder().
+			RawUrl(ts.URL + path).
+			Timeout(timeout).
+			Cache(cache).
+			ResponseMetadata(&metadata).
+			ResponseReference(&testResponse).
+			Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+		reqErr = req.Get()
+		return fmt.Sprint(testResponse.Data), metadata, reqErr
+	}
+
+	Convey("TEST stale responses are served in place of errors within the stale-if-error window", t, func() {
+		reset()
+		_, _, _ = doGet("/stale-if-error", 0)
+		_, _, _ = doGet("/max-age", 0)
+		setOffset(2 * time.Minute)
+		setServer(true, 0)
+		stale, staleMetadata, staleErr := doGet("/stale-if-error", 0)
+		_, _, noFallbackErr := doGet("/max-age", 0)
+		cache.StaleIfError = time.Hour
+		policyStale, policyMetadata, policyErr := doGet("/max-age", 0)
+		setOffset(10 * time.Minute)
+		cache.StaleIfError = 0
+		_, _, expiredErr := doGet("/stale-if-error", 0)
+
+		So(staleErr, ShouldBeNil)
+		So(stale, ShouldEqual, "/stale-if-error 1")
+		So(staleMetadata.Stale, ShouldBeTrue)
+		So(staleMetadata.FromCache, ShouldBeTrue)
+		So(staleMetadata.StaleErr, ShouldNotBeNil)
+		So(staleMetadata.StaleErr.GetTopLevelError(), ShouldEqual, ServiceUnavailableErr)
+		So(staleMetadata.StaleErr.GetMessage(), ShouldEqual, "maintenance")
+		So(noFallbackErr, ShouldNotBeNil)
+		So(noFallbackErr.GetTopLevelError(), ShouldEqual, ServiceUnavailableErr)
+		So(policyErr, ShouldBeNil)
+		So(policyStale, ShouldEqual, "/max-age 1")
+		So(policyMetadata.Stale, ShouldBeTrue)
+		So(expiredErr, ShouldNotBeNil)
+	})
+
+	Convey("TEST stale responses are served in place of timeouts and never if they must be revalidated", t, func() {
+		reset()
+		cache.StaleIfError = time.Hour
+		_, _, _ = doGet("/max-age", 0)
+		_, _, _ = doGet("/must-revalidate", 0)
+		setOffset(2 * time.Minute)
+		setServer(false, 200*time.Millisecond)
+		stale, metadata, timeoutErr := doGet("/max-age", 50*time.Millisecond)
+		_, _, mustRevalidateErr := doGet("/must-revalidate", 50*time.Millisecond)
+
+		So(timeoutErr, ShouldBeNil)
+		So(stale, ShouldEqual, "/max-age 1")
+		So(metadata.StaleErr, ShouldNotBeNil)
+		So(metadata.StaleErr.Timeout(), ShouldBeTrue)
+		So(mustRevalidateErr, ShouldNotBeNil)
+		So(mustRevalidateErr.Timeout(), ShouldBeTrue)
+	})
+
+	Convey("TEST stale responses are served while they are revalidated in the background", t, func() {
+		reset()
+		_, _, _ = doGet("/stale-while-revalidate", 0)
+		setOffset(90 * time.Second)
+		stale, staleMetadata, staleErr := doGet("/stale-while-revalidate", 0)
+		for i := 0; i < 100 && hitsOf("/stale-while-revalidate") < 2; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(20 * time.Millisecond)
+		revalidated, revalidatedMetadata, _ := doGet("/stale-while-revalidate", 0)
+
+		So(staleErr, ShouldBeNil)
+		So(stale, ShouldEqual, "/stale-while-revalidate 1")
+		So(staleMetadata.Stale, ShouldBeTrue)
+		So(staleMetadata.StaleErr, ShouldBeNil)
+		So(revalidated, ShouldEqual, "/stale-while-revalidate 2")
+		So(revalidatedMetadata.FromCache, ShouldBeTrue)
+		So(revalidatedMetadata.Stale, ShouldBeFalse)
+	})
+}
+
+func TestLRUCacheStore(t *testing.T) {
+	Convey("TEST least recently used responses are evicted", t, func() {
+		store := NewLRUCacheStore(2)
+		_ = store.Save("a", &CachedResponse{StatusCode: 200})
+		_ = store.Save("b", &CachedResponse{StatusCode: 201})
+		_, _ = store.Load("a")
+		_ = store.Save("c", &CachedResponse{StatusCode: 202})
+		a, _ := store.Load("a")
+		b, _ := store.Load("b")
+		c, _ := store.Load("c")
+		_ = store.Delete("c")
+		deleted, _ := store.Load("c")
+
+		So(a.StatusCode, ShouldEqual, 200)
+		So(b, ShouldBeNil)
+		So(c.StatusCode, ShouldEqual, 202)
+		So(deleted, ShouldBeNil)
+	})
+
+	Convey("TEST a store created without the constructor can be used", t, func() {
+		store := &LRUCacheStore{MaxEntries: 1}
+		missing, err := store.Load("a")
+		So(err, ShouldBeNil)
+		So(missing, ShouldBeNil)
+		So(store.Save("a", &CachedResponse{StatusCode: 200}), ShouldBeNil)
+		So(store.Save("b", &CachedResponse{StatusCode: 201}), ShouldBeNil)
+		a, _ := store.Load("a")
+		b, _ := store.Load("b")
+
+		So(a, ShouldBeNil)
+		So(b.StatusCode, ShouldEqual, 201)
+	})
+}
@@ -0,0 +1,208 @@
+package restclient
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* CookieJar is an http.CookieJar following the cookie domain, path, secure and expiry rules of RFC 6265 through
+net/http/cookiejar. Unlike cookiejar.Jar its cookies can be listed with their attributes, and it can be backed by a
+file to keep the cookies between runs. Unless it is created with a PublicSuffixList, a domain cookie can be set for any
+parent domain of the request host, including public suffixes such as co.uk, see CookieJarOptions */
+type CookieJar struct {
+	mu       sync.Mutex
+	jar      *cookiejar.Jar
+	entries  map[string]cookieJarEntry // set cookies by domain, path and name
+	path     string                    // file to persist the cookies in, empty for in-memory jars
+	suffixes cookiejar.PublicSuffixList
+}
+
+/* CookieJarOptions holds the options of a CookieJar.
+- PublicSuffixList: rejects domain cookies for public suffixes e.g. publicsuffix.List of golang.org/x/net/publicsuffix.
+  Without it a site can set a cookie for its public suffix (Domain=co.uk), which is then sent to unrelated sites
+- Path: optional file to persist the cookies in, see NewFileCookieJar */
+type CookieJarOptions struct {
+	PublicSuffixList cookiejar.PublicSuffixList
+	Path             string
+}
+
+/* cookieJarEntry is a cookie as it was set with the URL of the response setting it, MaxAge is resolved into Expires */
+type cookieJarEntry struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+/* NewCookieJar creates an in-memory CookieJar without a public suffix list */
+func NewCookieJar() *CookieJar {
+	cj, _ := NewCookieJarWithOptions(CookieJarOptions{})
+	return cj
+}
+
+/* NewFileCookieJar creates a CookieJar persisted in the file at path, cookies already in the file are loaded. The
+file is rewritten whenever cookies change. Session cookies (without expiry) are persisted as well */
+func NewFileCookieJar(path string) (*CookieJar, error) {
+	return NewCookieJarWithOptions(CookieJarOptions{Path: path})
+}
+
+/* NewCookieJarWithOptions creates a CookieJar with options, cookies already in the file at options.Path are loaded */
+func NewCookieJarWithOptions(options CookieJarOptions) (*CookieJar, error) {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: options.PublicSuffixList})
+	cj := &CookieJar{jar: jar, entries: make(map[string]cookieJarEntry), path: options.Path, suffixes: options.PublicSuffixList}
+	if cj.path == "" {
+		return cj, nil
+	}
+	content, err := ioutil.ReadFile(cj.path)
+	if os.IsNotExist(err) {
+		return cj, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read cookie file %s", cj.path)
+	}
+	var entries []cookieJarEntry
+	if err = json.Unmarshal(content, &entries); err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode cookie file %s", cj.path)
+	}
+	for _, entry := range entries {
+		u, err := url.Parse(entry.URL)
+		if err != nil || entry.Cookie == nil {
+			continue
+		}
+		cj.setCookiesLocked(u, []*http.Cookie{entry.Cookie})
+	}
+	return cj, nil
+}
+
+/* SetCookies implements http.CookieJar, it can also be used to seed the jar with cookies as if they were set by a
+response of u */
+func (cj *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	cj.mu.Lock()
+	defer cj.mu.Unlock()
+	cj.setCookiesLocked(u, cookies)
+	if cj.path != "" {
+		if err := cj.saveLocked(); err != nil {
+			errorLogger.Printf("Failed to persist cookies, [err]: %v", err)
+		}
+	}
+}
+
+/* Cookies implements http.CookieJar and returns the cookies to send in a request to u */
+func (cj *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	cj.mu.Lock()
+	defer cj.mu.Unlock()
+	return cj.jar.Cookies(u)
+}
+
+/* AllCookies returns every unexpired cookie in the jar with its Domain, Path, Expires, Secure and HttpOnly attributes.
+Domain is the host for host-only cookies */
+func (cj *CookieJar) AllCookies() []*http.Cookie {
+	cj.mu.Lock()
+	defer cj.mu.Unlock()
+	now := time.Now()
+	keys := make([]string, 0, len(cj.entries))
+	for key, entry := range cj.entries {
+		if !entry.Cookie.Expires.IsZero() && !entry.Cookie.Expires.After(now) {
+			delete(cj.entries, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	cookies := make([]*http.Cookie, len(keys))
+	for i, key := range keys {
+		cookie := *cj.entries[key].Cookie
+		u, _ := url.Parse(cj.entries[key].URL)
+		cookie.Domain, cookie.Path = cookieDomainAndPath(u, &cookie)
+		cookies[i] = &cookie
+	}
+	return cookies
+}
+
+/* Clear removes every cookie from the jar */
+func (cj *CookieJar) Clear() error {
+	cj.mu.Lock()
+	defer cj.mu.Unlock()
+	cj.jar, _ = cookiejar.New(&cookiejar.Options{PublicSuffixList: cj.suffixes})
+	cj.entries = make(map[string]cookieJarEntry)
+	if cj.path != "" {
+		return cj.saveLocked()
+	}
+	return nil
+}
+
+/* setCookiesLocked sets the cookies in the underlying jar and records the ones it accepts */
+func (cj *CookieJar) setCookiesLocked(u *url.URL, cookies []*http.Cookie) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return
+	}
+	host := strings.ToLower(u.Hostname())
+	now := time.Now()
+	recorded := make([]*http.Cookie, 0, len(cookies))
+	for _, c := range cookies {
+		cookie := *c
+		if cookie.MaxAge > 0 {
+			cookie.Expires, cookie.MaxAge = now.Add(time.Duration(cookie.MaxAge)*time.Second), 0
+		} else if cookie.MaxAge < 0 {
+			cookie.Expires, cookie.MaxAge = time.Unix(1, 0), 0
+		}
+		recorded = append(recorded, &cookie)
+
+		domain, path := cookieDomainAndPath(u, &cookie)
+		if host != domain && !strings.HasSuffix(host, "."+domain) {
+			continue
+		}
+		if cj.suffixes != nil && host != domain && cj.suffixes.PublicSuffix(domain) == domain {
+			continue
+		}
+		key := domain + ";" + path + ";" + cookie.Name
+		if !cookie.Expires.IsZero() && !cookie.Expires.After(now) {
+			delete(cj.entries, key)
+			continue
+		}
+		cj.entries[key] = cookieJarEntry{URL: u.String(), Cookie: &cookie}
+	}
+	cj.jar.SetCookies(u, recorded)
+}
+
+/* saveLocked writes the cookies to the file of the jar */
+func (cj *CookieJar) saveLocked() error {
+	entries := make([]cookieJarEntry, 0, len(cj.entries))
+	for _, entry := range cj.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].URL+entries[i].Cookie.Name < entries[j].URL+entries[j].Cookie.Name
+	})
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode cookies")
+	}
+	return writeFileAtomic(cj.path, content)
+}
+
+/* cookieDomainAndPath returns the domain and path a cookie set by a response of u applies to (RFC 6265 section 5.3) */
+func cookieDomainAndPath(u *url.URL, cookie *http.Cookie) (string, string) {
+	domain := strings.ToLower(strings.TrimPrefix(cookie.Domain, "."))
+	if domain == "" {
+		domain = strings.ToLower(u.Hostname())
+	}
+	path := cookie.Path
+	if !strings.HasPrefix(path, "/") {
+		path = u.Path
+		if i := strings.LastIndex(path, "/"); i > 0 {
+			path = path[:i]
+		} else {
+			path = "/"
+		}
+	}
+	return domain, path
+}
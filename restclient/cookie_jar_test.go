@@ -0,0 +1,185 @@
+package restclient
+
+import (
+	"context"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	var receivedCookies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/", HttpOnly: true})
+			http.SetCookie(w, &http.Cookie{Name: "admin", Value: "1", Path: "/admin"})
+		case "/logout":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "", Path: "/", MaxAge: -1})
+		default:
+			receivedCookies = append(receivedCookies, fmt.Sprint(r.Header.Values("Cookie")))
+			if auth := r.Header.Get("Authorization"); auth != "" && auth != "Bearer token-2" {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}
+	}))
+	defer ts.Close()
+
+	session := NewSession()
+	doGet := func(path string, auth Authenticator) RequestError {
+		req, reqErr := session.RequestBuilder().
+			RawUrl(ts.URL + path).
+			Header(&http.Header{"Cookie": []string{"manual=1"}}).
+			Auth(auth).
+			Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+		return req.Get()
+	}
+
+	Convey("TEST cookies set by a response are sent with the following requests of the session", t, func() {
+		loginErr := doGet("/login", nil)
+		receivedCookies = nil
+		tasksErr := doGet("/tasks", nil)
+		_ = doGet("/logout", nil)
+		_ = doGet("/tasks", nil)
+
+		Convey("Session cookie should be sent once per request until it is deleted, path cookie never", func() {
+			So(loginErr, ShouldBeNil)
+			So(tasksErr, ShouldBeNil)
+			So(receivedCookies, ShouldResemble, []string{"[manual=1; session=abc]", "[manual=1]"})
+		})
+	})
+
+	Convey("TEST cookie is resent without duplicates when a request is retried", t, func() {
+		fetches := 0
+		auth := NewRefreshableBearerAuthenticator(NewCachingTokenSource(func(ctx context.Context) (string, time.Time, error) {
+			fetches++
+			return fmt.Sprintf("token-%d", fetches), time.Time{}, nil
+		}))
+		_ = doGet("/login", nil)
+		receivedCookies = nil
+		reqErr := doGet("/tasks", auth)
+
+		Convey("Both attempts should carry the cookies once", func() {
+			So(reqErr, ShouldBeNil)
+			So(receivedCookies, ShouldResemble, []string{"[manual=1; session=abc]", "[manual=1; session=abc]"})
+		})
+	})
+}
+
+func TestCookieJar(t *testing.T) {
+	apiURL, _ := url.Parse("https://api.example.com/v1/tasks")
+
+	Convey("TEST cookie domain, path, secure and expiry rules", t, func() {
+		jar := NewCookieJar()
+		jar.SetCookies(apiURL, []*http.Cookie{
+			{Name: "domain", Value: "1", Domain: "example.com", Path: "/"},
+			{Name: "host", Value: "2"},
+			{Name: "secure", Value: "3", Path: "/", Secure: true},
+			{Name: "foreign", Value: "4", Domain: "other.com"},
+			{Name: "expired", Value: "5", Expires: time.Now().Add(-time.Hour)},
+		})
+		cookiesOf := func(rawURL string) []string {
+			u, _ := url.Parse(rawURL)
+			var names []string
+			for _, c := range jar.Cookies(u) {
+				names = append(names, c.Name)
+			}
+			return names
+		}
+
+		Convey("Cookies should only be sent where they apply", func() {
+			So(cookiesOf("https://api.example.com/v1/tasks/1"), ShouldResemble, []string{"host", "domain", "secure"})
+			So(cookiesOf("http://api.example.com/other"), ShouldResemble, []string{"domain"})
+			So(cookiesOf("https://www.example.com/"), ShouldResemble, []string{"domain"})
+			So(cookiesOf("https://other.com/"), ShouldBeEmpty)
+		})
+
+		Convey("AllCookies should list the accepted cookies with their attributes", func() {
+			all := jar.AllCookies()
+			So(len(all), ShouldEqual, 3)
+			So(all[0].Name, ShouldEqual, "secure")
+			So(all[0].Secure, ShouldBeTrue)
+			So(all[1].Name, ShouldEqual, "host")
+			So(all[1].Domain, ShouldEqual, "api.example.com")
+			So(all[1].Path, ShouldEqual, "/v1")
+			So(all[2].Name, ShouldEqual, "domain")
+			So(all[2].Domain, ShouldEqual, "example.com")
+		})
+
+		Convey("Clear should remove every cookie", func() {
+			So(jar.Clear(), ShouldBeNil)
+			So(jar.AllCookies(), ShouldBeEmpty)
+			So(cookiesOf("https://api.example.com/v1/tasks/1"), ShouldBeEmpty)
+		})
+	})
+
+	Convey("TEST domain cookies for public suffixes are rejected with a public suffix list", t, func() {
+		siteURL, _ := url.Parse("https://shop.co.uk/")
+		otherURL, _ := url.Parse("https://bank.co.uk/")
+		cookies := []*http.Cookie{{Name: "tracker", Value: "1", Domain: "co.uk", Path: "/"}, {Name: "own", Value: "2", Path: "/"}}
+		plain := NewCookieJar()
+		plain.SetCookies(siteURL, cookies)
+		guarded, err := NewCookieJarWithOptions(CookieJarOptions{PublicSuffixList: testPublicSuffixList{}})
+		So(err, ShouldBeNil)
+		guarded.SetCookies(siteURL, cookies)
+
+		Convey("Public suffix cookie should only be accepted without the list", func() {
+			So(len(plain.Cookies(otherURL)), ShouldEqual, 1)
+			So(guarded.Cookies(otherURL), ShouldBeEmpty)
+			So(len(guarded.Cookies(siteURL)), ShouldEqual, 1)
+			So(len(guarded.AllCookies()), ShouldEqual, 1)
+		})
+	})
+
+	Convey("TEST file cookie jar keeps cookies between runs", t, func() {
+		dir, _ := ioutil.TempDir("", "restclient-cookies")
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "cookies.json")
+
+		jar, err := NewFileCookieJar(path)
+		So(err, ShouldBeNil)
+		jar.SetCookies(apiURL, []*http.Cookie{
+			{Name: "session", Value: "abc", Path: "/", MaxAge: 3600},
+			{Name: "removed", Value: "1", Path: "/"},
+		})
+		jar.SetCookies(apiURL, []*http.Cookie{{Name: "removed", Path: "/", MaxAge: -1}})
+
+		reloaded, err := NewFileCookieJar(path)
+		So(err, ShouldBeNil)
+		cookies := reloaded.Cookies(apiURL)
+		all := reloaded.AllCookies()
+
+		Convey("Unexpired cookies should be loaded with their expiry", func() {
+			So(len(cookies), ShouldEqual, 1)
+			So(cookies[0].Name, ShouldEqual, "session")
+			So(len(all), ShouldEqual, 1)
+			So(all[0].Expires, ShouldHappenAfter, time.Now().Add(59*time.Minute))
+		})
+	})
+}
+
+/* testPublicSuffixList knows the public suffixes com and co.uk */
+type testPublicSuffixList struct{}
+
+func (testPublicSuffixList) PublicSuffix(domain string) string {
+	if strings.HasSuffix(domain, "co.uk") {
+		return "co.uk"
+	}
+	return domain[strings.LastIndex(domain, ".")+1:]
+}
+
+func (testPublicSuffixList) String() string {
+	return "test"
+}
@@ -0,0 +1,324 @@
+package restclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultCredentialEnvPrefix = "RESTCLIENT"
+	defaultHelperTimeout       = 10 * time.Second
+)
+
+/* Credentials are the credentials of a host, Token is empty for username/password credentials */
+type Credentials struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+/* CredentialProvider looks up credentials per host so that secrets are kept out of application code. Use it with
+NewBasicProviderAuthenticator or NewBearerProviderAuthenticator */
+type CredentialProvider interface {
+	/* Credentials returns the credentials of host (without port), nil if the provider has none for it */
+	Credentials(host string) (*Credentials, error)
+}
+
+/* ChainCredentialProvider returns the credentials of the first provider that has credentials for the host */
+type ChainCredentialProvider []CredentialProvider
+
+func (cp ChainCredentialProvider) Credentials(host string) (*Credentials, error) {
+	for _, provider := range cp {
+		credentials, err := provider.Credentials(host)
+		if err != nil || credentials != nil {
+			return credentials, err
+		}
+	}
+	return nil, nil
+}
+
+/* NetrcCredentialProvider reads credentials from a netrc file (login and password of the matching machine, or of
+default). Path defaults to $NETRC or ~/.netrc, the file is read again whenever it changes */
+type NetrcCredentialProvider struct {
+	Path string
+}
+
+func (np NetrcCredentialProvider) Credentials(host string) (*Credentials, error) {
+	path := np.Path
+	if path == "" {
+		path = os.Getenv("NETRC")
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to find netrc file")
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+	content, err := watchedFiles.read(path)
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseNetrc(string(content), host), nil
+}
+
+/* parseNetrc returns the credentials of host in a netrc file, or the default ones if host has no machine entry */
+func parseNetrc(content, host string) *Credentials {
+	var found, defaults, current *Credentials
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			lines = append(lines, line)
+		}
+	}
+	fields := strings.Fields(strings.Join(lines, "\n"))
+	for i := 0; i < len(fields); i++ {
+		next := func() string {
+			if i+1 < len(fields) {
+				i++
+				return fields[i]
+			}
+			return ""
+		}
+		switch fields[i] {
+		case "machine":
+			current = &Credentials{}
+			if strings.EqualFold(next(), host) && found == nil {
+				found = current
+			}
+		case "default":
+			current = &Credentials{}
+			defaults = current
+		case "login":
+			if login := next(); current != nil {
+				current.Username = login
+			}
+		case "password":
+			if password := next(); current != nil {
+				current.Password = password
+			}
+		case "account":
+			next()
+		case "macdef":
+			// Macro definitions run until an empty line, they are not used and skipped
+			next()
+			current = nil
+			for i+1 < len(fields) && fields[i+1] != "machine" && fields[i+1] != "default" {
+				i++
+			}
+		}
+	}
+	if found != nil {
+		return found
+	}
+	return defaults
+}
+
+/* EnvCredentialProvider reads credentials from environment variables. For host api.example.com and prefix RESTCLIENT
+these are RESTCLIENT_API_EXAMPLE_COM_USERNAME, _PASSWORD and _TOKEN. If none of them is set, RESTCLIENT_USERNAME,
+RESTCLIENT_PASSWORD and RESTCLIENT_TOKEN are used for the hosts listed in Hosts, they are never sent to other hosts.
+Prefix defaults to RESTCLIENT */
+type EnvCredentialProvider struct {
+	Prefix string
+	Hosts  []string
+}
+
+func (ep EnvCredentialProvider) Credentials(host string) (*Credentials, error) {
+	prefix := ep.Prefix
+	if prefix == "" {
+		prefix = defaultCredentialEnvPrefix
+	}
+	lookup := func(prefix string) *Credentials {
+		credentials := &Credentials{
+			Username: os.Getenv(prefix + "_USERNAME"),
+			Password: os.Getenv(prefix + "_PASSWORD"),
+			Token:    os.Getenv(prefix + "_TOKEN"),
+		}
+		if *credentials == (Credentials{}) {
+			return nil
+		}
+		return credentials
+	}
+	if credentials := lookup(prefix + "_" + envName(host)); credentials != nil {
+		return credentials, nil
+	}
+	if !hostListed(ep.Hosts, host) {
+		return nil, nil
+	}
+	return lookup(prefix), nil
+}
+
+/* hostListed reports whether host is one of hosts */
+func hostListed(hosts []string, host string) bool {
+	for _, listed := range hosts {
+		if strings.EqualFold(listed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+/* envName upper cases s and replaces the characters that are not allowed in environment variable names with _ */
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, s)
+}
+
+/* FileCredentialProvider reads credentials from mounted secret files named username, password and token, e.g. a
+Kubernetes secret volume. Files in the <Dir>/<host> directory take precedence over the ones in Dir, which are only
+used for the hosts listed in Hosts. The files are read again whenever they change on disk, so rotated secrets are
+picked up without a restart */
+type FileCredentialProvider struct {
+	Dir   string
+	Hosts []string
+}
+
+func (fp FileCredentialProvider) Credentials(host string) (*Credentials, error) {
+	// The host names a directory in Dir, it must not lead out of it
+	if host == "" || host == "." || host == ".." || strings.ContainsAny(host, `/\`) {
+		return nil, errors.Errorf("Invalid host %q", host)
+	}
+	dirs := []string{filepath.Join(fp.Dir, host)}
+	if hostListed(fp.Hosts, host) {
+		dirs = append(dirs, fp.Dir)
+	}
+	for _, dir := range dirs {
+		var credentials Credentials
+		for name, value := range map[string]*string{"username": &credentials.Username, "password": &credentials.Password, "token": &credentials.Token} {
+			content, err := watchedFiles.read(filepath.Join(dir, name))
+			if os.IsNotExist(errors.Cause(err)) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			*value = strings.TrimRight(string(content), "\r\n")
+		}
+		if credentials != (Credentials{}) {
+			return &credentials, nil
+		}
+	}
+	return nil, nil
+}
+
+/* HelperCredentialProvider gets credentials from an external credential-helper executable. The helper is run with
+Args, receives {"host": "<host>"} on stdin and writes the credentials as {"username": "...", "password": "...",
+"token": "..."} to stdout, an empty output or {} means it has no credentials for the host. A non-zero exit status is
+an error. Credentials are cached per host for CacheTTL (zero means no caching), the helper is killed after Timeout
+(defaults to 10 seconds) */
+type HelperCredentialProvider struct {
+	Command  string
+	Args     []string
+	Timeout  time.Duration
+	CacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedCredentials
+}
+
+type cachedCredentials struct {
+	credentials *Credentials
+	expiresAt   time.Time
+}
+
+func NewHelperCredentialProvider(command string, args ...string) *HelperCredentialProvider {
+	return &HelperCredentialProvider{
+		Command: command,
+		Args:    args,
+	}
+}
+
+func (hp *HelperCredentialProvider) Credentials(host string) (*Credentials, error) {
+	if hp.CacheTTL > 0 {
+		hp.mu.Lock()
+		cached, ok := hp.cache[host]
+		hp.mu.Unlock()
+		if ok && time.Now().Before(cached.expiresAt) {
+			return cached.credentials, nil
+		}
+	}
+
+	timeout := hp.Timeout
+	if timeout <= 0 {
+		timeout = defaultHelperTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	input, _ := json.Marshal(map[string]string{"host": host})
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, hp.Command, hp.Args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(input), &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "Credential helper %s failed: %s", hp.Command, strings.TrimSpace(stderr.String()))
+	}
+
+	var credentials *Credentials
+	if output := bytes.TrimSpace(stdout.Bytes()); len(output) != 0 {
+		credentials = &Credentials{}
+		if err := json.Unmarshal(output, credentials); err != nil {
+			return nil, errors.Wrapf(err, "Failed to decode output of credential helper %s", hp.Command)
+		}
+		if *credentials == (Credentials{}) {
+			credentials = nil
+		}
+	}
+
+	if hp.CacheTTL > 0 {
+		hp.mu.Lock()
+		if hp.cache == nil {
+			hp.cache = make(map[string]cachedCredentials)
+		}
+		hp.cache[host] = cachedCredentials{credentials: credentials, expiresAt: time.Now().Add(hp.CacheTTL)}
+		hp.mu.Unlock()
+	}
+	return credentials, nil
+}
+
+/* watchedFiles caches the content of credential files, a file is read again once its size or modification time changes */
+var watchedFiles = &fileCache{files: make(map[string]cachedFile)}
+
+type fileCache struct {
+	mu    sync.Mutex
+	files map[string]cachedFile
+}
+
+type cachedFile struct {
+	modTime time.Time
+	size    int64
+	content []byte
+}
+
+func (fc *fileCache) read(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read %s", path)
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if cached, ok := fc.files[path]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.content, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read %s", path)
+	}
+	fc.files[path] = cachedFile{modTime: info.ModTime(), size: info.Size(), content: content}
+	return content, nil
+}
@@ -0,0 +1,186 @@
+package restclient
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/* TestCredentialHelperProcess is not a real test, it is the credential helper run by TestCredentialProviders */
+func TestCredentialHelperProcess(t *testing.T) {
+	if os.Getenv("RESTCLIENT_TEST_CREDENTIAL_HELPER") != "1" {
+		return
+	}
+	defer os.Exit(0)
+	var input struct {
+		Host string `json:"host"`
+	}
+	if err := json.NewDecoder(os.Stdin).Decode(&input); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid input")
+		os.Exit(2)
+	}
+	switch input.Host {
+	case "api.example.com":
+		fmt.Println(`{"username": "helper-user", "password": "helper-password"}`)
+	case "broken.example.com":
+		fmt.Fprintln(os.Stderr, "helper is broken")
+		os.Exit(1)
+	}
+}
+
+func TestCredentialProviders(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient-credentials")
+	defer os.RemoveAll(dir)
+	writeFile := func(path, content string, modTime time.Time) {
+		_ = os.MkdirAll(filepath.Dir(path), 0700)
+		_ = ioutil.WriteFile(path, []byte(content), 0600)
+		_ = os.Chtimes(path, modTime, modTime)
+	}
+	start := time.Now().Add(-time.Hour)
+
+	Convey("TEST netrc credential provider", t, func() {
+		path := filepath.Join(dir, "netrc")
+		writeFile(path, `# comment
+machine api.example.com
+  login user password secret
+macdef init
+  cd /tmp
+
+machine other.example.com login other password other-secret account acc
+default login anonymous password guest
+`, start)
+		provider := NetrcCredentialProvider{Path: path}
+		apiCredentials, apiErr := provider.Credentials("api.example.com")
+		otherCredentials, _ := provider.Credentials("OTHER.example.com")
+		defaultCredentials, _ := provider.Credentials("unknown.example.com")
+		missing, missingErr := NetrcCredentialProvider{Path: filepath.Join(dir, "missing")}.Credentials("api.example.com")
+
+		writeFile(path, "machine api.example.com login user password rotated\n", start.Add(time.Minute))
+		rotated, _ := provider.Credentials("api.example.com")
+
+		Convey("Credentials of the machine should be used, or the default ones", func() {
+			So(apiErr, ShouldBeNil)
+			So(*apiCredentials, ShouldResemble, Credentials{Username: "user", Password: "secret"})
+			So(*otherCredentials, ShouldResemble, Credentials{Username: "other", Password: "other-secret"})
+			So(*defaultCredentials, ShouldResemble, Credentials{Username: "anonymous", Password: "guest"})
+			So(missingErr, ShouldBeNil)
+			So(missing, ShouldBeNil)
+		})
+
+		Convey("Changed file should be read again", func() {
+			So(*rotated, ShouldResemble, Credentials{Username: "user", Password: "rotated"})
+		})
+	})
+
+	Convey("TEST environment credential provider", t, func() {
+		_ = os.Setenv("RCTEST_API_EXAMPLE_COM_TOKEN", "host-token")
+		_ = os.Setenv("RCTEST_USERNAME", "user")
+		_ = os.Setenv("RCTEST_PASSWORD", "secret")
+		defer func() {
+			for _, name := range []string{"RCTEST_API_EXAMPLE_COM_TOKEN", "RCTEST_USERNAME", "RCTEST_PASSWORD"} {
+				_ = os.Unsetenv(name)
+			}
+		}()
+		provider := EnvCredentialProvider{Prefix: "RCTEST", Hosts: []string{"other.example.com"}}
+		hostCredentials, _ := provider.Credentials("api.example.com")
+		otherCredentials, _ := provider.Credentials("Other.example.com")
+		unlisted, _ := provider.Credentials("third-party.com")
+		none, _ := EnvCredentialProvider{Prefix: "RCTEST_NONE"}.Credentials("api.example.com")
+
+		Convey("Host variables should take precedence over the generic ones", func() {
+			So(*hostCredentials, ShouldResemble, Credentials{Token: "host-token"})
+			So(*otherCredentials, ShouldResemble, Credentials{Username: "user", Password: "secret"})
+			So(none, ShouldBeNil)
+		})
+
+		Convey("Generic variables should only be used for the listed hosts", func() {
+			So(unlisted, ShouldBeNil)
+		})
+	})
+
+	Convey("TEST file credential provider", t, func() {
+		secrets := filepath.Join(dir, "secrets")
+		writeFile(filepath.Join(secrets, "username"), "user\n", start)
+		writeFile(filepath.Join(secrets, "password"), "secret\n", start)
+		writeFile(filepath.Join(secrets, "api.example.com", "token"), "host-token", start)
+		provider := FileCredentialProvider{Dir: secrets, Hosts: []string{"other.example.com"}}
+		hostCredentials, _ := provider.Credentials("api.example.com")
+		otherCredentials, _ := provider.Credentials("other.example.com")
+		unlisted, _ := provider.Credentials("third-party.com")
+
+		writeFile(filepath.Join(secrets, "password"), "rotated-secret\n", start.Add(time.Minute))
+		rotated, _ := provider.Credentials("other.example.com")
+
+		Convey("Host directory should take precedence and rotated secrets should be picked up", func() {
+			So(*hostCredentials, ShouldResemble, Credentials{Token: "host-token"})
+			So(*otherCredentials, ShouldResemble, Credentials{Username: "user", Password: "secret"})
+			So(*rotated, ShouldResemble, Credentials{Username: "user", Password: "rotated-secret"})
+			So(unlisted, ShouldBeNil)
+		})
+
+		Convey("Hosts leading out of the directory should be rejected", func() {
+			for _, host := range []string{"..", ".", "", "../secrets", `..\secrets`} {
+				credentials, err := provider.Credentials(host)
+				So(err, ShouldNotBeNil)
+				So(credentials, ShouldBeNil)
+			}
+		})
+	})
+
+	Convey("TEST credential helper provider", t, func() {
+		_ = os.Setenv("RESTCLIENT_TEST_CREDENTIAL_HELPER", "1")
+		defer os.Unsetenv("RESTCLIENT_TEST_CREDENTIAL_HELPER")
+		provider := NewHelperCredentialProvider(os.Args[0], "-test.run=TestCredentialHelperProcess")
+		credentials, err := provider.Credentials("api.example.com")
+		none, noneErr := provider.Credentials("other.example.com")
+		_, brokenErr := provider.Credentials("broken.example.com")
+
+		Convey("Helper output should be decoded and its failures reported", func() {
+			So(err, ShouldBeNil)
+			So(*credentials, ShouldResemble, Credentials{Username: "helper-user", Password: "helper-password"})
+			So(noneErr, ShouldBeNil)
+			So(none, ShouldBeNil)
+			So(brokenErr, ShouldNotBeNil)
+			So(brokenErr.Error(), ShouldContainSubstring, "helper is broken")
+		})
+	})
+
+	Convey("TEST basic and bearer authenticators with a credential provider", t, func() {
+		var received []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = append(received, r.Header.Get("Authorization"))
+		}))
+		defer ts.Close()
+		path := filepath.Join(dir, "auth-netrc")
+		writeFile(path, "machine 127.0.0.1 login user password secret\n", start)
+		provider := ChainCredentialProvider{EnvCredentialProvider{Prefix: "RCTEST_NONE"}, NetrcCredentialProvider{Path: path}}
+		doGet := func(rawURL string, auth Authenticator) RequestError {
+			req, reqErr := RequestBuilder().RawUrl(rawURL).Auth(auth).Build()
+			if reqErr != nil {
+				return reqErr
+			}
+			return req.Get()
+		}
+		basicErr := doGet(ts.URL, NewBasicProviderAuthenticator(provider))
+		bearerErr := doGet(ts.URL, NewBearerProviderAuthenticator(provider))
+		missingErr := doGet("http://localhost:1/", NewBasicProviderAuthenticator(NetrcCredentialProvider{Path: path}))
+
+		Convey("Credentials of the request host should be sent", func() {
+			So(basicErr, ShouldBeNil)
+			So(bearerErr, ShouldBeNil)
+			So(received, ShouldResemble, []string{"Basic dXNlcjpzZWNyZXQ=", "Bearer secret"})
+		})
+
+		Convey("Missing credentials should fail the request before it is sent", func() {
+			So(missingErr, ShouldNotBeNil)
+			So(missingErr.GetMessage(), ShouldContainSubstring, "No credentials found for localhost")
+		})
+	})
+}
@@ -0,0 +1,149 @@
+package restclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* conditionalHeaders always take part in the key of a RequestGroup as they change the response of identical requests */
+var conditionalHeaders = []string{"If-None-Match", "If-Modified-Since"}
+
+/* RequestGroup de-duplicates concurrent identical GET and HEAD requests, set it with HttpRequestBuilder.Dedup. While a
+request is in flight, identical requests wait for it instead of being sent, and each of them gets its own copy of the
+response, decoded into its own ResponseReference. Errors are shared as well, unless the request in flight was cancelled
+or timed out, then a waiting request is sent in its place. Requests are identical if they have the same method, URL,
+values of Headers and credentials (the identity of an IdentityAuthenticator or the headers set by their Authenticator,
+Authorization, Cookie and the cookies of the CookieJar). A waiting request gives up once its own timeout expires. Share
+the same group between requests */
+type RequestGroup struct {
+	Headers []string
+
+	mu    sync.Mutex
+	calls map[string]*groupCall
+}
+
+/* groupCall is a request in flight whose result is shared with the identical requests waiting for it */
+type groupCall struct {
+	done chan struct{}
+	resp *http.Response // response without body, see body
+	body []byte
+	err  error
+
+	abandoned bool // the call was cancelled or timed out, its error is not shared
+}
+
+/* NewRequestGroup creates a RequestGroup, requests with different values of headers are not de-duplicated */
+func NewRequestGroup(headers ...string) *RequestGroup {
+	return &RequestGroup{Headers: headers, calls: make(map[string]*groupCall)}
+}
+
+/* do sends req with send unless an identical request with key is in flight, in which case its result is waited for up
+to timeout (zero means no timeout) and shared. It reports whether the response is shared with an identical request.
+The request in flight failing because it was cancelled or timed out is not shared, a waiting request sends itself in
+that case */
+func (rg *RequestGroup) do(req *http.Request, key string, timeout time.Duration, send func() (*http.Response, error)) (*http.Response, bool, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		resp, err := send()
+		return resp, false, err
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		rg.mu.Lock()
+		if rg.calls == nil {
+			rg.calls = make(map[string]*groupCall)
+		}
+		call, ok := rg.calls[key]
+		if !ok {
+			break
+		}
+		rg.mu.Unlock()
+		select {
+		case <-call.done:
+			if call.abandoned {
+				continue
+			}
+			resp, err := call.result()
+			return resp, true, err
+		case <-req.Context().Done():
+			return nil, false, &url.Error{Op: req.Method, URL: req.URL.String(), Err: req.Context().Err()}
+		case <-expired:
+			return nil, false, &url.Error{Op: req.Method, URL: req.URL.String(), Err: groupWaitTimeoutErr{}}
+		}
+	}
+	call := &groupCall{done: make(chan struct{})}
+	rg.calls[key] = call
+	rg.mu.Unlock()
+
+	call.resp, call.err = send()
+	if call.err == nil {
+		call.body, call.err = ioutil.ReadAll(call.resp.Body)
+		_ = call.resp.Body.Close()
+	}
+	if call.err != nil {
+		urlErr, isURLErr := call.err.(*url.Error)
+		call.abandoned = req.Context().Err() != nil || (isURLErr && urlErr.Timeout())
+	}
+	rg.mu.Lock()
+	delete(rg.calls, key)
+	rg.mu.Unlock()
+	close(call.done)
+
+	resp, err := call.result()
+	return resp, false, err
+}
+
+/* groupWaitTimeoutErr is the timeout error of a request that waited too long for an identical request in flight */
+type groupWaitTimeoutErr struct{}
+
+func (groupWaitTimeoutErr) Error() string {
+	return "timeout awaiting the response of an identical request"
+}
+
+func (groupWaitTimeoutErr) Timeout() bool {
+	return true
+}
+
+/* result returns a copy of the response of the call with its own header and body */
+func (gc *groupCall) result() (*http.Response, error) {
+	if gc.err != nil {
+		return nil, gc.err
+	}
+	resp := *gc.resp
+	resp.Header = gc.resp.Header.Clone()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(gc.body))
+	return &resp, nil
+}
+
+/* key returns the key of req, identity is the hash of its credentials (see credentialIdentity). It is hashed as the
+values of Headers may contain credentials as well */
+func (rg *RequestGroup) key(req *http.Request, identity string) string {
+	names := append(append([]string{}, conditionalHeaders...), rg.Headers...)
+	for i, name := range names {
+		names[i] = http.CanonicalHeaderKey(name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	_, _ = fmt.Fprintf(hash, "%s %s\nidentity: %s\n", req.Method, req.URL.String(), identity)
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			_, _ = fmt.Fprintf(hash, "%s: %s\n", name, strings.Join(req.Header.Values(name), ", "))
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,183 @@
+package restclient
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestGroup(t *testing.T) {
+	var hits int64
+	var release chan struct{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		<-release
+		if r.URL.Path == "/unavailable" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(testRequestBody{TestId: 1, TestName: r.Header.Get("Authorization")})
+	}))
+	defer ts.Close()
+
+	type result struct {
+		body     testRequestBody
+		metadata ResponseMetadata
+		err      RequestError
+	}
+	/* sendConcurrently sends the requests of builders at once, the server answers once all of them had time to arrive */
+	sendConcurrently := func(builders ...HttpRequestBuilder) []result {
+		atomic.StoreInt64(&hits, 0)
+		release = make(chan struct{})
+		results := make([]result, len(builders))
+		var wg sync.WaitGroup
+		for i, builder := range builders {
+			wg.Add(1)
+			go func(i int, builder HttpRequestBuilder) {
+				defer wg.Done()
+				request, _ := builder.ResponseReference(&results[i].body).ResponseMetadata(&results[i].metadata).Build()
+				results[i].err = request.Get()
+			}(i, builder)
+		}
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		return results
+	}
+
+	Convey("TEST Concurrent identical GET requests share a single call", t, func() {
+		group := NewRequestGroup()
+		builders := make([]HttpRequestBuilder, 5)
+		for i := range builders {
+			builders[i] = RequestBuilder().RawUrl(ts.URL + "/tasks/1").Auth(NewBearerTokenAuthenticator("token")).Dedup(group)
+		}
+		results := sendConcurrently(builders...)
+
+		So(atomic.LoadInt64(&hits), ShouldEqual, 1)
+		shared := 0
+		for _, result := range results {
+			So(result.err, ShouldBeNil)
+			So(result.body, ShouldResemble, testRequestBody{TestId: 1, TestName: "Bearer token"})
+			So(result.metadata.StatusCode, ShouldEqual, http.StatusOK)
+			if result.metadata.Shared {
+				shared++
+			}
+		}
+		So(shared, ShouldEqual, len(results)-1)
+	})
+
+	Convey("TEST Requests with different credentials or grouped headers are not de-duplicated", t, func() {
+		group := NewRequestGroup("X-Tenant")
+		tenant := func(name string) *http.Header {
+			return &http.Header{"X-Tenant": []string{name}}
+		}
+		results := sendConcurrently(
+			RequestBuilder().RawUrl(ts.URL+"/tasks/1").Auth(NewBearerTokenAuthenticator("first")).Header(tenant("a")).Dedup(group),
+			RequestBuilder().RawUrl(ts.URL+"/tasks/1").Auth(NewBearerTokenAuthenticator("second")).Header(tenant("a")).Dedup(group),
+			RequestBuilder().RawUrl(ts.URL+"/tasks/1").Auth(NewBearerTokenAuthenticator("first")).Header(tenant("b")).Dedup(group),
+			RequestBuilder().RawUrl(ts.URL+"/tasks/1").Auth(NewBearerTokenAuthenticator("first")).Header(tenant("b")).Dedup(group),
+		)
+
+		So(atomic.LoadInt64(&hits), ShouldEqual, 3)
+		So(results[0].body.TestName, ShouldEqual, "Bearer first")
+		So(results[1].body.TestName, ShouldEqual, "Bearer second")
+		So(results[2].metadata.Shared || results[3].metadata.Shared, ShouldBeTrue)
+	})
+
+	Convey("TEST Concurrent requests signed with the same key share a single call", t, func() {
+		group := NewRequestGroup()
+		builders := make([]HttpRequestBuilder, 3)
+		for i := range builders {
+			builders[i] = RequestBuilder().RawUrl(ts.URL + "/tasks/1").Auth(NewHMACAuthenticator("key", []byte("secret"))).Dedup(group)
+		}
+		results := sendConcurrently(append(builders,
+			RequestBuilder().RawUrl(ts.URL+"/tasks/1").Auth(NewHMACAuthenticator("key", []byte("other"))).Dedup(group))...)
+
+		So(atomic.LoadInt64(&hits), ShouldEqual, 2)
+		So(results[3].metadata.Shared, ShouldBeFalse)
+	})
+
+	Convey("TEST Errors of a shared call are shared", t, func() {
+		group := NewRequestGroup()
+		results := sendConcurrently(
+			RequestBuilder().RawUrl(ts.URL+"/unavailable").Dedup(group),
+			RequestBuilder().RawUrl(ts.URL+"/unavailable").Dedup(group),
+			RequestBuilder().RawUrl(ts.URL+"/unavailable").Dedup(group),
+		)
+
+		So(atomic.LoadInt64(&hits), ShouldEqual, 1)
+		for _, result := range results {
+			So(result.err, ShouldNotBeNil)
+			So(result.err.GetTopLevelError(), ShouldEqual, ServiceUnavailableErr)
+		}
+	})
+
+	Convey("TEST A waiting request gives up once its timeout expires", t, func() {
+		atomic.StoreInt64(&hits, 0)
+		release = make(chan struct{})
+		group := NewRequestGroup()
+		leaderDone := make(chan RequestError)
+		go func() {
+			leader, _ := RequestBuilder().RawUrl(ts.URL + "/tasks/1").Dedup(group).Build()
+			leaderDone <- leader.Get()
+		}()
+		time.Sleep(50 * time.Millisecond)
+		follower, _ := RequestBuilder().RawUrl(ts.URL + "/tasks/1").Timeout(20 * time.Millisecond).Dedup(group).Build()
+		followerErr := follower.Get()
+		close(release)
+
+		So(followerErr, ShouldNotBeNil)
+		So(followerErr.Timeout(), ShouldBeTrue)
+		So(<-leaderDone, ShouldBeNil)
+		So(atomic.LoadInt64(&hits), ShouldEqual, 1)
+	})
+
+	Convey("TEST Waiting requests are sent again once the request in flight is cancelled", t, func() {
+		atomic.StoreInt64(&hits, 0)
+		release = make(chan struct{})
+		group := NewRequestGroup()
+		ctx, cancel := context.WithCancel(context.Background())
+		leaderDone := make(chan RequestError)
+		go func() {
+			leader, _ := RequestBuilder().RawUrl(ts.URL + "/tasks/1").Dedup(group).Build()
+			leader.request = leader.request.WithContext(ctx)
+			leaderDone <- leader.Get()
+		}()
+		time.Sleep(50 * time.Millisecond)
+		results := make([]result, 3)
+		var wg sync.WaitGroup
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				follower, _ := RequestBuilder().RawUrl(ts.URL + "/tasks/1").Dedup(group).
+					ResponseReference(&results[i].body).ResponseMetadata(&results[i].metadata).Build()
+				results[i].err = follower.Get()
+			}(i)
+		}
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		leaderErr := <-leaderDone
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		So(leaderErr, ShouldNotBeNil)
+		So(atomic.LoadInt64(&hits), ShouldEqual, 2)
+		shared := 0
+		for _, result := range results {
+			So(result.err, ShouldBeNil)
+			So(result.body.TestId, ShouldEqual, 1)
+			if result.metadata.Shared {
+				shared++
+			}
+		}
+		So(shared, ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,272 @@
+package restclient
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+/* DigestAuthenticator implements HTTP Digest authentication (RFC 7616) with MD5 and SHA-256 (and their -sess
+variants) and qop=auth. The first request is sent without credentials, the 401 challenge in its WWW-Authenticate
+header is cached and the request is retried. Following requests to the same host reuse the cached nonce with an
+incremented nonce count until the server asks for a new one */
+type DigestAuthenticator struct {
+	Username, Password string
+
+	mu         sync.Mutex
+	challenges map[string]*digestChallenge // cached challenges per host
+}
+
+/* digestChallenge is a cached Digest challenge, nc is the number of requests sent with its nonce */
+type digestChallenge struct {
+	realm, nonce, opaque, algorithm string
+	qop                             bool
+	nc                              uint32
+}
+
+/* authChallenge is a single challenge of a WWW-Authenticate header e.g. Digest realm="api", nonce="abc" */
+type authChallenge struct {
+	scheme string
+	params map[string]string
+}
+
+func NewDigestAuthenticator(username, password string) Authenticator {
+	return &DigestAuthenticator{
+		Username:   username,
+		Password:   password,
+		challenges: make(map[string]*digestChallenge),
+	}
+}
+
+/* Identity is made of the username and the fingerprint of the password, the nonce count and client nonce change with
+every request */
+func (da *DigestAuthenticator) Identity() string {
+	return "digest " + da.Username + " " + fingerprint([]byte(da.Password))
+}
+
+/* Apply sets the Digest Authorization header if a challenge for the request host is cached, otherwise the request
+is sent as it is to receive a challenge */
+func (da *DigestAuthenticator) Apply(request *http.Request) error {
+	da.mu.Lock()
+	defer da.mu.Unlock()
+	c, ok := da.challenges[requestHost(request)]
+	if !ok {
+		return nil
+	}
+	c.nc++
+	authorization, err := da.authorization(request, c)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", authorization)
+	return nil
+}
+
+/* Challenge caches the Digest challenge of a 401 response and asks for a retry, unless the rejected request was
+already sent with the same nonce that is not reported stale i.e. the credentials are wrong. For other responses
+the next nonce announced in the Authentication-Info header is cached if any */
+func (da *DigestAuthenticator) Challenge(request *http.Request, response *http.Response) (bool, error) {
+	da.mu.Lock()
+	defer da.mu.Unlock()
+	host := requestHost(request)
+
+	if response.StatusCode != http.StatusUnauthorized {
+		if c, ok := da.challenges[host]; ok {
+			for _, info := range parseAuthParams(response.Header.Values("Authentication-Info")) {
+				if next := info["nextnonce"]; next != "" && next != c.nonce {
+					c.nonce, c.nc = next, 0
+				}
+			}
+		}
+		return false, nil
+	}
+
+	challenge := selectDigestChallenge(parseAuthChallenges(response.Header.Values("WWW-Authenticate")))
+	if challenge == nil {
+		return false, nil
+	}
+	sentAuthorization := request.Header.Get("Authorization")
+	if sentAuthorization != "" && !strings.EqualFold(challenge.params["stale"], "true") &&
+		strings.Contains(sentAuthorization, fmt.Sprintf("nonce=%q", challenge.params["nonce"])) {
+		return false, nil
+	}
+
+	// The authenticator may be created without NewDigestAuthenticator
+	if da.challenges == nil {
+		da.challenges = make(map[string]*digestChallenge)
+	}
+	da.challenges[host] = &digestChallenge{
+		realm:     challenge.params["realm"],
+		nonce:     challenge.params["nonce"],
+		opaque:    challenge.params["opaque"],
+		algorithm: challenge.params["algorithm"],
+		qop:       challenge.params["qop"] != "",
+	}
+	return true, nil
+}
+
+func (da *DigestAuthenticator) authorization(request *http.Request, c *digestChallenge) (string, error) {
+	algorithm := strings.ToUpper(c.algorithm)
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	newHash := md5.New
+	if strings.HasPrefix(algorithm, "SHA-256") {
+		newHash = sha256.New
+	}
+	digest := func(parts ...string) string {
+		return hashHex(newHash, strings.Join(parts, ":"))
+	}
+
+	cnonceBytes := make([]byte, 16)
+	if _, err := rand.Read(cnonceBytes); err != nil {
+		return "", errors.Wrap(err, "Failed to generate digest client nonce")
+	}
+	cnonce := hex.EncodeToString(cnonceBytes)
+	nc := fmt.Sprintf("%08x", c.nc)
+	uri := request.URL.RequestURI()
+
+	ha1 := digest(da.Username, c.realm, da.Password)
+	if strings.HasSuffix(algorithm, "-SESS") {
+		ha1 = digest(ha1, c.nonce, cnonce)
+	}
+	ha2 := digest(request.Method, uri)
+
+	var response string
+	if c.qop {
+		response = digest(ha1, c.nonce, nc, cnonce, "auth", ha2)
+	} else {
+		response = digest(ha1, c.nonce, ha2)
+	}
+
+	params := []string{
+		fmt.Sprintf("username=%q", da.Username),
+		fmt.Sprintf("realm=%q", c.realm),
+		fmt.Sprintf("uri=%q", uri),
+		"algorithm=" + algorithm,
+		fmt.Sprintf("nonce=%q", c.nonce),
+	}
+	if c.qop {
+		params = append(params, "nc="+nc, fmt.Sprintf("cnonce=%q", cnonce), "qop=auth")
+	}
+	params = append(params, fmt.Sprintf("response=%q", response))
+	if c.opaque != "" {
+		params = append(params, fmt.Sprintf("opaque=%q", c.opaque))
+	}
+	return "Digest " + strings.Join(params, ", "), nil
+}
+
+/* selectDigestChallenge picks the strongest supported Digest challenge, challenges with a qop other than auth are skipped */
+func selectDigestChallenge(challenges []authChallenge) *authChallenge {
+	var selected *authChallenge
+	for i, c := range challenges {
+		if !strings.EqualFold(c.scheme, "Digest") || c.params["nonce"] == "" {
+			continue
+		}
+		if qop := c.params["qop"]; qop != "" && !containsToken(qop, "auth") {
+			continue
+		}
+		switch strings.ToUpper(c.params["algorithm"]) {
+		case "SHA-256", "SHA-256-SESS":
+			return &challenges[i]
+		case "", "MD5", "MD5-SESS":
+			if selected == nil {
+				selected = &challenges[i]
+			}
+		}
+	}
+	return selected
+}
+
+/* containsToken reports whether the comma separated list contains token */
+func containsToken(list, token string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func hashHex(newHash func() hash.Hash, s string) string {
+	h := newHash()
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+/* parseAuthChallenges parses WWW-Authenticate header values into challenges. A header value can hold more than one
+challenge e.g. `Basic realm="api", Digest realm="api", nonce="abc"`. Parameter names are lowercased */
+func parseAuthChallenges(values []string) []authChallenge {
+	var challenges []authChallenge
+	for _, value := range values {
+		rest := value
+		for {
+			rest = strings.TrimLeft(rest, " \t,")
+			if rest == "" {
+				break
+			}
+			token := rest
+			if i := strings.IndexAny(rest, " \t,="); i >= 0 {
+				token = rest[:i]
+			}
+			if token == "" {
+				rest = rest[1:]
+				continue
+			}
+			afterToken := strings.TrimLeft(rest[len(token):], " \t")
+			if strings.HasPrefix(afterToken, "=") && len(challenges) > 0 {
+				var paramValue string
+				paramValue, rest = readAuthParamValue(strings.TrimLeft(afterToken[1:], " \t"))
+				challenges[len(challenges)-1].params[strings.ToLower(token)] = paramValue
+				continue
+			}
+			challenges = append(challenges, authChallenge{scheme: token, params: make(map[string]string)})
+			rest = afterToken
+		}
+	}
+	return challenges
+}
+
+/* parseAuthParams parses header values that only consist of auth-params e.g. Authentication-Info */
+func parseAuthParams(values []string) []map[string]string {
+	params := make([]map[string]string, 0, len(values))
+	for _, value := range values {
+		// Parse the value as the parameters of a dummy challenge
+		for _, c := range parseAuthChallenges([]string{"params " + value}) {
+			params = append(params, c.params)
+		}
+	}
+	return params
+}
+
+/* readAuthParamValue reads a token or a quoted string from the beginning of s and returns it with the rest of s */
+func readAuthParamValue(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		if i := strings.IndexByte(s, ','); i >= 0 {
+			return strings.TrimSpace(s[:i]), s[i+1:]
+		}
+		return strings.TrimSpace(s), ""
+	}
+	value := strings.Builder{}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				value.WriteByte(s[i])
+			}
+		case '"':
+			return value.String(), s[i+1:]
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+	return value.String(), ""
+}
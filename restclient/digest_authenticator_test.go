@@ -0,0 +1,152 @@
+package restclient
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/* testDigestServer is a minimal RFC 7616 server accepting SHA-256 with qop=auth for username:0123 */
+type testDigestServer struct {
+	mu        sync.Mutex
+	nonce     string
+	staleNext bool
+	received  int
+	ncs       []string
+	bodies    []string
+}
+
+func (s *testDigestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received++
+
+	challenge := func(stale bool) {
+		w.Header().Add("WWW-Authenticate", `Basic realm="test"`)
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="test", qop="auth", algorithm=MD5, nonce="%s", opaque="op"`, s.nonce))
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="test", qop="auth,auth-int", algorithm=SHA-256, nonce="%s", opaque="op", stale=%t`, s.nonce, stale))
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	challenges := parseAuthChallenges([]string{r.Header.Get("Authorization")})
+	if len(challenges) != 1 || challenges[0].scheme != "Digest" {
+		challenge(false)
+		return
+	}
+	p := challenges[0].params
+	if s.staleNext {
+		s.staleNext = false
+		s.nonce = s.nonce + "-rotated"
+		challenge(true)
+		return
+	}
+	digest := func(parts ...string) string {
+		return hashHex(sha256.New, strings.Join(parts, ":"))
+	}
+	ha1 := digest(p["username"], "test", "0123")
+	expected := digest(ha1, s.nonce, p["nc"], p["cnonce"], p["qop"], digest(r.Method, p["uri"]))
+	if p["algorithm"] != "SHA-256" || p["nonce"] != s.nonce || p["opaque"] != "op" || p["response"] != expected || p["uri"] != r.URL.RequestURI() {
+		challenge(false)
+		return
+	}
+
+	s.ncs = append(s.ncs, p["nc"])
+	var body testRequestBody
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	s.bodies = append(s.bodies, body.TestName)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(testHttpResponse{StatusCode: http.StatusOK, Data: testSuccess})
+}
+
+func TestDigestAuthenticator(t *testing.T) {
+	server := &testDigestServer{nonce: "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v"}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	doGet := func(auth Authenticator, path string) (testHttpResponse, RequestError) {
+		var testResponse testHttpResponse
+		req, reqErr := RequestBuilder().
+			RawUrl(ts.URL + path).
+			Auth(auth).
+			ResponseReference(&testResponse).
+			Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+		return testResponse, req.Get()
+	}
+
+	Convey("TEST Digest authentication answers the challenge and reuses the nonce", t, func() {
+		auth := NewDigestAuthenticator("username", "0123")
+		first, firstErr := doGet(auth, "/tasks/1?tenantId=1")
+		receivedAfterFirst := server.received
+		_, secondErr := doGet(auth, "/tasks/2")
+
+		Convey("First request should be retried once and the second should be sent with nc incremented", func() {
+			So(firstErr, ShouldBeNil)
+			So(first.Data, ShouldEqual, testSuccess)
+			So(receivedAfterFirst, ShouldEqual, 2)
+			So(secondErr, ShouldBeNil)
+			So(server.received, ShouldEqual, 3)
+			So(server.ncs, ShouldResemble, []string{"00000001", "00000002"})
+		})
+	})
+
+	Convey("TEST Digest authenticator created without the constructor answers the challenge", t, func() {
+		server.received, server.ncs = 0, nil
+		response, reqErr := doGet(&DigestAuthenticator{Username: "username", Password: "0123"}, "/tasks/1")
+
+		So(reqErr, ShouldBeNil)
+		So(response.Data, ShouldEqual, testSuccess)
+		So(server.received, ShouldEqual, 2)
+	})
+
+	Convey("TEST request body is sent again when the request is retried", t, func() {
+		server.received, server.bodies = 0, nil
+		req, reqErr := RequestBuilder().
+			RawUrl(ts.URL).
+			BodyJson(testRequestBody{TestId: 123, TestName: "Testing Request Body"}).
+			Auth(NewDigestAuthenticator("username", "0123")).
+			Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+		reqErr = req.Post()
+
+		Convey("Authenticated retry should carry the body", func() {
+			So(reqErr, ShouldBeNil)
+			So(server.received, ShouldEqual, 2)
+			So(server.bodies, ShouldResemble, []string{"Testing Request Body"})
+		})
+	})
+
+	Convey("TEST stale nonce is refreshed transparently", t, func() {
+		auth := NewDigestAuthenticator("username", "0123")
+		_, reqErr := doGet(auth, "/")
+		server.received, server.staleNext = 0, true
+		_, reqErr = doGet(auth, "/")
+
+		Convey("Request should be retried with the new nonce", func() {
+			So(reqErr, ShouldBeNil)
+			So(server.received, ShouldEqual, 2)
+		})
+	})
+
+	Convey("TEST wrong credentials are not retried in a loop", t, func() {
+		server.received = 0
+		_, reqErr := doGet(NewDigestAuthenticator("username", "WRONG"), "/")
+
+		Convey("Request should fail with UnauthorizedErr after a single retry", func() {
+			So(reqErr, ShouldNotBeNil)
+			So(reqErr.GetTopLevelError(), ShouldEqual, UnauthorizedErr)
+			So(server.received, ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,566 @@
+package restclient
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultDownloadMinPartSize = 8 << 20
+
+/* DownloadConfig holds the configuration of HttpRequest.DownloadTo.
+- Parts: optional number of parallel range requests to split large files into, Zero (0) or 1 means a single request
+- MinPartSize: minimum size of a part, smaller files are downloaded with a single request, defaults to 8 MiB
+- SHA256: optional expected hex SHA-256 of the file, otherwise the file is verified against the Repr-Digest, Digest,
+  Content-Digest or Content-MD5 header of the response if there is one
+- Retries: number of times an interrupted download (or part) is resumed before DownloadTo gives up
+- Progress: optional callback with the number of bytes downloaded so far and the size of the file, -1 if unknown. It
+  is called from one goroutine at a time */
+type DownloadConfig struct {
+	Parts       int
+	MinPartSize int64
+	SHA256      string
+	Retries     int
+	Progress    func(downloaded, total int64)
+}
+
+/* downloadState is kept next to the partial file of a download so that it can be resumed */
+type downloadState struct {
+	Validator string          `json:"validator"`        // strong ETag or Last-Modified the partial file belongs to
+	Size      int64           `json:"size"`             // size of the file, -1 if unknown
+	Digest    *fileDigest     `json:"digest,omitempty"` // digest of the file announced by the server
+	Parts     []*downloadPart `json:"parts,omitempty"`  // ranges of a parallel download
+}
+
+/* downloadPart is a range of a parallel download */
+type downloadPart struct {
+	Start   int64 `json:"start"`
+	End     int64 `json:"end"` // inclusive
+	Written int64 `json:"written"`
+}
+
+/* fileDigest is an expected digest of a downloaded file, Source is where it comes from e.g. the Digest header */
+type fileDigest struct {
+	Algorithm string `json:"algorithm"`
+	Sum       []byte `json:"sum"`
+	Source    string `json:"source"`
+}
+
+/* download is a single run of HttpRequest.DownloadTo */
+type download struct {
+	hr     HttpRequest
+	path   string
+	config DownloadConfig
+
+	mu         sync.Mutex // guards state and downloaded
+	state      downloadState
+	downloaded int64
+}
+
+/* DownloadTo downloads the response body of a GET request to the file at path. The body is written to path + ".part"
+and renamed to path once it is complete and its checksum is verified. A download interrupted by a connection error
+is resumed with a Range request, as long as the file did not change according to If-Range, up to Retries times.
+Calling DownloadTo again later resumes it as well. The timeout of the request applies to each request it sends,
+set Timeout(0) for large files */
+func (hr HttpRequest) DownloadTo(path string, config DownloadConfig) RequestError {
+	if config.MinPartSize <= 0 {
+		config.MinPartSize = defaultDownloadMinPartSize
+	}
+	d := &download{hr: hr, path: path, config: config}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return NewRequestBuildError(InvalidRequestErr, errors.Wrapf(err, "Failed to create directory of %s", path))
+	}
+	d.loadState()
+
+	var reqErr RequestError
+	if config.Parts > 1 && d.prepareParallel() {
+		reqErr = d.downloadParallel()
+	} else {
+		reqErr = d.downloadSingle()
+	}
+	if reqErr != nil {
+		return reqErr
+	}
+	return d.finish()
+}
+
+func (d *download) partPath() string {
+	return d.path + ".part"
+}
+
+func (d *download) statePath() string {
+	return d.path + ".part.json"
+}
+
+/* loadState loads the state of a previous download to resume, the download starts over if there is none */
+func (d *download) loadState() {
+	d.state = downloadState{Size: -1}
+	content, err := ioutil.ReadFile(d.statePath())
+	if err != nil {
+		return
+	}
+	var state downloadState
+	if _, statErr := os.Stat(d.partPath()); statErr != nil || json.Unmarshal(content, &state) != nil {
+		return
+	}
+	d.state = state
+}
+
+/* saveState saves the state of the download, d.mu must be held */
+func (d *download) saveState() error {
+	content, err := json.Marshal(d.state)
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode download state")
+	}
+	return writeFileAtomic(d.statePath(), content)
+}
+
+/* clearState forgets the partial file, the next download starts over */
+func (d *download) clearState() {
+	_ = os.Remove(d.partPath())
+	_ = os.Remove(d.statePath())
+	d.mu.Lock()
+	d.state, d.downloaded = downloadState{Size: -1}, 0
+	d.mu.Unlock()
+}
+
+/* newRequest returns a copy of the request for the bytes from start to end (inclusive), end < 0 means up to the end
+of the file and start < 0 the whole file */
+func (d *download) newRequest(ctx context.Context, start, end int64) (*HttpRequest, RequestError) {
+	request, reqErr := d.hr.Clone()
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	if ctx != nil {
+		request.request = request.request.WithContext(ctx)
+	}
+	header := request.request.Header
+	if header.Get("Accept") == "" {
+		header.Set("Accept", "*/*")
+	}
+	if start >= 0 {
+		byteRange := fmt.Sprintf("bytes=%d-", start)
+		if end >= 0 {
+			byteRange += strconv.FormatInt(end, 10)
+		}
+		header.Set("Range", byteRange)
+		if d.state.Validator != "" {
+			header.Set("If-Range", d.state.Validator)
+		}
+	}
+	return request, nil
+}
+
+/* downloadSingle downloads the file with a single request, resuming the partial file if there is one */
+func (d *download) downloadSingle() RequestError {
+	var reqErr RequestError
+	restarted := false
+	for attempt := 0; attempt <= d.config.Retries; attempt++ {
+		offset := int64(-1)
+		if info, err := os.Stat(d.partPath()); err == nil && d.state.Validator != "" && d.state.Parts == nil && info.Size() > 0 {
+			offset = info.Size()
+		}
+		request, buildErr := d.newRequest(nil, offset, -1)
+		if buildErr != nil {
+			return buildErr
+		}
+		var fatal error
+		request.consume = func(resp *http.Response) error {
+			start := int64(0)
+			d.mu.Lock()
+			if resp.StatusCode == http.StatusPartialContent {
+				rangeStart, _, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+				if err != nil || rangeStart != offset {
+					d.mu.Unlock()
+					fatal = errors.Errorf("Unexpected Content-Range %q for a download from byte %d", resp.Header.Get("Content-Range"), offset)
+					return fatal
+				}
+				start, d.state.Size = offset, total
+				d.setDigest(resp.Header, true)
+			} else {
+				d.state = downloadState{Validator: strongValidator(resp.Header), Size: resp.ContentLength}
+				d.setDigest(resp.Header, false)
+			}
+			d.downloaded = start
+			err := d.saveState()
+			d.mu.Unlock()
+			if err != nil {
+				fatal = err
+				return err
+			}
+			file, err := d.openPartFile(start == 0)
+			if err != nil {
+				fatal = err
+				return err
+			}
+			defer file.Close()
+			return d.writeBody(file, resp.Body, start, -1, nil, &fatal)
+		}
+
+		if reqErr = doRequest(*request, http.MethodGet); reqErr == nil {
+			if d.state.Size >= 0 && d.downloaded != d.state.Size {
+				reqErr = NewRequestConnectionError(HttpClientErr, errors.Errorf("Download ended after %d of %d bytes", d.downloaded, d.state.Size))
+				continue
+			}
+			return nil
+		}
+		if reqErr.GetStatusCode() == http.StatusRequestedRangeNotSatisfiable && offset > 0 && !restarted {
+			// The partial file does not fit the file anymore, start over
+			d.clearState()
+			restarted = true
+			attempt--
+			continue
+		}
+		if fatal != nil || !isTransientError(reqErr) {
+			return reqErr
+		}
+	}
+	return reqErr
+}
+
+/* prepareParallel probes the file with a HEAD request and splits it into parts, it reports whether the file can be
+downloaded with parallel range requests */
+func (d *download) prepareParallel() bool {
+	request, reqErr := d.newRequest(nil, -1, -1)
+	if reqErr != nil {
+		return false
+	}
+	var header http.Header
+	var size int64
+	request.consume = func(resp *http.Response) error {
+		header, size = resp.Header, resp.ContentLength
+		return nil
+	}
+	if reqErr = doRequest(*request, http.MethodHead); reqErr != nil || header == nil {
+		return false
+	}
+	validator := strongValidator(header)
+	if validator == "" || !headerContainsToken(header, "Accept-Ranges", "bytes") || size < 2*d.config.MinPartSize {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.state.Validator != validator || d.state.Size != size || len(d.state.Parts) == 0 {
+		_ = os.Remove(d.partPath())
+		count := int64(d.config.Parts)
+		if size/d.config.MinPartSize < count {
+			count = size / d.config.MinPartSize
+		}
+		d.state = downloadState{Validator: validator, Size: size}
+		for i := int64(0); i < count; i++ {
+			d.state.Parts = append(d.state.Parts, &downloadPart{Start: size * i / count, End: size*(i+1)/count - 1})
+		}
+	}
+	d.setDigest(header, false)
+	d.downloaded = 0
+	for _, part := range d.state.Parts {
+		d.downloaded += part.Written
+	}
+	return d.saveState() == nil
+}
+
+/* downloadParallel downloads the parts of the file concurrently, the first error cancels the other parts */
+func (d *download) downloadParallel() RequestError {
+	file, err := d.openPartFile(false)
+	if err != nil {
+		return NewRequestBuildError(InvalidRequestErr, err)
+	}
+	ctx, cancel := context.WithCancel(d.hr.request.Context())
+	defer cancel()
+
+	results := make(chan RequestError, len(d.state.Parts))
+	changed := false
+	var wg sync.WaitGroup
+	for _, part := range d.state.Parts {
+		wg.Add(1)
+		go func(part *downloadPart) {
+			defer wg.Done()
+			partErr, partChanged := d.downloadPart(ctx, file, part)
+			if partErr != nil {
+				d.mu.Lock()
+				changed = changed || partChanged
+				d.mu.Unlock()
+				results <- partErr
+				cancel()
+			}
+		}(part)
+	}
+	wg.Wait()
+	close(results)
+
+	reqErr := <-results
+	if closeErr := file.Close(); closeErr != nil && reqErr == nil {
+		reqErr = NewRequestResponseParseError(InvalidResponseBodyErr, errors.Wrapf(closeErr, "Failed to write %s", d.partPath()))
+	}
+	if changed {
+		d.clearState()
+		return reqErr
+	}
+	d.mu.Lock()
+	if saveErr := d.saveState(); saveErr != nil && reqErr == nil {
+		reqErr = NewRequestResponseParseError(InvalidResponseBodyErr, saveErr)
+	}
+	d.mu.Unlock()
+	return reqErr
+}
+
+/* downloadPart downloads the rest of part into file, it reports whether the file changed in the meantime */
+func (d *download) downloadPart(ctx context.Context, file *os.File, part *downloadPart) (RequestError, bool) {
+	var reqErr RequestError
+	for attempt := 0; attempt <= d.config.Retries; attempt++ {
+		d.mu.Lock()
+		start := part.Start + part.Written
+		d.mu.Unlock()
+		if start > part.End {
+			return nil, false
+		}
+		request, buildErr := d.newRequest(ctx, start, part.End)
+		if buildErr != nil {
+			return buildErr, false
+		}
+		var fatal error
+		changed := false
+		request.consume = func(resp *http.Response) error {
+			rangeStart, rangeEnd, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+			if resp.StatusCode != http.StatusPartialContent || err != nil || rangeStart != start || rangeEnd != part.End {
+				changed = true
+				fatal = errors.New("File changed during the download or the server ignored the range")
+				return fatal
+			}
+			return d.writeBody(file, resp.Body, start, part.End-start+1, part, &fatal)
+		}
+
+		if reqErr = doRequest(*request, http.MethodGet); reqErr == nil {
+			continue
+		}
+		if fatal != nil || ctx.Err() != nil || !isTransientError(reqErr) {
+			return reqErr, changed
+		}
+	}
+	d.mu.Lock()
+	written, size := part.Written, part.End-part.Start+1
+	d.mu.Unlock()
+	if written >= size {
+		return nil, false
+	}
+	if reqErr == nil {
+		reqErr = NewRequestConnectionError(HttpClientErr, errors.Errorf("Download of bytes %d-%d ended after %d of %d bytes", part.Start, part.End, written, size))
+	}
+	return reqErr, false
+}
+
+/* openPartFile opens the partial file for writing, truncated if truncate is set */
+func (d *download) openPartFile(truncate bool) (*os.File, error) {
+	flag := os.O_CREATE | os.O_WRONLY
+	if truncate {
+		flag |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(d.partPath(), flag, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open %s", d.partPath())
+	}
+	return file, nil
+}
+
+/* writeBody writes body to the partial file from offset on, up to limit bytes if limit is not negative. Write errors
+are stored in fatal as resuming does not help with them */
+func (d *download) writeBody(file *os.File, body io.Reader, offset, limit int64, part *downloadPart, fatal *error) error {
+	if limit >= 0 {
+		body = io.LimitReader(body, limit)
+	}
+	writer := &downloadWriter{d: d, file: file, offset: offset, part: part}
+	_, err := io.Copy(writer, body)
+	if writer.err != nil {
+		*fatal = errors.Wrapf(writer.err, "Failed to write %s", d.partPath())
+		return *fatal
+	}
+	// The written bytes are only recorded in the state once they are on disk
+	if syncErr := file.Sync(); syncErr != nil {
+		*fatal = errors.Wrapf(syncErr, "Failed to write %s", d.partPath())
+		return *fatal
+	}
+	if err == nil && part != nil {
+		d.mu.Lock()
+		err = d.saveState()
+		d.mu.Unlock()
+	}
+	return err
+}
+
+/* downloadWriter writes to the partial file at its offset and reports the progress of the download */
+type downloadWriter struct {
+	d      *download
+	file   *os.File
+	offset int64
+	part   *downloadPart
+	err    error // write error, as opposed to read errors of the body
+}
+
+func (dw *downloadWriter) Write(p []byte) (int, error) {
+	n, err := dw.file.WriteAt(p, dw.offset)
+	dw.offset += int64(n)
+	if err != nil {
+		dw.err = err
+	}
+	d := dw.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.downloaded += int64(n)
+	if dw.part != nil {
+		dw.part.Written += int64(n)
+	}
+	if d.config.Progress != nil && n > 0 {
+		d.config.Progress(d.downloaded, d.state.Size)
+	}
+	return n, err
+}
+
+/* setDigest remembers the digest of the file announced in header. Content-Digest and Content-MD5 only describe the
+file if the response is not partial. d.mu must be held */
+func (d *download) setDigest(header http.Header, partial bool) {
+	if digest := parseFileDigest(header, partial); digest != nil {
+		d.state.Digest = digest
+	}
+}
+
+/* finish verifies the checksum of the partial file and renames it to the path of the download */
+func (d *download) finish() RequestError {
+	expected := d.state.Digest
+	if d.config.SHA256 != "" {
+		sum, err := hex.DecodeString(d.config.SHA256)
+		if err != nil {
+			return NewRequestBuildError(InvalidRequestErr, errors.Wrap(err, "Invalid SHA256 checksum"))
+		}
+		expected = &fileDigest{Algorithm: "sha-256", Sum: sum, Source: "SHA256"}
+	}
+	if expected != nil {
+		if err := verifyFileDigest(d.partPath(), expected); err != nil {
+			d.clearState()
+			return NewRequestError(ChecksumMismatchErr, err, 0)
+		}
+	}
+	if err := os.Rename(d.partPath(), d.path); err != nil {
+		return NewRequestBuildError(InvalidRequestErr, errors.Wrapf(err, "Failed to rename %s", d.partPath()))
+	}
+	_ = os.Remove(d.statePath())
+	return nil
+}
+
+/* verifyFileDigest checks the file at path against digest */
+func verifyFileDigest(path string, digest *fileDigest) error {
+	var hasher hash.Hash
+	switch digest.Algorithm {
+	case "sha-256":
+		hasher = sha256.New()
+	case "sha-512":
+		hasher = sha512.New()
+	case "md5":
+		hasher = md5.New()
+	default:
+		return errors.Errorf("Unsupported digest algorithm %s", digest.Algorithm)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open %s", path)
+	}
+	defer file.Close()
+	if _, err = io.Copy(hasher, file); err != nil {
+		return errors.Wrapf(err, "Failed to read %s", path)
+	}
+	if subtle.ConstantTimeCompare(hasher.Sum(nil), digest.Sum) != 1 {
+		return errors.Errorf("%s %s does not match the downloaded file", digest.Source, digest.Algorithm)
+	}
+	return nil
+}
+
+/* parseFileDigest returns the first supported digest of the whole file in header, nil if there is none */
+func parseFileDigest(header http.Header, partial bool) *fileDigest {
+	sources := []string{"Repr-Digest"}
+	if !partial {
+		sources = append(sources, "Content-Digest")
+	}
+	for _, source := range sources {
+		members, err := parseSFDictionary(strings.Join(header.Values(source), ", "))
+		if err != nil {
+			continue
+		}
+		for _, member := range members {
+			if sum, ok := member.item.value.([]byte); ok && (member.name == "sha-256" || member.name == "sha-512") {
+				return &fileDigest{Algorithm: member.name, Sum: sum, Source: source}
+			}
+		}
+	}
+	// Digest (RFC 3230) e.g. SHA-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=
+	for _, value := range header.Values("Digest") {
+		for _, element := range strings.Split(value, ",") {
+			i := strings.Index(element, "=")
+			if i < 0 {
+				continue
+			}
+			algorithm := strings.ToLower(strings.TrimSpace(element[:i]))
+			sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(element[i+1:]))
+			if err == nil && (algorithm == "sha-256" || algorithm == "sha-512" || algorithm == "md5") {
+				return &fileDigest{Algorithm: algorithm, Sum: sum, Source: "Digest"}
+			}
+		}
+	}
+	if contentMD5 := header.Get("Content-MD5"); contentMD5 != "" && !partial {
+		if sum, err := base64.StdEncoding.DecodeString(contentMD5); err == nil {
+			return &fileDigest{Algorithm: "md5", Sum: sum, Source: "Content-MD5"}
+		}
+	}
+	return nil
+}
+
+/* strongValidator returns the validator to send as If-Range, the strong ETag or else the Last-Modified date of
+header, empty if there is none */
+func strongValidator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+/* parseContentRange parses a Content-Range header e.g. bytes 0-499/1234, total is -1 if unknown */
+func parseContentRange(value string) (int64, int64, int64, error) {
+	invalid := errors.Errorf("Invalid Content-Range %q", value)
+	if !strings.HasPrefix(value, "bytes ") {
+		return 0, 0, 0, invalid
+	}
+	byteRange := strings.TrimPrefix(value, "bytes ")
+	slash := strings.Index(byteRange, "/")
+	dash := strings.Index(byteRange, "-")
+	if slash < 0 || dash < 0 || dash > slash {
+		return 0, 0, 0, invalid
+	}
+	start, startErr := strconv.ParseInt(byteRange[:dash], 10, 64)
+	end, endErr := strconv.ParseInt(byteRange[dash+1:slash], 10, 64)
+	if startErr != nil || endErr != nil || end < start {
+		return 0, 0, 0, invalid
+	}
+	total := int64(-1)
+	if byteRange[slash+1:] != "*" {
+		var err error
+		if total, err = strconv.ParseInt(byteRange[slash+1:], 10, 64); err != nil {
+			return 0, 0, 0, invalid
+		}
+	}
+	return start, end, total, nil
+}
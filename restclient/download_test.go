@@ -0,0 +1,229 @@
+package restclient
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+/* testFileServer serves a file with ranges, its SHA-256 as Repr-Digest on /digest and a wrong one on /bad-digest. Range
+requests of /short are answered with half of the range without a Content-Length */
+type testFileServer struct {
+	mu       sync.Mutex
+	content  []byte
+	etag     string
+	failures int      // number of GET responses to abort after abortAt bytes
+	abortAt  int      // number of bytes after which a failing response is aborted
+	requests []string // method and Range header of each request
+}
+
+/* abortingWriter aborts the response after limit bytes as if the connection dropped */
+type abortingWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (aw *abortingWriter) Write(p []byte) (int, error) {
+	if len(p) > aw.limit {
+		_, _ = aw.ResponseWriter.Write(p[:aw.limit])
+		aw.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	aw.limit -= len(p)
+	return aw.ResponseWriter.Write(p)
+}
+
+func (s *testFileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.Header.Get("Range")+" "+r.Header.Get("If-Range"))
+	content, etag := s.content, s.etag
+	if r.Method == http.MethodGet && s.failures > 0 {
+		s.failures--
+		w = &abortingWriter{ResponseWriter: w, limit: s.abortAt}
+	}
+	s.mu.Unlock()
+
+	sum := sha256.Sum256(content)
+	switch r.URL.Path {
+	case "/digest":
+		w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+	case "/bad-digest":
+		w.Header().Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	}
+	w.Header().Set("ETag", etag)
+	if start, end, ok := parseTestRange(r.Header.Get("Range")); r.URL.Path == "/short" && ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(content[start : start+(end-start+1)/2])
+		return
+	}
+	http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+}
+
+func parseTestRange(value string) (int, int, bool) {
+	var start, end int
+	_, err := fmt.Sscanf(value, "bytes=%d-%d", &start, &end)
+	return start, end, err == nil
+}
+
+func TestDownloadTo(t *testing.T) {
+	server := &testFileServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	dir, _ := ioutil.TempDir("", "restclient-download")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "artifacts", "file.bin")
+
+	reset := func(size int) {
+		server.mu.Lock()
+		server.content = make([]byte, size)
+		_, _ = rand.Read(server.content)
+		server.etag, server.failures, server.abortAt, server.requests = `"v1"`, 0, 0, nil
+		server.mu.Unlock()
+		_ = os.RemoveAll(filepath.Dir(path))
+	}
+	download := func(urlPath string, config DownloadConfig) RequestError {
+		request, _ := RequestBuilder().RawUrl(ts.URL + urlPath).Build()
+		return request.DownloadTo(path, config)
+	}
+	downloaded := func() []byte {
+		content, _ := ioutil.ReadFile(path)
+		return content
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	Convey("TEST A file is downloaded and verified against its SHA-256", t, func() {
+		reset(100000)
+		sum := sha256.Sum256(server.content)
+		var progress [][2]int64
+		reqErr := download("/file", DownloadConfig{SHA256: hex.EncodeToString(sum[:]), Progress: func(downloaded, total int64) {
+			progress = append(progress, [2]int64{downloaded, total})
+		}})
+
+		So(reqErr, ShouldBeNil)
+		So(bytes.Equal(downloaded(), server.content), ShouldBeTrue)
+		So(exists(path+".part"), ShouldBeFalse)
+		So(exists(path+".part.json"), ShouldBeFalse)
+		So(len(progress), ShouldBeGreaterThan, 0)
+		So(progress[len(progress)-1], ShouldResemble, [2]int64{100000, 100000})
+	})
+
+	Convey("TEST Checksum mismatches fail the download", t, func() {
+		reset(1000)
+		reqErr := download("/file", DownloadConfig{SHA256: hex.EncodeToString(make([]byte, 32))})
+		So(reqErr, ShouldNotBeNil)
+		So(reqErr.GetTopLevelError(), ShouldEqual, ChecksumMismatchErr)
+		So(exists(path), ShouldBeFalse)
+		So(exists(path+".part"), ShouldBeFalse)
+
+		reqErr = download("/bad-digest", DownloadConfig{})
+		So(reqErr, ShouldNotBeNil)
+		So(reqErr.GetMessage(), ShouldContainSubstring, "Digest sha-256 does not match")
+
+		So(download("/digest", DownloadConfig{}), ShouldBeNil)
+		So(bytes.Equal(downloaded(), server.content), ShouldBeTrue)
+	})
+
+	Convey("TEST An interrupted download is resumed with Range and If-Range", t, func() {
+		reset(100000)
+		server.failures, server.abortAt = 1, 30000
+		reqErr := download("/digest", DownloadConfig{Retries: 1})
+
+		So(reqErr, ShouldBeNil)
+		So(bytes.Equal(downloaded(), server.content), ShouldBeTrue)
+		So(server.requests, ShouldHaveLength, 2)
+		So(server.requests[0], ShouldEqual, "GET  ")
+		So(server.requests[1], ShouldStartWith, "GET bytes=")
+		So(server.requests[1], ShouldEndWith, `- "v1"`)
+	})
+
+	Convey("TEST An interrupted download is resumed by the next download", t, func() {
+		reset(100000)
+		server.failures, server.abortAt = 1, 30000
+		firstErr := download("/digest", DownloadConfig{})
+		So(firstErr, ShouldNotBeNil)
+		So(exists(path), ShouldBeFalse)
+		So(exists(path+".part"), ShouldBeTrue)
+
+		So(download("/digest", DownloadConfig{}), ShouldBeNil)
+		So(bytes.Equal(downloaded(), server.content), ShouldBeTrue)
+		So(server.requests[1], ShouldStartWith, "GET bytes=")
+	})
+
+	Convey("TEST A download starts over once the file changed", t, func() {
+		reset(100000)
+		server.failures, server.abortAt = 1, 30000
+		So(download("/file", DownloadConfig{}), ShouldNotBeNil)
+		server.mu.Lock()
+		server.content, server.etag = bytes.Repeat([]byte("new"), 20000), `"v2"`
+		server.mu.Unlock()
+
+		So(download("/digest", DownloadConfig{}), ShouldBeNil)
+		So(bytes.Equal(downloaded(), server.content), ShouldBeTrue)
+		So(server.requests[1], ShouldEndWith, `- "v1"`)
+	})
+
+	Convey("TEST Large files are downloaded with parallel range requests", t, func() {
+		reset(100000)
+		server.failures, server.abortAt = 1, 5000
+		var last int64
+		reqErr := download("/digest", DownloadConfig{Parts: 4, MinPartSize: 10000, Retries: 1, Progress: func(downloaded, total int64) {
+			last = downloaded
+		}})
+
+		So(reqErr, ShouldBeNil)
+		So(bytes.Equal(downloaded(), server.content), ShouldBeTrue)
+		So(last, ShouldEqual, 100000)
+		So(server.requests[0], ShouldEqual, "HEAD  ")
+		So(server.requests, ShouldContain, `GET bytes=0-24999 "v1"`)
+		So(server.requests, ShouldContain, `GET bytes=75000-99999 "v1"`)
+		So(server.requests, ShouldHaveLength, 6)
+	})
+
+	Convey("TEST Parts that end early fail the download", t, func() {
+		reset(100000)
+		reqErr := download("/short", DownloadConfig{Parts: 4, MinPartSize: 10000})
+
+		So(reqErr, ShouldNotBeNil)
+		So(reqErr.ConnectionError(), ShouldBeTrue)
+		So(reqErr.GetMessage(), ShouldContainSubstring, "ended after 12500 of 25000 bytes")
+		So(exists(path), ShouldBeFalse)
+	})
+
+	Convey("TEST Small files are downloaded with a single request", t, func() {
+		reset(1000)
+		So(download("/file", DownloadConfig{Parts: 4, MinPartSize: 10000}), ShouldBeNil)
+		So(bytes.Equal(downloaded(), server.content), ShouldBeTrue)
+		So(server.requests, ShouldResemble, []string{"HEAD  ", "GET  "})
+	})
+}
+
+func TestParseContentRange(t *testing.T) {
+	Convey("TEST Content-Range headers are parsed", t, func() {
+		start, end, total, err := parseContentRange("bytes 100-199/1000")
+		So(err, ShouldBeNil)
+		So([]int64{start, end, total}, ShouldResemble, []int64{100, 199, 1000})
+
+		_, _, total, err = parseContentRange("bytes 0-9/*")
+		So(err, ShouldBeNil)
+		So(total, ShouldEqual, -1)
+
+		_, _, _, err = parseContentRange("bytes */1000")
+		So(err, ShouldNotBeNil)
+	})
+}
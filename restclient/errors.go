@@ -1,6 +1,7 @@
 package restclient
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
 	"net/http"
@@ -19,6 +20,11 @@ var (
 	UnprocessableEntityErr    = errors.New("Syntactically correct but semantically incorrect request")
 	InternalServerErr         = errors.New("Internal server error")
 	ServiceUnavailableErr     = errors.New("Service unavailable")
+	ConcurrencyLimitErr       = errors.New("Concurrency limit reached - Request could not be scheduled")
+	ResponseVerificationErr   = errors.New("Response verification failed - Response could not be authenticated")
+	RedirectErr               = errors.New("Redirect not followed")
+	PreconditionFailedErr     = errors.New("Precondition failed - Resource was modified by someone else")
+	ChecksumMismatchErr       = errors.New("Checksum mismatch - Downloaded file is corrupted")
 )
 
 type RequestError interface {
@@ -32,6 +38,7 @@ type RequestError interface {
 	ConnectionError() bool     // ConnectionError returns if request failed due to a connection error (Failed to get response for some reason)
 	ResponseParseError() bool  // ResponseParseError returns if response of the request could not be parsed into given response reference variable
 	RequestBuildError() bool   // RequestBuildError returns if request could not be built due to some reason
+	PreconditionFailed() bool  // PreconditionFailed returns if request failed as its If-Match or If-Unmodified-Since precondition did not hold (412)
 }
 
 type requestErrorImpl struct {
@@ -76,6 +83,10 @@ func (r requestErrorImpl) RequestBuildError() bool {
 	return r.isRequestBuildErr
 }
 
+func (r requestErrorImpl) PreconditionFailed() bool {
+	return r.topLevelErr == PreconditionFailedErr
+}
+
 func (r requestErrorImpl) Error() string {
 	return fmt.Sprintf("%s - %s - Status Code: %d", r.GetTitle(), r.GetMessage(), r.GetStatusCode())
 }
@@ -121,3 +132,18 @@ func NewRequestResponseParseError(topLevelErr, err error) RequestError {
 		isResponseParseErr: true,
 	}
 }
+
+/* cancelledError is the error of an operation, e.g. a pagination, that was cancelled along with ctx */
+func cancelledError(ctx context.Context, operation string) RequestError {
+	return NewRequestConnectionError(HttpClientErr, errors.Wrapf(ctx.Err(), "%s was cancelled", operation))
+}
+
+/* isTransientError reports whether a request failing with reqErr may succeed when it is sent again e.g. an EventSource
+reconnects or a download resumes after it */
+func isTransientError(reqErr RequestError) bool {
+	if reqErr.ConnectionError() || reqErr.ResponseParseError() {
+		return true
+	}
+	status := reqErr.GetStatusCode()
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
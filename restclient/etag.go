@@ -0,0 +1,117 @@
+package restclient
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const defaultReadModifyWriteAttempts = 3
+
+/* ETagTracker remembers the ETag of resources by URL for optimistic concurrency control, set it with
+HttpRequestBuilder.TrackETags. The ETag of successful GET, PUT and PATCH responses is remembered and sent as If-Match
+with the following PUT, PATCH and DELETE requests to the same URL, so that they fail with PreconditionFailedErr
+instead of silently overwriting the changes of someone else. Weak ETags are not remembered as If-Match never matches
+them. A precondition failure keeps the outdated ETag, read the resource again to write it */
+type ETagTracker struct {
+	mu    sync.Mutex
+	etags map[string]string
+}
+
+func NewETagTracker() *ETagTracker {
+	return &ETagTracker{etags: make(map[string]string)}
+}
+
+/* ETag returns the remembered ETag of the resource at rawURL, empty if there is none */
+func (et *ETagTracker) ETag(rawURL string) string {
+	et.mu.Lock()
+	defer et.mu.Unlock()
+	return et.etags[rawURL]
+}
+
+/* Forget forgets the ETag of the resource at rawURL, its next write is sent without If-Match */
+func (et *ETagTracker) Forget(rawURL string) {
+	et.mu.Lock()
+	defer et.mu.Unlock()
+	delete(et.etags, rawURL)
+}
+
+/* applyIfMatch sets the If-Match header of writes to the remembered ETag unless the request has one already */
+func (et *ETagTracker) applyIfMatch(req *http.Request) {
+	switch req.Method {
+	case http.MethodPut, http.MethodPatch, http.MethodDelete:
+		if etag := et.ETag(req.URL.String()); etag != "" && req.Header.Get("If-Match") == "" {
+			req.Header.Set("If-Match", etag)
+		}
+	}
+}
+
+/* record remembers the ETag of a successful response, or forgets it once the resource is deleted */
+func (et *ETagTracker) record(req *http.Request, resp *http.Response) {
+	key := req.URL.String()
+	switch req.Method {
+	case http.MethodGet, http.MethodPut, http.MethodPatch:
+		etag := resp.Header.Get("ETag")
+		if etag == "" || strings.HasPrefix(etag, "W/") {
+			// The resource changed but its new ETag is unknown, the next write needs a fresh read
+			if req.Method != http.MethodGet {
+				et.Forget(key)
+			}
+			return
+		}
+		et.mu.Lock()
+		et.etags[key] = etag
+		et.mu.Unlock()
+	case http.MethodDelete:
+		et.Forget(key)
+	}
+}
+
+/* ReadModifyWrite updates a resource with optimistic concurrency control. It GETs the resource into resource, lets
+modify change it and PUTs it back with the ETag of the read as If-Match. When the write fails with 412 Precondition
+Failed, as the resource was changed in between, the loop starts over with a fresh read, up to maxAttempts (defaults
+to 3) times. newBuilder returns a builder of the resource URL with everything but the body and the response
+reference, e.g. its auth. resource is reset to its zero value before each read */
+func ReadModifyWrite(newBuilder func() HttpRequestBuilder, resource interface{}, modify func() error, maxAttempts int) RequestError {
+	if maxAttempts <= 0 {
+		maxAttempts = defaultReadModifyWriteAttempts
+	}
+	value := reflect.ValueOf(resource)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return NewRequestBuildError(InvalidRequestErr, errors.Errorf("ReadModifyWrite needs a non-nil pointer resource, got %T", resource))
+	}
+
+	var reqErr RequestError
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		tracker := NewETagTracker()
+		value.Elem().Set(reflect.Zero(value.Elem().Type()))
+		read, buildErr := newBuilder().TrackETags(tracker).ResponseReference(resource).Build()
+		if buildErr != nil {
+			return buildErr
+		}
+		if attempt > 0 {
+			// The resource was changed in between, a cached copy of it is outdated
+			read.request.Header.Set("Cache-Control", "no-cache")
+		}
+		if reqErr = read.Get(); reqErr != nil {
+			return reqErr
+		}
+		if tracker.ETag(read.request.URL.String()) == "" {
+			return NewRequestBuildError(InvalidRequestErr, errors.New("Resource was read without a strong ETag, it cannot be written safely"))
+		}
+
+		if err := modify(); err != nil {
+			return NewRequestBuildError(InvalidRequestErr, errors.Wrap(err, "Failed to modify resource"))
+		}
+		write, buildErr := newBuilder().TrackETags(tracker).BodyJson(resource).Build()
+		if buildErr != nil {
+			return buildErr
+		}
+		if reqErr = write.Put(); reqErr == nil || !reqErr.PreconditionFailed() {
+			return reqErr
+		}
+	}
+	return reqErr
+}
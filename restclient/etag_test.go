@@ -0,0 +1,130 @@
+package restclient
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+/* testVersionedServer serves a single task resource whose ETag is its version, writes need a matching If-Match */
+type testVersionedServer struct {
+	mu        sync.Mutex
+	version   int
+	task      testRequestBody
+	ifMatches []string
+	conflicts int // number of writes to make conflict by changing the task right before them
+}
+
+func (s *testVersionedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	etag := fmt.Sprintf(`"v%d"`, s.version)
+	if r.Method == http.MethodGet {
+		w.Header().Set("ETag", etag)
+		_ = json.NewEncoder(w).Encode(s.task)
+		return
+	}
+	s.ifMatches = append(s.ifMatches, r.Method+" "+r.Header.Get("If-Match"))
+	if s.conflicts > 0 {
+		s.conflicts--
+		s.version++
+		s.task.TestName += " (changed)"
+		etag = fmt.Sprintf(`"v%d"`, s.version)
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != etag {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		_, _ = w.Write([]byte("version mismatch"))
+		return
+	}
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	_ = json.NewDecoder(r.Body).Decode(&s.task)
+	s.version++
+	w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, s.version))
+	_ = json.NewEncoder(w).Encode(s.task)
+}
+
+func TestETagTracker(t *testing.T) {
+	server := &testVersionedServer{version: 1, task: testRequestBody{TestId: 1, TestName: "task"}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	reset := func(conflicts int) {
+		server.mu.Lock()
+		server.version, server.task, server.ifMatches, server.conflicts = 1, testRequestBody{TestId: 1, TestName: "task"}, nil, conflicts
+		server.mu.Unlock()
+	}
+	newBuilder := func() HttpRequestBuilder {
+		return RequestBuilder().RawUrl(ts.URL + "/tasks/1")
+	}
+
+	Convey("TEST ETag of a read is sent as If-Match with the following writes", t, func() {
+		reset(0)
+		tracker := NewETagTracker()
+		var task testRequestBody
+		var metadata ResponseMetadata
+		read, _ := newBuilder().TrackETags(tracker).ResponseMetadata(&metadata).ResponseReference(&task).Build()
+		readErr := read.Get()
+		firstWrite, _ := newBuilder().TrackETags(tracker).BodyJson(testRequestBody{TestId: 1, TestName: "first"}).Build()
+		firstErr := firstWrite.Put()
+		secondWrite, _ := newBuilder().TrackETags(tracker).BodyJson(testRequestBody{TestId: 1, TestName: "second"}).Build()
+		secondErr := secondWrite.Patch()
+		deleteRequest, _ := newBuilder().TrackETags(tracker).Build()
+		deleteErr := deleteRequest.Delete()
+
+		So(readErr, ShouldBeNil)
+		So(metadata.ETag, ShouldEqual, `"v1"`)
+		So(firstErr, ShouldBeNil)
+		So(secondErr, ShouldBeNil)
+		So(deleteErr, ShouldBeNil)
+		So(server.ifMatches, ShouldResemble, []string{`PUT "v1"`, `PATCH "v2"`, `DELETE "v3"`})
+		So(tracker.ETag(ts.URL+"/tasks/1"), ShouldBeEmpty)
+	})
+
+	Convey("TEST lost updates fail with a typed precondition error", t, func() {
+		reset(1)
+		tracker := NewETagTracker()
+		read, _ := newBuilder().TrackETags(tracker).Build()
+		_ = read.Get()
+		write, _ := newBuilder().TrackETags(tracker).BodyJson(testRequestBody{TestId: 1, TestName: "mine"}).Build()
+		reqErr := write.Put()
+
+		So(reqErr, ShouldNotBeNil)
+		So(reqErr.PreconditionFailed(), ShouldBeTrue)
+		So(reqErr.GetTopLevelError(), ShouldEqual, PreconditionFailedErr)
+		So(reqErr.GetStatusCode(), ShouldEqual, http.StatusPreconditionFailed)
+		So(server.task.TestName, ShouldEqual, "task (changed)")
+		So(tracker.ETag(ts.URL+"/tasks/1"), ShouldEqual, `"v1"`)
+	})
+
+	Convey("TEST read-modify-write retries with a fresh read on conflicts", t, func() {
+		reset(2)
+		var task testRequestBody
+		modifications := 0
+		reqErr := ReadModifyWrite(newBuilder, &task, func() error {
+			modifications++
+			task.TestName += " (renamed)"
+			return nil
+		}, 3)
+
+		So(reqErr, ShouldBeNil)
+		So(modifications, ShouldEqual, 3)
+		So(server.task.TestName, ShouldEqual, "task (changed) (changed) (renamed)")
+		So(server.ifMatches, ShouldResemble, []string{`PUT "v1"`, `PUT "v2"`, `PUT "v3"`})
+	})
+
+	Convey("TEST read-modify-write gives up after the last attempt", t, func() {
+		reset(5)
+		var task testRequestBody
+		reqErr := ReadModifyWrite(newBuilder, &task, func() error { return nil }, 2)
+
+		So(reqErr, ShouldNotBeNil)
+		So(reqErr.PreconditionFailed(), ShouldBeTrue)
+		So(len(server.ifMatches), ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,29 @@
+package restclient
+
+import (
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+/* writeFileAtomic writes content to a temporary file only readable by the current user and renames it to path, so
+that a crash never leaves a partial file */
+func writeFileAtomic(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrapf(err, "Failed to create directory of %s", path)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create %s", path)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return errors.Wrapf(err, "Failed to write %s", path)
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrapf(err, "Failed to write %s", path)
+	}
+	return errors.Wrapf(os.Rename(tmp.Name(), path), "Failed to write %s", path)
+}
@@ -0,0 +1,45 @@
+package restclient
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+/* HeaderAuthenticator sets Header to "<Scheme> <token>" (or only the token if Scheme is empty) for arbitrary
+authentication schemes e.g. Header: "Authorization", Scheme: "Token" */
+type HeaderAuthenticator struct {
+	Header, Scheme string
+	Source         TokenSource
+}
+
+func NewHeaderAuthenticator(header, scheme, value string) Authenticator {
+	return &HeaderAuthenticator{
+		Header: header,
+		Scheme: scheme,
+		Source: StaticTokenSource(value),
+	}
+}
+
+/* NewHeaderSourceAuthenticator creates a HeaderAuthenticator that fetches the value from source for each request */
+func NewHeaderSourceAuthenticator(header, scheme string, source TokenSource) Authenticator {
+	return &HeaderAuthenticator{
+		Header: header,
+		Scheme: scheme,
+		Source: source,
+	}
+}
+
+func (ha HeaderAuthenticator) Apply(request *http.Request) error {
+	if ha.Source == nil {
+		return errors.Errorf("Token source is required to set the %s header", ha.Header)
+	}
+	token, err := ha.Source.Token()
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get value for %s header", ha.Header)
+	}
+	if ha.Scheme != "" {
+		token = ha.Scheme + " " + token
+	}
+	request.Header.Set(ha.Header, token)
+	return nil
+}
@@ -0,0 +1,203 @@
+package restclient
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHedgingMinSamples = 20
+	hedgingLatencyWindowSize = 128
+)
+
+/* HedgingConfig holds the configuration of a HedgingPolicy.
+- Delay: how long to wait for a response before sending another copy of the request
+- Percentile: optional latency percentile in (0, 1) e.g. 0.95, once enough samples are observed the delay becomes
+  that percentile of the recently observed latencies instead of Delay
+- MinSamples: number of latency samples needed before Percentile is used, defaults to 20
+- MaxHedges: maximum number of extra copies to send, defaults to 1 */
+type HedgingConfig struct {
+	Delay      time.Duration
+	Percentile float64
+	MinSamples int
+	MaxHedges  int
+}
+
+/* HedgingPolicy sends extra copies of idempotent requests (GET, HEAD, OPTIONS, PUT and DELETE) whose response has not
+arrived after a delay. The first successful response wins and the other copies are cancelled. A HedgingPolicy keeps the
+observed latencies, share the same policy between requests to the same kind of endpoint. If the request is
+concurrency limited each extra copy needs a free slot of its own, copies are not sent while the destination is full */
+type HedgingPolicy struct {
+	config  HedgingConfig
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+/* errNoFreeSlot is returned when an extra copy could not be sent because its destination is at its concurrency limit */
+var errNoFreeSlot = errors.New("no free slot for another copy")
+
+/* hedgeResult is the outcome of a single copy of a hedged request */
+type hedgeResult struct {
+	id      int
+	resp    *http.Response
+	err     error
+	cancel  context.CancelFunc
+	latency time.Duration
+}
+
+func NewHedgingPolicy(config HedgingConfig) *HedgingPolicy {
+	if config.MaxHedges < 1 {
+		config.MaxHedges = 1
+	}
+	if config.MinSamples < 1 {
+		config.MinSamples = defaultHedgingMinSamples
+	}
+	return &HedgingPolicy{config: config}
+}
+
+/* HedgeDelay returns the delay that is going to be waited before sending the next copy of a request */
+func (hp *HedgingPolicy) HedgeDelay() time.Duration {
+	if hp.config.Percentile <= 0 || hp.config.Percentile >= 1 {
+		return hp.config.Delay
+	}
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	if len(hp.samples) < hp.config.MinSamples {
+		return hp.config.Delay
+	}
+	sorted := make([]time.Duration, len(hp.samples))
+	copy(sorted, hp.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(float64(len(sorted)-1)*hp.config.Percentile)]
+}
+
+func (hp *HedgingPolicy) recordLatency(latency time.Duration) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	if len(hp.samples) < hedgingLatencyWindowSize {
+		hp.samples = append(hp.samples, latency)
+		return
+	}
+	hp.samples[hp.next] = latency
+	hp.next = (hp.next + 1) % hedgingLatencyWindowSize
+}
+
+/* isIdempotentMethod reports whether requests with the given method can safely be sent more than once */
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+/* isHedgeSuccess reports whether a copy's result is final, server errors and transport errors are not */
+func isHedgeSuccess(r hedgeResult) bool {
+	return r.err == nil && r.resp.StatusCode < http.StatusInternalServerError
+}
+
+/* do sends req using send, hedging it according to the policy. The first copy runs in the slot req holds in limiter
+(which may be nil), extra copies are only sent if they get a free slot. It returns the winning response together with
+the cancel function of its context which must be called once the response body is consumed. If no copy succeeds the
+result of the first failed copy is returned */
+func (hp *HedgingPolicy) do(req *http.Request, limiter *ConcurrencyLimiter, send func(*http.Request) (*http.Response, error)) (*http.Response, context.CancelFunc, error) {
+	maxAttempts := hp.config.MaxHedges + 1
+	results := make(chan hedgeResult, maxAttempts)
+	cancels := make([]context.CancelFunc, 0, maxAttempts)
+	launched, pending := 0, 0
+
+	launch := func() error {
+		ctx, cancel := context.WithCancel(req.Context())
+		if launched > 0 && limiter != nil {
+			permit := limiter.tryAcquire(req)
+			if permit == nil {
+				cancel()
+				return errNoFreeSlot
+			}
+			// The slot is held until the copy is cancelled, for the winner that is once its body is consumed
+			var once sync.Once
+			cancelContext := cancel
+			cancel = func() {
+				cancelContext()
+				once.Do(func() { permit.release(false) })
+			}
+		}
+		attempt, err := cloneRequest(ctx, req)
+		if err != nil {
+			cancel()
+			return err
+		}
+		id := launched
+		cancels = append(cancels, cancel)
+		launched++
+		pending++
+		start := time.Now()
+		go func() {
+			resp, err := send(attempt)
+			results <- hedgeResult{id: id, resp: resp, err: err, cancel: cancel, latency: time.Since(start)}
+		}()
+		return nil
+	}
+
+	if err := launch(); err != nil {
+		return nil, func() {}, err
+	}
+	delay := hp.HedgeDelay()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var failure *hedgeResult
+	discard := func(r hedgeResult) {
+		if r.resp != nil {
+			_ = r.resp.Body.Close()
+		}
+		r.cancel()
+	}
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if launched >= maxAttempts {
+				continue
+			}
+			// A copy that found no free slot is tried again after another delay
+			if err := launch(); (err == nil || err == errNoFreeSlot) && launched < maxAttempts {
+				timer.Reset(delay)
+			}
+		case r := <-results:
+			pending--
+			if isHedgeSuccess(r) {
+				hp.recordLatency(r.latency)
+				// Cancel the losing copies and release whatever they return
+				for id, cancel := range cancels {
+					if id != r.id {
+						cancel()
+					}
+				}
+				go func(remaining int) {
+					for i := 0; i < remaining; i++ {
+						discard(<-results)
+					}
+				}(pending)
+				if failure != nil {
+					discard(*failure)
+				}
+				return r.resp, r.cancel, nil
+			}
+			if failure == nil {
+				failure = &r
+			} else {
+				discard(r)
+			}
+			// Do not wait for the delay when a copy has already failed
+			if launched < maxAttempts {
+				_ = launch()
+			}
+		}
+	}
+	return failure.resp, failure.cancel, failure.err
+}
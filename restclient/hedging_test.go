@@ -0,0 +1,159 @@
+package restclient
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedgedRequests(t *testing.T) {
+	var received int32
+	cancelled := make(chan struct{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&received, 1)
+		if n == 1 {
+			// The first copy hangs until it is cancelled by the winning hedge
+			select {
+			case <-r.Context().Done():
+				cancelled <- struct{}{}
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(testHttpResponse{StatusCode: http.StatusOK, Data: fmt.Sprintf("copy-%d", n)})
+	}))
+	defer ts.Close()
+
+	Convey("TEST hedged GET is answered by the second copy and the first one is cancelled", t, func() {
+		atomic.StoreInt32(&received, 0)
+		var testResponse testHttpResponse
+		req, reqErr := RequestBuilder().
+			RawUrl(ts.URL).
+			ResponseReference(&testResponse).
+			Hedging(NewHedgingPolicy(HedgingConfig{Delay: 50 * time.Millisecond, MaxHedges: 2})).
+			Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+
+		start := time.Now()
+		reqErr = req.Get()
+		elapsed := time.Since(start)
+
+		var firstCancelled bool
+		select {
+		case <-cancelled:
+			firstCancelled = true
+		case <-time.After(2 * time.Second):
+		}
+
+		Convey("Only the winner's body should be decoded", func() {
+			So(reqErr, ShouldBeNil)
+			So(testResponse.Data, ShouldEqual, "copy-2")
+			So(elapsed, ShouldBeLessThan, time.Second)
+			So(firstCancelled, ShouldBeTrue)
+			So(atomic.LoadInt32(&received), ShouldEqual, 2)
+		})
+	})
+
+	Convey("TEST non-idempotent POST is never hedged", t, func() {
+		atomic.StoreInt32(&received, 1)
+		var testResponse testHttpResponse
+		req, reqErr := RequestBuilder().
+			RawUrl(ts.URL).
+			BodyJson(testRequestBody{TestId: 1}).
+			ResponseReference(&testResponse).
+			Hedging(NewHedgingPolicy(HedgingConfig{Delay: time.Millisecond, MaxHedges: 3})).
+			Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+
+		reqErr = req.Post()
+		Convey("Server should receive a single copy", func() {
+			So(reqErr, ShouldBeNil)
+			So(testResponse.Data, ShouldEqual, "copy-2")
+			So(atomic.LoadInt32(&received), ShouldEqual, 2)
+		})
+	})
+
+	Convey("TEST hedge delay follows the observed latency percentile", t, func() {
+		policy := NewHedgingPolicy(HedgingConfig{Delay: time.Second, Percentile: 0.9, MinSamples: 10})
+		delayBeforeSamples := policy.HedgeDelay()
+		for i := 1; i <= 10; i++ {
+			policy.recordLatency(time.Duration(i) * time.Millisecond)
+		}
+
+		Convey("Configured delay should be used until enough samples are recorded", func() {
+			So(delayBeforeSamples, ShouldEqual, time.Second)
+			So(policy.HedgeDelay(), ShouldEqual, 9*time.Millisecond)
+		})
+	})
+
+	Convey("TEST cloned requests carry their own copy of the body", t, func() {
+		req, reqErr := RequestBuilder().
+			RawUrl(ts.URL).
+			BodyJson(testRequestBody{TestId: 123, TestName: "Testing Request Body"}).
+			Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+		cloned, reqErr := req.Clone()
+		originalBody, _ := ioutil.ReadAll(req.YieldRequest().Body)
+		clonedBody, _ := ioutil.ReadAll(cloned.YieldRequest().Body)
+
+		Convey("Both bodies should be readable", func() {
+			So(reqErr, ShouldBeNil)
+			So(string(clonedBody), ShouldEqual, `{"test_id":123,"test_name":"Testing Request Body"}`)
+			So(string(originalBody), ShouldEqual, string(clonedBody))
+		})
+	})
+}
+
+func TestHedgedRequestsUnderConcurrencyLimit(t *testing.T) {
+	var received int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(100 * time.Millisecond):
+		}
+	}))
+	defer ts.Close()
+
+	doGet := func(limiter *ConcurrencyLimiter) RequestError {
+		req, reqErr := RequestBuilder().
+			RawUrl(ts.URL).
+			ConcurrencyLimiter(limiter).
+			Hedging(NewHedgingPolicy(HedgingConfig{Delay: 20 * time.Millisecond, MaxHedges: 2})).
+			Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+		return req.Get()
+	}
+
+	Convey("TEST hedges are not sent while the destination is at its limit", t, func() {
+		atomic.StoreInt32(&received, 0)
+		limiter := NewConcurrencyLimiter(ConcurrencyLimiterConfig{MaxInFlight: 1})
+		So(doGet(limiter), ShouldBeNil)
+		So(atomic.LoadInt32(&received), ShouldEqual, 1)
+		So(limiter.InFlight(httptest.NewRequest(http.MethodGet, ts.URL, nil)), ShouldEqual, 0)
+	})
+
+	Convey("TEST each hedge holds a slot of its own", t, func() {
+		atomic.StoreInt32(&received, 0)
+		limiter := NewConcurrencyLimiter(ConcurrencyLimiterConfig{MaxInFlight: 2})
+		So(doGet(limiter), ShouldBeNil)
+		So(atomic.LoadInt32(&received), ShouldEqual, 2)
+		So(limiter.InFlight(httptest.NewRequest(http.MethodGet, ts.URL, nil)), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,181 @@
+package restclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultHMACTemplate        = "{method}\n{path}\n{query}\n{headers}\n{timestamp}\n{body-sha256}"
+	defaultHMACSignatureHeader = "X-Signature"
+	defaultHMACKeyIDHeader     = "X-Key-Id"
+	defaultHMACTimestampHeader = "X-Timestamp"
+)
+
+/* HMACCanonicalRequest holds the request components that can be used to build the string to sign */
+type HMACCanonicalRequest struct {
+	Method        string // upper case request method
+	Host          string // request host
+	Path          string // escaped request path, "/" if empty
+	Query         string // query parameters sorted by name and value
+	Headers       string // "name:value" lines of the signed headers in the configured order, names lowercased
+	SignedHeaders string // semicolon separated lowercased names of the signed headers
+	Timestamp     string // formatted signing time, the value of the timestamp header
+	KeyID         string // the key ID
+	BodySHA256    []byte // SHA-256 of the request body
+}
+
+/* HMACAuthenticator signs requests with HMAC-SHA256 for APIs that use their own HMAC signature schemes.
+- KeyID and Secret: the key ID sent in KeyIDHeader and the shared secret
+- Template: canonicalization template of the string to sign, defaults to DefaultHMACTemplate. Supported placeholders
+  are {method}, {host}, {path}, {query}, {headers}, {signed-headers}, {timestamp}, {key-id}, {body-sha256} (hex) and
+  {body-sha256-base64}. Canonicalize can be set instead to build the string to sign in code
+- SignedHeaders: names of the headers to include in {headers}, headers missing in the request are signed as empty
+- SignatureHeader, KeyIDHeader, TimestampHeader: header names, default to X-Signature, X-Key-Id and X-Timestamp. Set
+  KeyIDHeader to "-" to not send the key ID
+- SignaturePrefix: prepended to the signature e.g. "sha256="
+- Base64Signature: encode the signature with base64 instead of hex
+- TimestampFormat: a time layout for the timestamp, defaults to Unix seconds
+- Now: used to get the signing time, defaults to time.Now */
+type HMACAuthenticator struct {
+	KeyID                                         string
+	Secret                                        []byte
+	Template                                      string
+	Canonicalize                                  func(HMACCanonicalRequest) string
+	SignedHeaders                                 []string
+	SignatureHeader, KeyIDHeader, TimestampHeader string
+	SignaturePrefix                               string
+	Base64Signature                               bool
+	TimestampFormat                               string
+	Now                                           func() time.Time
+}
+
+func NewHMACAuthenticator(keyID string, secret []byte) Authenticator {
+	return &HMACAuthenticator{
+		KeyID:  keyID,
+		Secret: secret,
+	}
+}
+
+/* Identity is made of the key ID and the fingerprint of the secret, the signature headers change with every request */
+func (ha HMACAuthenticator) Identity() string {
+	return "hmac " + ha.KeyID + " " + fingerprint(ha.Secret)
+}
+
+/* Apply sets the timestamp, key ID and signature headers. The body hash is computed without consuming the request
+body, signed headers must be set before Apply is called */
+func (ha HMACAuthenticator) Apply(request *http.Request) error {
+	body, err := peekRequestBody(request)
+	if err != nil {
+		return errors.Wrap(err, "Failed to read request body to compute body hash")
+	}
+	bodySum := sha256.Sum256(body)
+
+	signTime := time.Now()
+	if ha.Now != nil {
+		signTime = ha.Now()
+	}
+	timestamp := strconv.FormatInt(signTime.Unix(), 10)
+	if ha.TimestampFormat != "" {
+		timestamp = signTime.UTC().Format(ha.TimestampFormat)
+	}
+
+	signedHeaders := make([]string, len(ha.SignedHeaders))
+	headerLines := make([]string, len(ha.SignedHeaders))
+	for i, name := range ha.SignedHeaders {
+		signedHeaders[i] = strings.ToLower(name)
+		value := request.Header.Get(name)
+		if strings.EqualFold(name, "host") {
+			value = requestHost(request)
+		}
+		headerLines[i] = signedHeaders[i] + ":" + strings.TrimSpace(value)
+	}
+
+	path := request.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := HMACCanonicalRequest{
+		Method:        strings.ToUpper(request.Method),
+		Host:          requestHost(request),
+		Path:          path,
+		Query:         hmacCanonicalQuery(request.URL.Query()),
+		Headers:       strings.Join(headerLines, "\n"),
+		SignedHeaders: strings.Join(signedHeaders, ";"),
+		Timestamp:     timestamp,
+		KeyID:         ha.KeyID,
+		BodySHA256:    bodySum[:],
+	}
+
+	mac := hmac.New(sha256.New, ha.Secret)
+	mac.Write([]byte(ha.stringToSign(canonical)))
+	signature := hex.EncodeToString(mac.Sum(nil))
+	if ha.Base64Signature {
+		signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	request.Header.Set(headerOrDefault(ha.TimestampHeader, defaultHMACTimestampHeader), timestamp)
+	if ha.KeyIDHeader != "-" {
+		request.Header.Set(headerOrDefault(ha.KeyIDHeader, defaultHMACKeyIDHeader), ha.KeyID)
+	}
+	request.Header.Set(headerOrDefault(ha.SignatureHeader, defaultHMACSignatureHeader), ha.SignaturePrefix+signature)
+	return nil
+}
+
+/* stringToSign builds the string to sign from the Canonicalize function if set, the template otherwise */
+func (ha HMACAuthenticator) stringToSign(c HMACCanonicalRequest) string {
+	if ha.Canonicalize != nil {
+		return ha.Canonicalize(c)
+	}
+	template := ha.Template
+	if template == "" {
+		template = DefaultHMACTemplate
+	}
+	return strings.NewReplacer(
+		"{method}", c.Method,
+		"{host}", c.Host,
+		"{path}", c.Path,
+		"{query}", c.Query,
+		"{headers}", c.Headers,
+		"{signed-headers}", c.SignedHeaders,
+		"{timestamp}", c.Timestamp,
+		"{key-id}", c.KeyID,
+		"{body-sha256}", hex.EncodeToString(c.BodySHA256),
+		"{body-sha256-base64}", base64.StdEncoding.EncodeToString(c.BodySHA256),
+	).Replace(template)
+}
+
+/* hmacCanonicalQuery encodes query sorted by name and then value */
+func hmacCanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(query))
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, url.QueryEscape(name)+"="+url.QueryEscape(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func headerOrDefault(header, defaultHeader string) string {
+	if header == "" {
+		return defaultHeader
+	}
+	return header
+}
@@ -0,0 +1,110 @@
+package restclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHMACAuthenticator(t *testing.T) {
+	signTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	secret := []byte("0123456789abcdef")
+	body := `{"test_id":123,"test_name":"Testing Request Body"}`
+	bodySum := sha256.Sum256([]byte(body))
+	sign := func(s string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(s))
+		return mac.Sum(nil)
+	}
+	newRequest := func() *http.Request {
+		req, reqErr := RequestBuilder().
+			RawUrl("https://ysyesilyurt.com/tasks/1?vectorId=2&tenantId=b&tenantId=a").
+			Header(&http.Header{"Content-Type": []string{"application/json"}, "X-Request-Id": []string{" abc "}}).
+			BodyJson(testRequestBody{TestId: 123, TestName: "Testing Request Body"}).
+			Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+		req.YieldRequest().Method = http.MethodPost
+		return req.YieldRequest()
+	}
+
+	Convey("TEST HMAC signature with the default template", t, func() {
+		req := newRequest()
+		auth := &HMACAuthenticator{
+			KeyID:         "key-1",
+			Secret:        secret,
+			SignedHeaders: []string{"Host", "Content-Type", "X-Request-Id"},
+			Now:           func() time.Time { return signTime },
+		}
+		err := auth.Apply(req)
+		sentBody, _ := ioutil.ReadAll(req.Body)
+		expected := strings.Join([]string{
+			"POST",
+			"/tasks/1",
+			"tenantId=a&tenantId=b&vectorId=2",
+			"host:ysyesilyurt.com\ncontent-type:application/json\nx-request-id:abc",
+			"1622548800",
+			hex.EncodeToString(bodySum[:]),
+		}, "\n")
+
+		Convey("Signature, key ID and timestamp headers should be set and the body should be kept", func() {
+			So(err, ShouldBeNil)
+			So(req.Header.Get("X-Signature"), ShouldEqual, hex.EncodeToString(sign(expected)))
+			So(req.Header.Get("X-Key-Id"), ShouldEqual, "key-1")
+			So(req.Header.Get("X-Timestamp"), ShouldEqual, "1622548800")
+			So(string(sentBody), ShouldEqual, body)
+		})
+	})
+
+	Convey("TEST HMAC signature with a custom template and header names", t, func() {
+		req := newRequest()
+		auth := &HMACAuthenticator{
+			KeyID:           "key-2",
+			Secret:          secret,
+			Template:        "{key-id}|{method}|{path}?{query}|{timestamp}|{body-sha256-base64}",
+			SignatureHeader: "Authorization",
+			SignaturePrefix: "HMAC-SHA256 ",
+			KeyIDHeader:     "-",
+			TimestampHeader: "Date",
+			TimestampFormat: time.RFC3339,
+			Base64Signature: true,
+			Now:             func() time.Time { return signTime },
+		}
+		err := auth.Apply(req)
+		expected := "key-2|POST|/tasks/1?tenantId=a&tenantId=b&vectorId=2|2021-06-01T12:00:00Z|" +
+			base64.StdEncoding.EncodeToString(bodySum[:])
+
+		Convey("Custom canonicalization and headers should be used", func() {
+			So(err, ShouldBeNil)
+			So(req.Header.Get("Authorization"), ShouldEqual, "HMAC-SHA256 "+base64.StdEncoding.EncodeToString(sign(expected)))
+			So(req.Header.Get("Date"), ShouldEqual, "2021-06-01T12:00:00Z")
+			So(req.Header.Get("X-Key-Id"), ShouldBeEmpty)
+		})
+	})
+
+	Convey("TEST HMAC signature built by a Canonicalize function", t, func() {
+		req := newRequest()
+		auth := &HMACAuthenticator{
+			KeyID:  "key-3",
+			Secret: secret,
+			Canonicalize: func(c HMACCanonicalRequest) string {
+				return c.Method + " " + c.Host + c.Path
+			},
+		}
+		err := auth.Apply(req)
+
+		Convey("String to sign should come from the function", func() {
+			So(err, ShouldBeNil)
+			So(req.Header.Get("X-Signature"), ShouldEqual, hex.EncodeToString(sign("POST ysyesilyurt.com/tasks/1")))
+		})
+	})
+}
@@ -0,0 +1,197 @@
+package restclient
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/http"
+	"time"
+)
+
+const defaultJWTTTL = 5 * time.Minute
+
+/* JWTAlgorithm is a JWS algorithm (RFC 7518) supported by JWTAuthenticator */
+type JWTAlgorithm string
+
+const (
+	JWTRS256 JWTAlgorithm = "RS256"
+	JWTES256 JWTAlgorithm = "ES256"
+	JWTEdDSA JWTAlgorithm = "EdDSA"
+)
+
+/* JWTConfig configures the JWTs minted by JWTAuthenticator.
+- Signer: *rsa.PrivateKey, *ecdsa.PrivateKey (P-256), ed25519.PrivateKey or any crypto.Signer of these key types
+  e.g. backed by a KMS or HSM
+- Algorithm: RS256, ES256 or EdDSA, derived from the public key of Signer if empty
+- KeyID: the kid header of the tokens, lets the verifier pick the key
+- Issuer, Subject, Audience: the iss, sub and aud claims. aud is a string if there is a single audience
+- TTL: lifetime of the tokens (exp - iat), defaults to 5 minutes
+- Claims: additional claims, the registered claims above take precedence
+- Now: used to get the issue time, defaults to time.Now */
+type JWTConfig struct {
+	Signer          crypto.Signer
+	Algorithm       JWTAlgorithm
+	KeyID           string
+	Issuer, Subject string
+	Audience        []string
+	TTL             time.Duration
+	Claims          map[string]interface{}
+	Now             func() time.Time
+}
+
+/* JWTAuthenticator sets `Authorization: Bearer <jwt>` with short-lived self-signed JWT assertions. A token is minted
+with a new jti and cached until it is about to expire. A request rejected with 401 Unauthorized is retried once with a
+newly minted token, see RefreshableAuthenticator */
+type JWTAuthenticator struct {
+	config    JWTConfig
+	algorithm MessageSignatureAlgorithm
+	err       error // invalid configuration, returned instead of minting tokens
+	source    *CachingTokenSource
+	RefreshGroup
+}
+
+/* NewJWTAuthenticator creates a JWTAuthenticator. The Signer is checked against the Algorithm right away, an invalid
+configuration is logged and fails every request it is applied to */
+func NewJWTAuthenticator(config JWTConfig) Authenticator {
+	ja := &JWTAuthenticator{config: config}
+	ja.config.Algorithm, ja.algorithm, ja.err = jwtAlgorithm(config)
+	if ja.err != nil {
+		errorLogger.Printf("Invalid JWT configuration! Requests will fail to be authenticated.. %v", ja.err)
+	}
+	ja.source = NewCachingTokenSource(func(ctx context.Context) (string, time.Time, error) {
+		return ja.Mint()
+	})
+	return ja
+}
+
+/* Identity is made of the key ID, issuer, subject and the fingerprint of the key, the tokens change once they are
+minted again */
+func (ja *JWTAuthenticator) Identity() string {
+	return "jwt " + ja.config.KeyID + " " + ja.config.Issuer + " " + ja.config.Subject + " " + signerFingerprint(ja.config.Signer)
+}
+
+func (ja *JWTAuthenticator) Apply(request *http.Request) error {
+	return BearerTokenAuthenticator{Source: ja.source}.Apply(request)
+}
+
+func (ja *JWTAuthenticator) Refresh(ctx context.Context, response *http.Response) error {
+	return ja.source.Refresh(ctx)
+}
+
+/* Token returns the cached token or mints a new one, so that JWTAuthenticator can also be used as a TokenSource */
+func (ja *JWTAuthenticator) Token() (string, error) {
+	return ja.source.Token()
+}
+
+/* Mint signs a new token regardless of the cached one and returns it with its expiry */
+func (ja *JWTAuthenticator) Mint() (string, time.Time, error) {
+	config := ja.config
+	if ja.err != nil {
+		return "", time.Time{}, ja.err
+	}
+
+	issuedAt := time.Now()
+	if config.Now != nil {
+		issuedAt = config.Now()
+	}
+	ttl := config.TTL
+	if ttl <= 0 {
+		ttl = defaultJWTTTL
+	}
+	expiresAt := issuedAt.Add(ttl)
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", time.Time{}, errors.Wrap(err, "Failed to generate JWT ID")
+	}
+
+	claims := make(map[string]interface{}, len(config.Claims)+6)
+	for name, value := range config.Claims {
+		claims[name] = value
+	}
+	setClaimIfNotEmpty := func(name, value string) {
+		if value != "" {
+			claims[name] = value
+		}
+	}
+	setClaimIfNotEmpty("iss", config.Issuer)
+	setClaimIfNotEmpty("sub", config.Subject)
+	if len(config.Audience) == 1 {
+		claims["aud"] = config.Audience[0]
+	} else if len(config.Audience) > 1 {
+		claims["aud"] = config.Audience
+	}
+	claims["iat"] = issuedAt.Unix()
+	claims["exp"] = expiresAt.Unix()
+	claims["jti"] = hex.EncodeToString(jti)
+
+	header, err := json.Marshal(struct {
+		Algorithm JWTAlgorithm `json:"alg"`
+		Type      string       `json:"typ"`
+		KeyID     string       `json:"kid,omitempty"`
+	}{config.Algorithm, "JWT", config.KeyID})
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "Failed to marshal JWT header")
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "Failed to marshal JWT claims")
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := signMessage(ja.algorithm, config.Signer, nil, signingInput)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "Failed to sign JWT")
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), expiresAt, nil
+}
+
+/* jwtAlgorithm returns the JWT algorithm of config, derived from its Signer if not set, and the algorithm to sign with.
+It fails unless the key of the Signer can sign with the algorithm */
+func jwtAlgorithm(config JWTConfig) (JWTAlgorithm, MessageSignatureAlgorithm, error) {
+	if config.Signer == nil {
+		return "", "", errors.New("Signer is required to sign JWTs")
+	}
+	publicKey := config.Signer.Public()
+	algorithm := config.Algorithm
+	if algorithm == "" {
+		var err error
+		if algorithm, err = jwtAlgorithmOf(publicKey); err != nil {
+			return "", "", err
+		}
+	}
+	signatureAlgorithm, ok := map[JWTAlgorithm]MessageSignatureAlgorithm{
+		JWTRS256: MessageSignatureRSAv15SHA256,
+		JWTES256: MessageSignatureECDSAP256SHA256,
+		JWTEdDSA: MessageSignatureEd25519,
+	}[algorithm]
+	if !ok {
+		return "", "", errors.Errorf("Unsupported JWT algorithm %q", algorithm)
+	}
+	if keyAlgorithm, err := jwtAlgorithmOf(publicKey); err != nil || keyAlgorithm != algorithm {
+		return "", "", errors.Errorf("Signer %T key cannot sign %s JWTs", publicKey, algorithm)
+	}
+	return algorithm, signatureAlgorithm, nil
+}
+
+/* jwtAlgorithmOf returns the JWT algorithm to sign with the private key of publicKey */
+func jwtAlgorithmOf(publicKey crypto.PublicKey) (JWTAlgorithm, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return JWTRS256, nil
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P256() {
+			return JWTES256, nil
+		}
+	case ed25519.PublicKey:
+		return JWTEdDSA, nil
+	}
+	return "", errors.Errorf("No supported JWT algorithm for %T keys", publicKey)
+}
@@ -0,0 +1,138 @@
+package restclient
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/* parseTestJWT verifies the signature of token with publicKey and returns its header and claims */
+func parseTestJWT(token string, algorithm MessageSignatureAlgorithm, publicKey crypto.PublicKey) (map[string]interface{}, map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		log.Fatalf("malformed JWT %q", token)
+	}
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	if err := verifyMessage(algorithm, publicKey, nil, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, nil, err
+	}
+	var header, claims map[string]interface{}
+	headerJson, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	claimsJson, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	_ = json.Unmarshal(headerJson, &header)
+	_ = json.Unmarshal(claimsJson, &claims)
+	return header, claims, nil
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	issuedAt := time.Unix(1622548800, 0)
+
+	Convey("TEST JWTs are signed with the algorithm of the signer", t, func() {
+		for _, tc := range []struct {
+			signer    crypto.Signer
+			jwtAlg    string
+			algorithm MessageSignatureAlgorithm
+		}{
+			{rsaKey, "RS256", MessageSignatureRSAv15SHA256},
+			{ecKey, "ES256", MessageSignatureECDSAP256SHA256},
+			{edKey, "EdDSA", MessageSignatureEd25519},
+		} {
+			auth := NewJWTAuthenticator(JWTConfig{
+				Signer:   tc.signer,
+				KeyID:    "key-1",
+				Issuer:   "billing",
+				Subject:  "billing-worker",
+				Audience: []string{"https://ysyesilyurt.com"},
+				TTL:      time.Minute,
+				Claims:   map[string]interface{}{"scope": "tasks:read", "iss": "overridden"},
+				Now:      func() time.Time { return issuedAt },
+			}).(*JWTAuthenticator)
+			token, expiresAt, err := auth.Mint()
+			So(err, ShouldBeNil)
+			So(expiresAt, ShouldEqual, issuedAt.Add(time.Minute))
+
+			header, claims, err := parseTestJWT(token, tc.algorithm, tc.signer.Public())
+			So(err, ShouldBeNil)
+			So(header, ShouldResemble, map[string]interface{}{"alg": tc.jwtAlg, "typ": "JWT", "kid": "key-1"})
+			So(claims["iss"], ShouldEqual, "billing")
+			So(claims["sub"], ShouldEqual, "billing-worker")
+			So(claims["aud"], ShouldEqual, "https://ysyesilyurt.com")
+			So(claims["iat"], ShouldEqual, 1622548800)
+			So(claims["exp"], ShouldEqual, 1622548860)
+			So(claims["jti"], ShouldHaveLength, 32)
+			So(claims["scope"], ShouldEqual, "tasks:read")
+		}
+	})
+
+	Convey("TEST JWTs with an unsupported key are rejected", t, func() {
+		p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		_, _, err := NewJWTAuthenticator(JWTConfig{Signer: p384Key}).(*JWTAuthenticator).Mint()
+		So(err, ShouldNotBeNil)
+
+		_, _, err = NewJWTAuthenticator(JWTConfig{Signer: p384Key, Algorithm: JWTES256}).(*JWTAuthenticator).Mint()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "cannot sign ES256 JWTs")
+
+		_, _, err = NewJWTAuthenticator(JWTConfig{Signer: edKey, Algorithm: JWTRS256}).(*JWTAuthenticator).Mint()
+		So(err, ShouldNotBeNil)
+
+		reqErr := NewJWTAuthenticator(JWTConfig{}).Apply(httptest.NewRequest(http.MethodGet, "/", nil))
+		So(reqErr, ShouldNotBeNil)
+	})
+
+	Convey("TEST JWTs are cached and minted again once rejected", t, func() {
+		var received int32
+		var rejectedToken atomic.Value
+		rejectedToken.Store("")
+		var tokens []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&received, 1)
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			tokens = append(tokens, token)
+			if _, _, err := parseTestJWT(token, MessageSignatureEd25519, edPublic); err != nil || token == rejectedToken.Load().(string) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer ts.Close()
+
+		auth := NewJWTAuthenticator(JWTConfig{Signer: edKey, Issuer: "billing", Audience: []string{ts.URL}})
+		doGet := func() RequestError {
+			req, reqErr := RequestBuilder().RawUrl(ts.URL).Auth(auth).Build()
+			if reqErr != nil {
+				log.Fatalf("failed to construct testRequest, %v", reqErr)
+			}
+			return req.Get()
+		}
+		firstErr, secondErr := doGet(), doGet()
+		rejectedToken.Store(tokens[1])
+		thirdErr := doGet()
+
+		Convey("Cached token should be reused until it is rejected", func() {
+			So(firstErr, ShouldBeNil)
+			So(secondErr, ShouldBeNil)
+			So(thirdErr, ShouldBeNil)
+			So(atomic.LoadInt32(&received), ShouldEqual, 4)
+			So(tokens[0], ShouldEqual, tokens[1])
+			So(tokens[2], ShouldEqual, tokens[1])
+			So(tokens[3], ShouldNotEqual, tokens[2])
+		})
+	})
+}
@@ -0,0 +1,325 @@
+package restclient
+
+import (
+	"github.com/pkg/errors"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* ConcurrencyLimiterConfig holds the configuration of a ConcurrencyLimiter.
+- MaxInFlight: maximum number of in-flight requests per destination (initial limit if Adaptive is set)
+- MaxWait: maximum duration a request can wait for a free slot, Zero (0) means waiting as long as the request timeout
+- PathTemplates: optional path templates such as "/users/{id}/posts" to bulkhead matching requests per template instead of per host
+- Adaptive: optional AdaptiveLimit factory to adjust each destination's limit from observed latency and overload */
+type ConcurrencyLimiterConfig struct {
+	MaxInFlight   int
+	MaxWait       time.Duration
+	PathTemplates []string
+	Adaptive      func() AdaptiveLimit
+}
+
+/* ConcurrencyLimiter caps the number of in-flight requests per destination (host, or host and path template) so that
+one slow dependency cannot use up all goroutines and file descriptors. A single ConcurrencyLimiter is meant to be
+shared by all the requests that should be limited together, set it on requests using HttpRequestBuilder.ConcurrencyLimiter */
+type ConcurrencyLimiter struct {
+	config  ConcurrencyLimiterConfig
+	mu      sync.Mutex
+	buckets map[string]*limiterBucket
+}
+
+/* limiterBucket keeps the state of a single destination, all fields are guarded by ConcurrencyLimiter.mu */
+type limiterBucket struct {
+	limit    int
+	inFlight int
+	waiters  []chan struct{}
+	adaptive AdaptiveLimit
+}
+
+/* limiterPermit represents an acquired slot, it must be released exactly once */
+type limiterPermit struct {
+	limiter *ConcurrencyLimiter
+	bucket  *limiterBucket
+	start   time.Time
+}
+
+func NewConcurrencyLimiter(config ConcurrencyLimiterConfig) *ConcurrencyLimiter {
+	if config.MaxInFlight < 1 {
+		config.MaxInFlight = 1
+	}
+	return &ConcurrencyLimiter{
+		config:  config,
+		buckets: make(map[string]*limiterBucket),
+	}
+}
+
+/* Limit returns the current concurrency limit for the destination of the given request */
+func (cl *ConcurrencyLimiter) Limit(req *http.Request) int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.bucketFor(req).limit
+}
+
+/* InFlight returns the number of in-flight requests for the destination of the given request */
+func (cl *ConcurrencyLimiter) InFlight(req *http.Request) int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.bucketFor(req).inFlight
+}
+
+/* bucketFor returns the bucket of the destination of req, creating it if necessary. cl.mu must be held */
+func (cl *ConcurrencyLimiter) bucketFor(req *http.Request) *limiterBucket {
+	key := cl.destinationKey(req)
+	b, ok := cl.buckets[key]
+	if !ok {
+		b = &limiterBucket{limit: cl.config.MaxInFlight}
+		if cl.config.Adaptive != nil {
+			b.adaptive = cl.config.Adaptive()
+		}
+		cl.buckets[key] = b
+	}
+	return b
+}
+
+/* destinationKey is the request host, suffixed with the first matching path template if any */
+func (cl *ConcurrencyLimiter) destinationKey(req *http.Request) string {
+	for _, template := range cl.config.PathTemplates {
+		if matchPathTemplate(template, req.URL.Path) {
+			return req.URL.Host + " " + template
+		}
+	}
+	return req.URL.Host
+}
+
+/* matchPathTemplate reports whether path matches template, where template segments enclosed in braces or consisting
+of a single asterisk match any single non-empty path segment e.g. "/users/{id}/posts" matches "/users/42/posts" */
+func matchPathTemplate(template, path string) bool {
+	templateSegments := strings.Split(strings.Trim(template, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(templateSegments) != len(pathSegments) {
+		return false
+	}
+	for i, ts := range templateSegments {
+		if ts == "*" || (strings.HasPrefix(ts, "{") && strings.HasSuffix(ts, "}")) {
+			if pathSegments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if ts != pathSegments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+/* acquire waits for a free slot for req's destination. It gives up with a RequestError once maxWait elapses or the
+request context is done. Zero (0) maxWait means waiting until the request context is done */
+func (cl *ConcurrencyLimiter) acquire(req *http.Request, maxWait time.Duration) (*limiterPermit, RequestError) {
+	if cl.config.MaxWait > 0 {
+		maxWait = cl.config.MaxWait
+	}
+
+	cl.mu.Lock()
+	b := cl.bucketFor(req)
+	if b.inFlight < b.limit && len(b.waiters) == 0 {
+		b.inFlight++
+		cl.mu.Unlock()
+		return &limiterPermit{limiter: cl, bucket: b, start: time.Now()}, nil
+	}
+	ready := make(chan struct{})
+	b.waiters = append(b.waiters, ready)
+	cl.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if maxWait > 0 {
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var waitErr error
+	select {
+	case <-ready:
+		return &limiterPermit{limiter: cl, bucket: b, start: time.Now()}, nil
+	case <-timeout:
+		waitErr = errors.Errorf("Timed out after %v waiting for a free slot for %s", maxWait, cl.destinationKey(req))
+	case <-req.Context().Done():
+		waitErr = errors.Wrapf(req.Context().Err(), "Gave up waiting for a free slot for %s", cl.destinationKey(req))
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	for i, w := range b.waiters {
+		if w == ready {
+			b.waiters = append(b.waiters[:i], b.waiters[i+1:]...)
+			return nil, NewRequestTimeoutError(ConcurrencyLimitErr, waitErr)
+		}
+	}
+	// The slot was handed over concurrently with giving up, pass it to the next waiter
+	b.inFlight--
+	cl.dispatch(b)
+	return nil, NewRequestTimeoutError(ConcurrencyLimitErr, waitErr)
+}
+
+/* tryAcquire takes a free slot for req's destination without waiting, it returns nil if there is none */
+func (cl *ConcurrencyLimiter) tryAcquire(req *http.Request) *limiterPermit {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	b := cl.bucketFor(req)
+	if b.inFlight >= b.limit || len(b.waiters) > 0 {
+		return nil
+	}
+	b.inFlight++
+	return &limiterPermit{limiter: cl, bucket: b, start: time.Now()}
+}
+
+/* dispatch hands free slots of b over to its waiters in FIFO order. cl.mu must be held */
+func (cl *ConcurrencyLimiter) dispatch(b *limiterBucket) {
+	for b.inFlight < b.limit && len(b.waiters) > 0 {
+		next := b.waiters[0]
+		b.waiters = b.waiters[1:]
+		b.inFlight++
+		close(next)
+	}
+}
+
+/* release frees the slot held by the permit and feeds the observed sample to the adaptive limit if configured.
+overloaded should be set if the destination reported it is overloaded (e.g. 503) or the request timed out */
+func (p *limiterPermit) release(overloaded bool) {
+	rtt := time.Since(p.start)
+	cl := p.limiter
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if p.bucket.adaptive != nil {
+		p.bucket.limit = p.bucket.adaptive.Update(p.bucket.limit, p.bucket.inFlight, rtt, overloaded)
+		if p.bucket.limit < 1 {
+			p.bucket.limit = 1
+		}
+	}
+	p.bucket.inFlight--
+	cl.dispatch(p.bucket)
+}
+
+/* isOverloadSignal reports whether a request result implies that the destination is overloaded */
+func isOverloadSignal(reqErr RequestError) bool {
+	if reqErr == nil {
+		return false
+	}
+	return reqErr.GetTopLevelError() == ServiceUnavailableErr || (reqErr.Timeout() && reqErr.GetTopLevelError() != ConcurrencyLimitErr)
+}
+
+/* AdaptiveLimit adjusts the concurrency limit of a single destination. Update is called after each request completes
+with the current limit, the number of in-flight requests (including the completed one), the observed latency and
+whether the destination reported overload, and returns the new limit. Implementations may keep state, a new
+instance is created for each destination */
+type AdaptiveLimit interface {
+	Update(limit, inFlight int, rtt time.Duration, overloaded bool) int
+}
+
+/* AIMDLimit is an additive-increase/multiplicative-decrease AdaptiveLimit. The limit grows by one after each
+successful request that used at least half of the limit and shrinks by BackoffRatio on overload or when the latency
+exceeds LatencyThreshold (if set) */
+type AIMDLimit struct {
+	MinLimit, MaxLimit int
+	BackoffRatio       float64
+	LatencyThreshold   time.Duration
+}
+
+/* NewAIMDLimit returns an AdaptiveLimit factory to be used as ConcurrencyLimiterConfig.Adaptive. BackoffRatio
+defaults to 0.9 if not in (0, 1) */
+func NewAIMDLimit(config AIMDLimit) func() AdaptiveLimit {
+	if config.BackoffRatio <= 0 || config.BackoffRatio >= 1 {
+		config.BackoffRatio = 0.9
+	}
+	return func() AdaptiveLimit {
+		l := config
+		return &l
+	}
+}
+
+func (a *AIMDLimit) Update(limit, inFlight int, rtt time.Duration, overloaded bool) int {
+	if overloaded || (a.LatencyThreshold > 0 && rtt > a.LatencyThreshold) {
+		return clampLimit(int(float64(limit)*a.BackoffRatio), a.MinLimit, a.MaxLimit)
+	}
+	if inFlight*2 >= limit {
+		return clampLimit(limit+1, a.MinLimit, a.MaxLimit)
+	}
+	return limit
+}
+
+/* GradientLimit is a gradient based AdaptiveLimit. It compares the smoothed latency against the minimum latency
+observed so far, shrinking the limit as queueing builds up and growing it by a square root sized headroom otherwise.
+Smoothing defaults to 0.2 and Tolerance, the accepted ratio of smoothed to minimum latency, defaults to 1.5 */
+type GradientLimit struct {
+	MinLimit, MaxLimit int
+	Smoothing          float64
+	Tolerance          float64
+
+	minRTT, smoothedRTT float64
+	estimate            float64
+}
+
+/* NewGradientLimit returns an AdaptiveLimit factory to be used as ConcurrencyLimiterConfig.Adaptive */
+func NewGradientLimit(config GradientLimit) func() AdaptiveLimit {
+	if config.Smoothing <= 0 || config.Smoothing > 1 {
+		config.Smoothing = 0.2
+	}
+	if config.Tolerance < 1 {
+		config.Tolerance = 1.5
+	}
+	return func() AdaptiveLimit {
+		return &GradientLimit{
+			MinLimit:  config.MinLimit,
+			MaxLimit:  config.MaxLimit,
+			Smoothing: config.Smoothing,
+			Tolerance: config.Tolerance,
+		}
+	}
+}
+
+func (g *GradientLimit) Update(limit, inFlight int, rtt time.Duration, overloaded bool) int {
+	if g.estimate == 0 {
+		g.estimate = float64(limit)
+	}
+	if overloaded {
+		g.estimate = math.Max(1, g.estimate/2)
+		return clampLimit(int(g.estimate), g.MinLimit, g.MaxLimit)
+	}
+
+	sample := float64(rtt)
+	if g.minRTT == 0 || sample < g.minRTT {
+		g.minRTT = sample
+	}
+	if g.smoothedRTT == 0 {
+		g.smoothedRTT = sample
+	} else {
+		g.smoothedRTT = g.smoothedRTT*(1-g.Smoothing) + sample*g.Smoothing
+	}
+
+	// Do not grow the limit if the destination is not even using half of it
+	if inFlight*2 < limit && g.smoothedRTT <= g.minRTT*g.Tolerance {
+		return limit
+	}
+
+	gradient := math.Max(0.5, math.Min(1, g.Tolerance*g.minRTT/g.smoothedRTT))
+	next := g.estimate*gradient + math.Sqrt(g.estimate)
+	g.estimate = g.estimate*(1-g.Smoothing) + next*g.Smoothing
+	return clampLimit(int(math.Round(g.estimate)), g.MinLimit, g.MaxLimit)
+}
+
+/* clampLimit keeps limit within [min, max], a non-positive max means no upper bound */
+func clampLimit(limit, min, max int) int {
+	if min < 1 {
+		min = 1
+	}
+	if limit < min {
+		return min
+	}
+	if max > 0 && limit > max {
+		return max
+	}
+	return limit
+}
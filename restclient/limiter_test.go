@@ -0,0 +1,140 @@
+package restclient
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			<-release
+			w.WriteHeader(http.StatusOK)
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer ts.Close()
+	splittedURL := strings.Split(ts.URL, "://")
+	testServerScheme := splittedURL[0]
+	testServerHost := splittedURL[1]
+
+	buildRequest := func(limiter *ConcurrencyLimiter, path string) *HttpRequest {
+		req, reqErr := RequestBuilder().
+			Scheme(testServerScheme).
+			Host(testServerHost).
+			PathElements([]string{path}).
+			ConcurrencyLimiter(limiter).
+			Timeout(5 * time.Second).
+			Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+		return req
+	}
+
+	Convey("TEST queued request times out while the destination is saturated", t, func() {
+		limiter := NewConcurrencyLimiter(ConcurrencyLimiterConfig{MaxInFlight: 1, MaxWait: 50 * time.Millisecond})
+		slowReq := buildRequest(limiter, "slow")
+
+		var wg sync.WaitGroup
+		var slowErr RequestError
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slowErr = slowReq.Get()
+		}()
+		for limiter.InFlight(slowReq.YieldRequest()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		reqErr := buildRequest(limiter, "other").Get()
+		release <- struct{}{}
+		wg.Wait()
+
+		Convey("Second request should fail with ConcurrencyLimitErr and the first one should succeed", func() {
+			So(reqErr, ShouldNotBeNil)
+			So(reqErr.GetTopLevelError(), ShouldEqual, ConcurrencyLimitErr)
+			So(reqErr.Timeout(), ShouldBeTrue)
+			So(slowErr, ShouldBeNil)
+			So(limiter.InFlight(slowReq.YieldRequest()), ShouldEqual, 0)
+		})
+	})
+
+	Convey("TEST path templates bulkhead requests separately from the rest of the host", t, func() {
+		limiter := NewConcurrencyLimiter(ConcurrencyLimiterConfig{
+			MaxInFlight:   1,
+			MaxWait:       50 * time.Millisecond,
+			PathTemplates: []string{"/{name}"},
+		})
+		slowReq := buildRequest(limiter, "slow")
+		go func() {
+			_ = slowReq.Get()
+		}()
+		for limiter.InFlight(slowReq.YieldRequest()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		rootReq, reqErr := RequestBuilder().
+			RawUrl(ts.URL).
+			ConcurrencyLimiter(limiter).
+			Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+		reqErr = rootReq.Get()
+		release <- struct{}{}
+
+		Convey("Request outside of the template should not wait for the slow one", func() {
+			So(reqErr, ShouldBeNil)
+			So(matchPathTemplate("/users/{id}/posts", "/users/42/posts"), ShouldBeTrue)
+			So(matchPathTemplate("/users/{id}/posts", "/users/42"), ShouldBeFalse)
+		})
+	})
+
+	Convey("TEST adaptive AIMD limit backs off on 503 and does not grow while underused", t, func() {
+		limiter := NewConcurrencyLimiter(ConcurrencyLimiterConfig{
+			MaxInFlight: 10,
+			Adaptive:    NewAIMDLimit(AIMDLimit{MinLimit: 2, MaxLimit: 10, BackoffRatio: 0.5}),
+		})
+		unavailableReq := buildRequest(limiter, "unavailable")
+		reqErr := unavailableReq.Get()
+		limitAfterOverload := limiter.Limit(unavailableReq.YieldRequest())
+
+		okReq := buildRequest(limiter, "ok")
+		_ = okReq.Get()
+
+		Convey("Limit should be halved by the 503 and kept by the following success using a single slot", func() {
+			So(reqErr.GetTopLevelError(), ShouldEqual, ServiceUnavailableErr)
+			So(limitAfterOverload, ShouldEqual, 5)
+			So(limiter.Limit(okReq.YieldRequest()), ShouldEqual, 5)
+		})
+	})
+
+	Convey("TEST gradient limit shrinks when latency builds up", t, func() {
+		gradient := NewGradientLimit(GradientLimit{MinLimit: 1, MaxLimit: 100})()
+		limit := 20
+		for i := 0; i < 10; i++ {
+			limit = gradient.Update(limit, limit, 10*time.Millisecond, false)
+		}
+		grown := limit
+		for i := 0; i < 20; i++ {
+			limit = gradient.Update(limit, limit, 100*time.Millisecond, false)
+		}
+
+		Convey("Limit should grow while latency is stable and shrink after it increases", func() {
+			So(grown, ShouldBeGreaterThan, 20)
+			So(limit, ShouldBeLessThan, grown)
+		})
+	})
+}
@@ -0,0 +1,446 @@
+package restclient
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/* MessageSignatureAlgorithm is an algorithm of the HTTP Signature Algorithms registry (RFC 9421 section 3.3) */
+type MessageSignatureAlgorithm string
+
+const (
+	MessageSignatureEd25519         MessageSignatureAlgorithm = "ed25519"
+	MessageSignatureECDSAP256SHA256 MessageSignatureAlgorithm = "ecdsa-p256-sha256"
+	MessageSignatureECDSAP384SHA384 MessageSignatureAlgorithm = "ecdsa-p384-sha384"
+	MessageSignatureRSAPSSSHA512    MessageSignatureAlgorithm = "rsa-pss-sha512"
+	MessageSignatureRSAv15SHA256    MessageSignatureAlgorithm = "rsa-v1_5-sha256"
+	MessageSignatureHMACSHA256      MessageSignatureAlgorithm = "hmac-sha256"
+
+	defaultMessageSignatureLabel = "sig1"
+)
+
+/* DefaultMessageSignatureComponents are the components signed by MessageSignatureAuthenticator if none are configured */
+var DefaultMessageSignatureComponents = []string{"@method", "@target-uri"}
+
+/* MessageSignatureAuthenticator signs requests with HTTP Message Signatures (RFC 9421) by setting the Signature-Input
+and Signature headers.
+- Label: label of the signature in both headers, defaults to "sig1"
+- KeyID and Algorithm: sent in the keyid parameter and used to sign. Signer is used for the asymmetric algorithms
+  (ed25519, ecdsa-*, rsa-*), Secret for hmac-sha256
+- Components: covered components, defaults to DefaultMessageSignatureComponents. Derived components (@method,
+  @target-uri, @authority, @scheme, @request-target, @path, @query and @query-param;name="...") and header names are
+  supported. A missing header fails the request
+- ContentDigest: set the Content-Digest header (RFC 9530) of the request body with sha-256 and cover it
+- Expires, Nonce, IncludeAlgorithm, Tag: add the expires (created + Expires), a random nonce, alg and tag parameters
+- Now: used to get the signing time, defaults to time.Now */
+type MessageSignatureAuthenticator struct {
+	Label            string
+	KeyID            string
+	Algorithm        MessageSignatureAlgorithm
+	Signer           crypto.Signer
+	Secret           []byte
+	Components       []string
+	ContentDigest    bool
+	Expires          time.Duration
+	Nonce            bool
+	IncludeAlgorithm bool
+	Tag              string
+	Now              func() time.Time
+}
+
+func NewMessageSignatureAuthenticator(keyID string, algorithm MessageSignatureAlgorithm, signer crypto.Signer) Authenticator {
+	return &MessageSignatureAuthenticator{
+		KeyID:     keyID,
+		Algorithm: algorithm,
+		Signer:    signer,
+	}
+}
+
+func NewHMACMessageSignatureAuthenticator(keyID string, secret []byte) Authenticator {
+	return &MessageSignatureAuthenticator{
+		KeyID:     keyID,
+		Algorithm: MessageSignatureHMACSHA256,
+		Secret:    secret,
+	}
+}
+
+/* Identity is made of the key ID and the fingerprint of the key, the signature headers change with every request */
+func (ma MessageSignatureAuthenticator) Identity() string {
+	return "message-signature " + ma.KeyID + " " + fingerprint(ma.Secret) + " " + signerFingerprint(ma.Signer)
+}
+
+/* Apply signs the request, it must be the last step that modifies the request since anything that is changed
+afterwards may invalidate the signature. A signature set by an earlier Apply is replaced */
+func (ma MessageSignatureAuthenticator) Apply(request *http.Request) error {
+	components := ma.Components
+	if len(components) == 0 {
+		components = DefaultMessageSignatureComponents
+	}
+	if ma.ContentDigest && request.Body != nil && request.Body != http.NoBody {
+		body, err := peekRequestBody(request)
+		if err != nil {
+			return errors.Wrap(err, "Failed to read request body to compute content digest")
+		}
+		sum := sha256.Sum256(body)
+		request.Header.Set("Content-Digest", "sha-256="+serializeSFBareItem(sum[:]))
+		if !containsString(components, "content-digest") {
+			components = append(append([]string(nil), components...), "content-digest")
+		}
+	}
+
+	items, err := parseSignatureComponents(components)
+	if err != nil {
+		return err
+	}
+	params, err := ma.signatureParams()
+	if err != nil {
+		return err
+	}
+	base, err := signatureBase(items, params, request, nil)
+	if err != nil {
+		return err
+	}
+	signature, err := signMessage(ma.Algorithm, ma.Signer, ma.Secret, base)
+	if err != nil {
+		return err
+	}
+
+	label := ma.Label
+	if label == "" {
+		label = defaultMessageSignatureLabel
+	}
+	request.Header.Set("Signature-Input", label+"="+serializeSFInnerList(items, params))
+	request.Header.Set("Signature", label+"="+serializeSFBareItem(signature))
+	return nil
+}
+
+func (ma MessageSignatureAuthenticator) signatureParams() (sfParams, error) {
+	created := time.Now()
+	if ma.Now != nil {
+		created = ma.Now()
+	}
+	params := sfParams{{name: "created", value: created.Unix()}}
+	if ma.Expires > 0 {
+		params = append(params, sfParam{name: "expires", value: created.Add(ma.Expires).Unix()})
+	}
+	if ma.KeyID != "" {
+		params = append(params, sfParam{name: "keyid", value: ma.KeyID})
+	}
+	if ma.IncludeAlgorithm {
+		params = append(params, sfParam{name: "alg", value: string(ma.Algorithm)})
+	}
+	if ma.Nonce {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, errors.Wrap(err, "Failed to generate signature nonce")
+		}
+		params = append(params, sfParam{name: "nonce", value: base64.RawURLEncoding.EncodeToString(nonce)})
+	}
+	if ma.Tag != "" {
+		params = append(params, sfParam{name: "tag", value: ma.Tag})
+	}
+	return params, nil
+}
+
+/* parseSignatureComponents parses component identifiers e.g. "@method", "Content-Type" or `@query-param;name="id"`.
+Header names are lowercased */
+func parseSignatureComponents(components []string) ([]sfItem, error) {
+	items := make([]sfItem, len(components))
+	for i, component := range components {
+		identifier := component
+		if !strings.HasPrefix(component, `"`) {
+			name, params := component, ""
+			if j := strings.IndexByte(component, ';'); j >= 0 {
+				name, params = component[:j], component[j:]
+			}
+			identifier = `"` + strings.ToLower(strings.TrimSpace(name)) + `"` + params
+		}
+		item, err := parseSFItem(identifier)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid signature component %q", component)
+		}
+		if _, ok := item.value.(string); !ok {
+			return nil, errors.Errorf("Invalid signature component %q", component)
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+/* signatureBase builds the signature base (RFC 9421 section 2.5) of the components of request, or of response if
+it is not nil. Response components can refer to the request with the req parameter */
+func signatureBase(components []sfItem, params sfParams, request *http.Request, response *http.Response) (string, error) {
+	base := strings.Builder{}
+	seen := make(map[string]bool)
+	for _, component := range components {
+		identifier := serializeSFItem(component)
+		if seen[identifier] {
+			return "", errors.Errorf("Duplicate signature component %s", identifier)
+		}
+		seen[identifier] = true
+		values, err := signatureComponentValues(component, request, response)
+		if err != nil {
+			return "", err
+		}
+		for _, v := range values {
+			base.WriteString(identifier + ": " + v + "\n")
+		}
+	}
+	base.WriteString(`"@signature-params": ` + serializeSFInnerList(components, params))
+	return base.String(), nil
+}
+
+/* signatureComponentValues returns the component value of a signature component, @query-param has a value per occurrence */
+func signatureComponentValues(component sfItem, request *http.Request, response *http.Response) ([]string, error) {
+	name := component.value.(string)
+	for _, p := range component.params {
+		if p.name != "req" && p.name != "name" {
+			return nil, errors.Errorf("Unsupported parameter %q of signature component %s", p.name, serializeSFItem(component))
+		}
+	}
+	_, fromRequest := component.params.get("req")
+	if fromRequest && response == nil {
+		return nil, errors.Errorf("Signature component %s can only be used for responses", serializeSFItem(component))
+	}
+	ofResponse := response != nil && !fromRequest
+
+	if !strings.HasPrefix(name, "@") {
+		header := request.Header
+		if ofResponse {
+			header = response.Header
+		}
+		values := header.Values(name)
+		if len(values) == 0 && name == "host" && !ofResponse {
+			values = []string{requestHost(request)}
+		}
+		if len(values) == 0 {
+			return nil, errors.Errorf("Signed header %q is missing", name)
+		}
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.TrimSpace(v)
+		}
+		return []string{strings.Join(trimmed, ", ")}, nil
+	}
+
+	if name == "@status" {
+		if !ofResponse {
+			return nil, errors.New("Signature component @status can only be used for responses")
+		}
+		return []string{fmt.Sprintf("%03d", response.StatusCode)}, nil
+	}
+	if ofResponse {
+		return nil, errors.Errorf("Signature component %s of a response must refer to the request with the req parameter", name)
+	}
+	switch name {
+	case "@method":
+		return []string{request.Method}, nil
+	case "@target-uri":
+		target := *request.URL
+		target.Scheme, target.Host = requestScheme(request), requestHost(request)
+		return []string{target.String()}, nil
+	case "@authority":
+		return []string{strings.ToLower(requestHost(request))}, nil
+	case "@scheme":
+		return []string{requestScheme(request)}, nil
+	case "@request-target":
+		return []string{request.URL.RequestURI()}, nil
+	case "@path":
+		if path := request.URL.EscapedPath(); path != "" {
+			return []string{path}, nil
+		}
+		return []string{"/"}, nil
+	case "@query":
+		return []string{"?" + request.URL.RawQuery}, nil
+	case "@query-param":
+		paramName, _ := component.params.get("name")
+		queryName, ok := paramName.(string)
+		if !ok {
+			return nil, errors.New(`Signature component @query-param requires a name parameter`)
+		}
+		decodedName, err := url.QueryUnescape(queryName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid @query-param name %q", queryName)
+		}
+		values := request.URL.Query()[decodedName]
+		if len(values) == 0 {
+			return nil, errors.Errorf("Signed query parameter %q is missing", decodedName)
+		}
+		encoded := make([]string, len(values))
+		for i, v := range values {
+			encoded[i] = strings.ReplaceAll(url.QueryEscape(v), "+", "%20")
+		}
+		return encoded, nil
+	}
+	return nil, errors.Errorf("Unsupported signature component %s", name)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+/* requestScheme returns the lowercased scheme of request, incoming server requests have no scheme in their URL */
+func requestScheme(request *http.Request) string {
+	if request.URL.Scheme != "" {
+		return strings.ToLower(request.URL.Scheme)
+	}
+	if request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+/* ecdsaSignature is the ASN.1 form of ECDSA signatures that crypto.Signer returns */
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+/* messageSignatureHash returns the hash function the algorithm signs with and the size of the ECDSA signature halves */
+func messageSignatureHash(algorithm MessageSignatureAlgorithm) (crypto.Hash, int) {
+	switch algorithm {
+	case MessageSignatureECDSAP256SHA256:
+		return crypto.SHA256, 32
+	case MessageSignatureECDSAP384SHA384:
+		return crypto.SHA384, 48
+	case MessageSignatureRSAPSSSHA512:
+		return crypto.SHA512, 0
+	case MessageSignatureRSAv15SHA256:
+		return crypto.SHA256, 0
+	}
+	return 0, 0
+}
+
+func hashMessage(hash crypto.Hash, message string) []byte {
+	switch hash {
+	case crypto.SHA256:
+		sum := sha256.Sum256([]byte(message))
+		return sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384([]byte(message))
+		return sum[:]
+	}
+	sum := sha512.Sum512([]byte(message))
+	return sum[:]
+}
+
+/* signMessage signs the signature base, ECDSA signatures are encoded as the concatenation of r and s */
+func signMessage(algorithm MessageSignatureAlgorithm, signer crypto.Signer, secret []byte, base string) ([]byte, error) {
+	if algorithm == MessageSignatureHMACSHA256 {
+		if len(secret) == 0 {
+			return nil, errors.New("Secret is required to sign with hmac-sha256")
+		}
+		return hmacSHA256(secret, base), nil
+	}
+	if signer == nil {
+		return nil, errors.Errorf("Signer is required to sign with %s", algorithm)
+	}
+
+	var signature []byte
+	var err error
+	hash, ecdsaSize := messageSignatureHash(algorithm)
+	switch algorithm {
+	case MessageSignatureEd25519:
+		signature, err = signer.Sign(rand.Reader, []byte(base), crypto.Hash(0))
+	case MessageSignatureECDSAP256SHA256, MessageSignatureECDSAP384SHA384:
+		if key, ok := signer.Public().(*ecdsa.PublicKey); !ok || key.Params().BitSize != ecdsaSize*8 {
+			return nil, errors.Errorf("Signer key does not match %s", algorithm)
+		}
+		var der []byte
+		if der, err = signer.Sign(rand.Reader, hashMessage(hash, base), hash); err != nil {
+			break
+		}
+		var parsed ecdsaSignature
+		if _, err = asn1.Unmarshal(der, &parsed); err != nil {
+			break
+		}
+		if parsed.R.Sign() <= 0 || parsed.S.Sign() <= 0 || parsed.R.BitLen() > ecdsaSize*8 || parsed.S.BitLen() > ecdsaSize*8 {
+			err = errors.New("Signer returned a signature of another curve")
+			break
+		}
+		signature = make([]byte, 2*ecdsaSize)
+		parsed.R.FillBytes(signature[:ecdsaSize])
+		parsed.S.FillBytes(signature[ecdsaSize:])
+	case MessageSignatureRSAPSSSHA512:
+		signature, err = signer.Sign(rand.Reader, hashMessage(hash, base), &rsa.PSSOptions{SaltLength: 64, Hash: hash})
+	case MessageSignatureRSAv15SHA256:
+		signature, err = signer.Sign(rand.Reader, hashMessage(hash, base), hash)
+	default:
+		return nil, errors.Errorf("Unsupported message signature algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to sign message with %s", algorithm)
+	}
+	return signature, nil
+}
+
+/* verifyMessage verifies the signature of the signature base with publicKey, or with secret for hmac-sha256 */
+func verifyMessage(algorithm MessageSignatureAlgorithm, publicKey crypto.PublicKey, secret []byte, base string, signature []byte) error {
+	invalid := errors.New("Invalid message signature")
+	hash, ecdsaSize := messageSignatureHash(algorithm)
+	switch algorithm {
+	case MessageSignatureHMACSHA256:
+		if len(secret) == 0 {
+			return errors.New("Secret is required to verify hmac-sha256 signatures")
+		}
+		if !hmac.Equal(hmacSHA256(secret, base), signature) {
+			return invalid
+		}
+	case MessageSignatureEd25519:
+		key, ok := publicKey.(ed25519.PublicKey)
+		if !ok {
+			return errors.Errorf("Public key %T cannot verify %s signatures", publicKey, algorithm)
+		}
+		if !ed25519.Verify(key, []byte(base), signature) {
+			return invalid
+		}
+	case MessageSignatureECDSAP256SHA256, MessageSignatureECDSAP384SHA384:
+		key, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok || key.Params().BitSize != ecdsaSize*8 {
+			return errors.Errorf("Public key %T cannot verify %s signatures", publicKey, algorithm)
+		}
+		if len(signature) != 2*ecdsaSize {
+			return invalid
+		}
+		r, s := new(big.Int).SetBytes(signature[:ecdsaSize]), new(big.Int).SetBytes(signature[ecdsaSize:])
+		if !ecdsa.Verify(key, hashMessage(hash, base), r, s) {
+			return invalid
+		}
+	case MessageSignatureRSAPSSSHA512, MessageSignatureRSAv15SHA256:
+		key, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return errors.Errorf("Public key %T cannot verify %s signatures", publicKey, algorithm)
+		}
+		var err error
+		if algorithm == MessageSignatureRSAPSSSHA512 {
+			err = rsa.VerifyPSS(key, hash, hashMessage(hash, base), signature, &rsa.PSSOptions{SaltLength: 64, Hash: hash})
+		} else {
+			err = rsa.VerifyPKCS1v15(key, hash, hashMessage(hash, base), signature)
+		}
+		if err != nil {
+			return invalid
+		}
+	default:
+		return errors.Errorf("Unsupported message signature algorithm %q", algorithm)
+	}
+	return nil
+}
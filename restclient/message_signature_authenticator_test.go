@@ -0,0 +1,260 @@
+package restclient
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* Test keys and messages of RFC 9421 appendix B */
+const (
+	rfc9421Ed25519PrivateKey = "MC4CAQAwBQYDK2VwBCIEIJ+DYvh6SEqVTm50DFtMDoQikTmiCqirVv9mWG9qfSnF"
+	rfc9421ECCP256PublicKey  = "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEqIVYZVLCrPZHGHjP17CTW0/+D9Lfw0EkjqF7xB4FivAxzic30tMM4GF+hR6Dxh71Z50VGGdldkkDXZCnTNnoXQ=="
+	rfc9421SharedSecret      = "uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ=="
+)
+
+func rfc9421TestRequest() *http.Request {
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/foo?param=Value&Pet=dog", strings.NewReader(`{"hello": "world"}`))
+	if err != nil {
+		log.Fatalf("failed to construct testRequest, %v", err)
+	}
+	req.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Digest", "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:")
+	req.Header.Set("Content-Length", "18")
+	return req
+}
+
+func TestMessageSignatureAuthenticator(t *testing.T) {
+	created := func() time.Time { return time.Unix(1618884473, 0) }
+
+	Convey("TEST ed25519 signature of RFC 9421 B.2.6", t, func() {
+		der, _ := base64.StdEncoding.DecodeString(rfc9421Ed25519PrivateKey)
+		key, err := x509.ParsePKCS8PrivateKey(der)
+		So(err, ShouldBeNil)
+		req := rfc9421TestRequest()
+		auth := &MessageSignatureAuthenticator{
+			Label:      "sig-b26",
+			KeyID:      "test-key-ed25519",
+			Algorithm:  MessageSignatureEd25519,
+			Signer:     key.(ed25519.PrivateKey),
+			Components: []string{"date", "@method", "@path", "@authority", "content-type", "content-length"},
+			Now:        created,
+		}
+		err = auth.Apply(req)
+
+		Convey("Signature-Input and Signature should match the RFC", func() {
+			So(err, ShouldBeNil)
+			So(req.Header.Get("Signature-Input"), ShouldEqual, `sig-b26=("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`)
+			So(req.Header.Get("Signature"), ShouldEqual, "sig-b26=:wqcAqbmYJ2ji2glfAMaRy4gruYYnx2nEFN2HN6jrnDnQCK1u02Gb04v9EDgwUPiu4A0w6vuQv5lIp5WPpBKRCw==:")
+		})
+	})
+
+	Convey("TEST hmac-sha256 signature of RFC 9421 B.2.5", t, func() {
+		secret, _ := base64.StdEncoding.DecodeString(rfc9421SharedSecret)
+		req := rfc9421TestRequest()
+		auth := NewHMACMessageSignatureAuthenticator("test-shared-secret", secret).(*MessageSignatureAuthenticator)
+		auth.Label, auth.Components, auth.Now = "sig-b25", []string{"Date", "@authority", "Content-Type"}, created
+		err := auth.Apply(req)
+
+		Convey("Signature should match the RFC and be verifiable", func() {
+			So(err, ShouldBeNil)
+			So(req.Header.Get("Signature"), ShouldEqual, "sig-b25=:pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=:")
+			So(NewHMACMessageSignatureVerifier("test-shared-secret", secret).VerifyRequest(req), ShouldBeNil)
+		})
+	})
+
+	Convey("TEST signing fails when a covered header is missing", t, func() {
+		secret, _ := base64.StdEncoding.DecodeString(rfc9421SharedSecret)
+		auth := NewHMACMessageSignatureAuthenticator("test-shared-secret", secret).(*MessageSignatureAuthenticator)
+		auth.Components = []string{"@method", "x-missing"}
+		err := auth.Apply(rfc9421TestRequest())
+
+		Convey("Error should name the missing header", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "x-missing")
+		})
+	})
+
+	Convey("TEST signing fails when the key does not match the algorithm", t, func() {
+		p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+		p384Err := NewMessageSignatureAuthenticator("ec", MessageSignatureECDSAP256SHA256, p384Key).Apply(rfc9421TestRequest())
+		rsaErr := NewMessageSignatureAuthenticator("rsa", MessageSignatureECDSAP384SHA384, rsaKey).Apply(rfc9421TestRequest())
+
+		Convey("Error should be returned instead of a panic", func() {
+			So(p384Err, ShouldNotBeNil)
+			So(p384Err.Error(), ShouldContainSubstring, "Signer key does not match ecdsa-p256-sha256")
+			So(rsaErr, ShouldNotBeNil)
+		})
+	})
+
+	Convey("TEST ecdsa and rsa-pss signatures with query parameters can be verified", t, func() {
+		ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+		components := []string{"@method", "@target-uri", "@query", `@query-param;name="Pet"`, "content-digest"}
+		ecReq, rsaReq := rfc9421TestRequest(), rfc9421TestRequest()
+		ecAuth := &MessageSignatureAuthenticator{KeyID: "ec", Algorithm: MessageSignatureECDSAP384SHA384, Signer: ecKey,
+			Components: components, ContentDigest: true, IncludeAlgorithm: true, Nonce: true, Expires: time.Minute}
+		rsaAuth := &MessageSignatureAuthenticator{KeyID: "rsa", Algorithm: MessageSignatureRSAPSSSHA512, Signer: rsaKey,
+			Components: components}
+		ecErr, rsaErr := ecAuth.Apply(ecReq), rsaAuth.Apply(rsaReq)
+		ecVerifier := NewMessageSignatureVerifier("ec", MessageSignatureECDSAP384SHA384, ecKey.Public())
+		ecVerifier.RequiredComponents = []string{"content-digest"}
+
+		Convey("Signatures should be verified and the body should be left intact", func() {
+			So(ecErr, ShouldBeNil)
+			So(rsaErr, ShouldBeNil)
+			So(ecReq.Header.Get("Content-Digest"), ShouldStartWith, "sha-256=:")
+			So(ecVerifier.VerifyRequest(ecReq), ShouldBeNil)
+			body, _ := ioutil.ReadAll(ecReq.Body)
+			So(string(body), ShouldEqual, `{"hello": "world"}`)
+			So(NewMessageSignatureVerifier("rsa", MessageSignatureRSAPSSSHA512, rsaKey.Public()).VerifyRequest(rsaReq), ShouldBeNil)
+		})
+
+		Convey("Tampered requests should be rejected", func() {
+			rsaReq.URL.RawQuery = "param=Value&Pet=cat"
+			So(NewMessageSignatureVerifier("rsa", MessageSignatureRSAPSSSHA512, rsaKey.Public()).VerifyRequest(rsaReq), ShouldNotBeNil)
+			ecReq.Body = ioutil.NopCloser(strings.NewReader(`{"hello": "dog"}`))
+			So(ecVerifier.VerifyRequest(ecReq), ShouldNotBeNil)
+			So(NewMessageSignatureVerifier("ec", MessageSignatureEd25519, ecKey.Public()).VerifyRequest(ecReq), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestMessageSignatureVerifier(t *testing.T) {
+	der, _ := base64.StdEncoding.DecodeString(rfc9421ECCP256PublicKey)
+	publicKey, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		log.Fatalf("failed to parse test key, %v", err)
+	}
+	newResponse := func(body string) *http.Response {
+		header := http.Header{}
+		header.Set("Date", "Tue, 20 Apr 2021 02:07:56 GMT")
+		header.Set("Content-Type", "application/json")
+		header.Set("Content-Digest", "sha-512=:mEWXIS7MaLRuGgxOBdODa3xqM1XdEvxoYhvlCFJ41QJgJc4GTsPp29l5oGX69wWdXymyU0rjJuahq4l5aGgfLQ==:")
+		header.Set("Content-Length", "23")
+		header.Set("Signature-Input", `sig-b24=("@status" "content-type" "content-digest" "content-length");created=1618884473;keyid="test-key-ecc-p256"`)
+		header.Set("Signature", "sig-b24=:wNmSUAhwb5LxtOtOpNa6W5xj067m5hFrj0XQ4fvpaCLx0NKocgPquLgyahnzDnDAUy5eCdlYUEkLIj+32oiasw==:")
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: ioutil.NopCloser(strings.NewReader(body))}
+	}
+	verifier := NewMessageSignatureVerifier("test-key-ecc-p256", MessageSignatureECDSAP256SHA256, publicKey)
+	verifier.RequiredComponents = []string{"@status", "content-digest"}
+
+	Convey("TEST ecdsa-p256-sha256 response signature of RFC 9421 B.2.4", t, func() {
+		resp := newResponse(`{"message": "good dog"}`)
+		err := verifier.Verify(rfc9421TestRequest(), resp)
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		Convey("Signature and content digest should be verified and the body should still be readable", func() {
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, `{"message": "good dog"}`)
+		})
+	})
+
+	Convey("TEST response verification failures", t, func() {
+		Convey("Body not matching the content digest should be rejected", func() {
+			So(verifier.Verify(rfc9421TestRequest(), newResponse(`{"message": "bad dog"}`)), ShouldNotBeNil)
+		})
+		Convey("Changed status should be rejected", func() {
+			resp := newResponse(`{"message": "good dog"}`)
+			resp.StatusCode = http.StatusCreated
+			So(verifier.Verify(rfc9421TestRequest(), resp), ShouldNotBeNil)
+		})
+		Convey("Signature older than MaxAge should be rejected", func() {
+			aged := *verifier
+			aged.MaxAge = time.Hour
+			So(aged.Verify(rfc9421TestRequest(), newResponse(`{"message": "good dog"}`)), ShouldNotBeNil)
+		})
+		Convey("Signature not covering a required component should be rejected", func() {
+			strict := *verifier
+			strict.RequiredComponents = []string{"date"}
+			So(strict.Verify(rfc9421TestRequest(), newResponse(`{"message": "good dog"}`)), ShouldNotBeNil)
+		})
+		Convey("Unsigned response should be rejected", func() {
+			resp := newResponse(`{"message": "good dog"}`)
+			resp.Header.Del("Signature")
+			So(verifier.Verify(rfc9421TestRequest(), resp), ShouldNotBeNil)
+		})
+	})
+
+	Convey("TEST signed requests and verified responses end to end", t, func() {
+		clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		serverPublic, serverKey, _ := ed25519.GenerateKey(rand.Reader)
+		tamper := false
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestVerifier := NewMessageSignatureVerifier("client", MessageSignatureECDSAP256SHA256, clientKey.Public())
+			requestVerifier.RequiredComponents = []string{"@method", "@target-uri", "content-digest"}
+			if err := requestVerifier.VerifyRequest(r); err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			body, _ := json.Marshal(testHttpResponse{StatusCode: http.StatusOK, Data: testSuccess})
+			sum := sha256.Sum256(body)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Digest", "sha-256="+serializeSFBareItem(sum[:]))
+			components, _ := parseSignatureComponents([]string{"@status", "content-digest", "@method;req", "@target-uri;req"})
+			params := sfParams{{name: "created", value: time.Now().Unix()}, {name: "keyid", value: "server"}}
+			base, _ := signatureBase(components, params, r, &http.Response{StatusCode: http.StatusOK, Header: w.Header()})
+			signature, _ := signMessage(MessageSignatureEd25519, serverKey, nil, base)
+			w.Header().Set("Signature-Input", "sig1="+serializeSFInnerList(components, params))
+			w.Header().Set("Signature", "sig1="+serializeSFBareItem(signature))
+			if tamper {
+				body = bytes.Replace(body, []byte(testSuccess), []byte("tampered"), 1)
+			}
+			_, _ = w.Write(body)
+		}))
+		defer ts.Close()
+
+		doPost := func() (testHttpResponse, RequestError) {
+			var testResponse testHttpResponse
+			auth := NewMessageSignatureAuthenticator("client", MessageSignatureECDSAP256SHA256, clientKey).(*MessageSignatureAuthenticator)
+			auth.ContentDigest = true
+			responseVerifier := NewMessageSignatureVerifier("server", MessageSignatureEd25519, serverPublic)
+			responseVerifier.RequiredComponents = []string{"@status", "content-digest", "@target-uri;req"}
+			responseVerifier.MaxAge = time.Minute
+			req, reqErr := RequestBuilder().
+				RawUrl(ts.URL + "/tasks?tenantId=1").
+				BodyJson(testRequestBody{TestId: 123, TestName: "Testing Request Body"}).
+				Auth(auth).
+				VerifyResponse(responseVerifier).
+				ResponseReference(&testResponse).
+				Build()
+			if reqErr != nil {
+				log.Fatalf("failed to construct testRequest, %v", reqErr)
+			}
+			return testResponse, req.Post()
+		}
+
+		Convey("Signed request should be accepted and the signed response should be decoded", func() {
+			testResponse, reqErr := doPost()
+			So(reqErr, ShouldBeNil)
+			So(testResponse.Data, ShouldEqual, testSuccess)
+		})
+
+		Convey("Tampered response should fail with ResponseVerificationErr without being decoded", func() {
+			tamper = true
+			testResponse, reqErr := doPost()
+			So(reqErr, ShouldNotBeNil)
+			So(reqErr.GetTopLevelError(), ShouldEqual, ResponseVerificationErr)
+			So(reqErr.GetStatusCode(), ShouldEqual, http.StatusOK)
+			So(testResponse.Data, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,374 @@
+package restclient
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOAuth2RedirectPath = "/callback"
+	defaultOAuth2ListenAddr   = "127.0.0.1:0"
+)
+
+/* OAuth2AuthorizationRequiredErr is returned by OAuth2TokenSource when there is no token to use or refresh, the user
+has to go through AuthorizationCodeFlow.Authorize first */
+var OAuth2AuthorizationRequiredErr = errors.New("OAuth2 authorization required - No refreshable token is stored")
+
+/* OAuth2Token is a token response of an OAuth2 token endpoint (RFC 6749 section 5.1). Expiry is computed from
+expires_in when the token is received */
+type OAuth2Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresIn    int64     `json:"expires_in,omitempty"`
+	Scope        string    `json:"scope,omitempty"`
+	IDToken      string    `json:"id_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+/* valid reports whether the access token can be used for a while more, tokens without expiry never expire */
+func (t *OAuth2Token) valid() bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || time.Now().Add(defaultTokenExpiryLeeway).Before(t.Expiry))
+}
+
+/* TokenStore persists OAuth2 tokens between runs. Implement it to keep tokens in e.g. the OS keychain */
+type TokenStore interface {
+	/* Load returns the stored token, nil if there is none */
+	Load() (*OAuth2Token, error)
+	Save(token *OAuth2Token) error
+}
+
+/* MemoryTokenStore is a TokenStore that keeps the token in memory */
+type MemoryTokenStore struct {
+	mu    sync.Mutex
+	token *OAuth2Token
+}
+
+func (ms *MemoryTokenStore) Load() (*OAuth2Token, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.token == nil {
+		return nil, nil
+	}
+	token := *ms.token
+	return &token, nil
+}
+
+func (ms *MemoryTokenStore) Save(token *OAuth2Token) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	saved := *token
+	ms.token = &saved
+	return nil
+}
+
+/* FileTokenStore is a TokenStore that keeps the token as JSON in a file only readable by the current user */
+type FileTokenStore struct {
+	Path string
+}
+
+func (fs FileTokenStore) Load() (*OAuth2Token, error) {
+	content, err := ioutil.ReadFile(fs.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read token file %s", fs.Path)
+	}
+	var token OAuth2Token
+	if err = json.Unmarshal(content, &token); err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode token file %s", fs.Path)
+	}
+	return &token, nil
+}
+
+/* Save writes the token to a temporary file first and renames it, so that a crash never leaves a partial token file */
+func (fs FileTokenStore) Save(token *OAuth2Token) error {
+	content, err := json.Marshal(token)
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode token")
+	}
+	return writeFileAtomic(fs.Path, content)
+}
+
+/* PKCE is a Proof Key for Code Exchange (RFC 7636) verifier with its S256 challenge */
+type PKCE struct {
+	Verifier, Challenge, Method string
+}
+
+/* NewPKCE generates a random 43 character verifier and its S256 challenge */
+func NewPKCE() (PKCE, error) {
+	verifier, err := randomURLSafeString(32)
+	if err != nil {
+		return PKCE{}, errors.Wrap(err, "Failed to generate PKCE verifier")
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return PKCE{
+		Verifier:  verifier,
+		Challenge: base64.RawURLEncoding.EncodeToString(sum[:]),
+		Method:    "S256",
+	}, nil
+}
+
+func randomURLSafeString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+/* OAuth2Config describes the OAuth2 client and the endpoints of the authorization server.
+- ClientID and ClientSecret: the client credentials, public clients (e.g. CLIs) leave ClientSecret empty and send
+  client_id in the token requests instead
+- AuthURL and TokenURL: the authorization and token endpoints
+- Scopes: requested scopes
+- RedirectPath and ListenAddr: path and address of the loopback callback server, defaults to "/callback" and
+  "127.0.0.1:0" (a random port). Set a fixed port if the authorization server requires an exact redirect URI
+- AuthParams: additional parameters of the authorization request e.g. "prompt" or "audience" */
+type OAuth2Config struct {
+	ClientID, ClientSecret string
+	AuthURL, TokenURL      string
+	Scopes                 []string
+	RedirectPath           string
+	ListenAddr             string
+	AuthParams             url.Values
+}
+
+/* AuthorizationCodeFlow acts on behalf of a user with the OAuth2 authorization code grant with PKCE (RFC 8252 native
+app flow). OpenBrowser is called with the authorization URL, it should open it in the user's browser or print it.
+Tokens are saved to Store */
+type AuthorizationCodeFlow struct {
+	Config      OAuth2Config
+	Store       TokenStore
+	OpenBrowser func(authURL string) error
+}
+
+/* NewAuthorizationCodeFlow creates an AuthorizationCodeFlow, tokens are kept in memory if store is nil */
+func NewAuthorizationCodeFlow(config OAuth2Config, store TokenStore, openBrowser func(authURL string) error) *AuthorizationCodeFlow {
+	if store == nil {
+		store = &MemoryTokenStore{}
+	}
+	return &AuthorizationCodeFlow{
+		Config:      config,
+		Store:       store,
+		OpenBrowser: openBrowser,
+	}
+}
+
+/* oauth2Callback is the result of the redirect to the loopback server */
+type oauth2Callback struct {
+	code string
+	err  error
+}
+
+/* Authorize starts a loopback server, sends the user to the authorization URL and waits for the redirect until ctx is
+done. The received code is exchanged for tokens that are saved to the store and returned */
+func (af *AuthorizationCodeFlow) Authorize(ctx context.Context) (*OAuth2Token, error) {
+	pkce, err := NewPKCE()
+	if err != nil {
+		return nil, err
+	}
+	state, err := randomURLSafeString(16)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to generate OAuth2 state")
+	}
+
+	redirectPath, listenAddr := af.Config.RedirectPath, af.Config.ListenAddr
+	if redirectPath == "" {
+		redirectPath = defaultOAuth2RedirectPath
+	}
+	if listenAddr == "" {
+		listenAddr = defaultOAuth2ListenAddr
+	}
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to start loopback server for OAuth2 redirect")
+	}
+	redirectURI := "http://" + listener.Addr().String() + redirectPath
+
+	callbacks := make(chan oauth2Callback, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != redirectPath {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		var callback oauth2Callback
+		switch {
+		case query.Get("state") != state:
+			callback.err = errors.New("OAuth2 redirect has an unexpected state")
+		case query.Get("error") != "":
+			callback.err = errors.Errorf("OAuth2 authorization failed: %s %s", query.Get("error"), query.Get("error_description"))
+		case query.Get("code") == "":
+			callback.err = errors.New("OAuth2 redirect has no authorization code")
+		default:
+			callback.code = query.Get("code")
+		}
+		if callback.err != nil {
+			http.Error(w, callback.err.Error(), http.StatusBadRequest)
+		} else {
+			_, _ = fmt.Fprintln(w, "Authorization completed, you can close this window.")
+		}
+		select {
+		case callbacks <- callback:
+		default:
+		}
+	})}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Close()
+
+	authParams := url.Values{}
+	for name, values := range af.Config.AuthParams {
+		authParams[name] = values
+	}
+	authParams.Set("response_type", "code")
+	authParams.Set("client_id", af.Config.ClientID)
+	authParams.Set("redirect_uri", redirectURI)
+	authParams.Set("state", state)
+	authParams.Set("code_challenge", pkce.Challenge)
+	authParams.Set("code_challenge_method", pkce.Method)
+	if len(af.Config.Scopes) != 0 {
+		authParams.Set("scope", strings.Join(af.Config.Scopes, " "))
+	}
+	authURL := af.Config.AuthURL + "?" + authParams.Encode()
+	if strings.Contains(af.Config.AuthURL, "?") {
+		authURL = af.Config.AuthURL + "&" + authParams.Encode()
+	}
+	if af.OpenBrowser == nil {
+		return nil, errors.New("OpenBrowser is required to send the user to the authorization URL")
+	}
+	if err = af.OpenBrowser(authURL); err != nil {
+		return nil, errors.Wrap(err, "Failed to open authorization URL")
+	}
+
+	var callback oauth2Callback
+	select {
+	case callback = <-callbacks:
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "OAuth2 authorization was not completed")
+	}
+	if callback.err != nil {
+		return nil, callback.err
+	}
+
+	return af.requestToken(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {callback.code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {pkce.Verifier},
+	}, nil)
+}
+
+/* TokenSource returns a RefreshableTokenSource of the stored tokens, use it with NewRefreshableBearerAuthenticator */
+func (af *AuthorizationCodeFlow) TokenSource() *OAuth2TokenSource {
+	return &OAuth2TokenSource{flow: af}
+}
+
+/* requestToken sends a token request through RequestBuilder and saves the received token. The refresh token of
+previous is kept if the response does not rotate it */
+func (af *AuthorizationCodeFlow) requestToken(ctx context.Context, form url.Values, previous *OAuth2Token) (*OAuth2Token, error) {
+	var auth Authenticator
+	if af.Config.ClientSecret != "" {
+		auth = NewBasicAuthenticator(url.QueryEscape(af.Config.ClientID), url.QueryEscape(af.Config.ClientSecret))
+	} else {
+		form.Set("client_id", af.Config.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, af.Config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to construct token request")
+	}
+
+	var token OAuth2Token
+	hr, reqErr := RequestBuilder().
+		Request(req).
+		Header(&http.Header{"Content-Type": []string{"application/x-www-form-urlencoded"}}).
+		Auth(auth).
+		ResponseReference(&token).
+		Build()
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	if reqErr = hr.Post(); reqErr != nil {
+		return nil, errors.Wrap(reqErr, "OAuth2 token request failed")
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("OAuth2 token response has no access_token")
+	}
+
+	if token.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	if token.RefreshToken == "" && previous != nil {
+		token.RefreshToken = previous.RefreshToken
+	}
+	if af.Store != nil {
+		if err = af.Store.Save(&token); err != nil {
+			return nil, errors.Wrap(err, "Failed to save OAuth2 token")
+		}
+	}
+	return &token, nil
+}
+
+/* OAuth2TokenSource is a RefreshableTokenSource of the tokens stored by an AuthorizationCodeFlow. The access token is
+refreshed with the refresh token once it is about to expire or Refresh is called */
+type OAuth2TokenSource struct {
+	flow *AuthorizationCodeFlow
+	mu   sync.Mutex
+}
+
+func (ts *OAuth2TokenSource) Token() (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	token, err := ts.flow.Store.Load()
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to load OAuth2 token")
+	}
+	if token.valid() {
+		return token.AccessToken, nil
+	}
+	if token, err = ts.refreshLocked(context.Background(), token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+func (ts *OAuth2TokenSource) Refresh(ctx context.Context) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	token, err := ts.flow.Store.Load()
+	if err != nil {
+		return errors.Wrap(err, "Failed to load OAuth2 token")
+	}
+	_, err = ts.refreshLocked(ctx, token)
+	return err
+}
+
+func (ts *OAuth2TokenSource) refreshLocked(ctx context.Context, token *OAuth2Token) (*OAuth2Token, error) {
+	if token == nil || token.RefreshToken == "" {
+		return nil, OAuth2AuthorizationRequiredErr
+	}
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+	}
+	if len(ts.flow.Config.Scopes) != 0 {
+		form.Set("scope", strings.Join(ts.flow.Config.Scopes, " "))
+	}
+	return ts.flow.requestToken(ctx, form, token)
+}
@@ -0,0 +1,173 @@
+package restclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+/* testIdP is a minimal OAuth2 authorization server supporting the authorization code grant with PKCE and refresh tokens */
+type testIdP struct {
+	mu         sync.Mutex
+	challenges map[string]string // code -> code challenge
+	issued     int
+	denyNext   bool
+}
+
+func (idp *testIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/authorize":
+		query := r.URL.Query()
+		redirect := query.Get("redirect_uri") + "?state=" + query.Get("state")
+		if idp.denyNext || query.Get("client_id") != "cli" || query.Get("code_challenge_method") != "S256" ||
+			query.Get("scope") != "tasks:read offline_access" {
+			idp.denyNext = false
+			http.Redirect(w, r, redirect+"&error=access_denied", http.StatusFound)
+			return
+		}
+		code := fmt.Sprintf("code-%d", len(idp.challenges))
+		idp.challenges[code] = query.Get("code_challenge")
+		http.Redirect(w, r, redirect+"&code="+code, http.StatusFound)
+	case "/token":
+		_ = r.ParseForm()
+		reject := func() {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		}
+		if r.PostForm.Get("client_id") != "cli" || r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+			reject()
+			return
+		}
+		switch r.PostForm.Get("grant_type") {
+		case "authorization_code":
+			sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+			challenge, ok := idp.challenges[r.PostForm.Get("code")]
+			if !ok || challenge != base64.RawURLEncoding.EncodeToString(sum[:]) {
+				reject()
+				return
+			}
+			delete(idp.challenges, r.PostForm.Get("code"))
+		case "refresh_token":
+			if r.PostForm.Get("refresh_token") != "refresh-1" {
+				reject()
+				return
+			}
+		default:
+			reject()
+			return
+		}
+		idp.issued++
+		token := map[string]interface{}{"access_token": fmt.Sprintf("access-%d", idp.issued), "token_type": "Bearer", "expires_in": 3600}
+		if idp.issued == 1 {
+			token["refresh_token"] = "refresh-1"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(token)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	idp := &testIdP{challenges: make(map[string]string)}
+	idpServer := httptest.NewServer(idp)
+	defer idpServer.Close()
+
+	var apiTokens []string
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiTokens = append(apiTokens, r.Header.Get("Authorization"))
+		// Reject the first access token as if it was revoked
+		if r.Header.Get("Authorization") != "Bearer access-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer apiServer.Close()
+
+	// The browser is stood in by an http.Client following the redirects of the IdP to the loopback server
+	openBrowser := func(authURL string) error {
+		resp, err := http.Get(authURL)
+		if err != nil {
+			return err
+		}
+		_, _ = ioutil.ReadAll(resp.Body)
+		return resp.Body.Close()
+	}
+	config := OAuth2Config{
+		ClientID: "cli",
+		AuthURL:  idpServer.URL + "/authorize",
+		TokenURL: idpServer.URL + "/token",
+		Scopes:   []string{"tasks:read", "offline_access"},
+	}
+
+	Convey("TEST authorization code flow stores tokens that are refreshed on 401", t, func() {
+		dir, _ := ioutil.TempDir("", "restclient-oauth2")
+		defer os.RemoveAll(dir)
+		store := FileTokenStore{Path: filepath.Join(dir, "tokens", "token.json")}
+		flow := NewAuthorizationCodeFlow(config, store, openBrowser)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		token, authErr := flow.Authorize(ctx)
+		stored, loadErr := store.Load()
+
+		req, reqErr := RequestBuilder().
+			RawUrl(apiServer.URL).
+			Auth(NewRefreshableBearerAuthenticator(flow.TokenSource())).
+			Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+		reqErr = req.Get()
+		refreshed, _ := store.Load()
+		info, _ := os.Stat(store.Path)
+
+		Convey("Code should be exchanged with the PKCE verifier and the token should be refreshed once", func() {
+			So(authErr, ShouldBeNil)
+			So(token.AccessToken, ShouldEqual, "access-1")
+			So(token.Expiry, ShouldHappenAfter, time.Now().Add(time.Hour-time.Minute))
+			So(loadErr, ShouldBeNil)
+			So(stored.RefreshToken, ShouldEqual, "refresh-1")
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+
+			So(reqErr, ShouldBeNil)
+			So(apiTokens, ShouldResemble, []string{"Bearer access-1", "Bearer access-2"})
+			So(refreshed.AccessToken, ShouldEqual, "access-2")
+			So(refreshed.RefreshToken, ShouldEqual, "refresh-1")
+		})
+	})
+
+	Convey("TEST denied authorization is reported", t, func() {
+		idp.denyNext = true
+		_, err := NewAuthorizationCodeFlow(config, nil, openBrowser).Authorize(context.Background())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "access_denied")
+	})
+
+	Convey("TEST authorization times out if the user never completes it", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := NewAuthorizationCodeFlow(config, nil, func(string) error { return nil }).Authorize(ctx)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("TEST token source without a stored token asks for authorization", t, func() {
+		_, err := NewAuthorizationCodeFlow(config, nil, openBrowser).TokenSource().Token()
+		So(err, ShouldEqual, OAuth2AuthorizationRequiredErr)
+	})
+}
@@ -0,0 +1,424 @@
+package restclient
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/* Page is a page of a list endpoint fetched by a PageIterator */
+type Page struct {
+	Number   int           // index of the page in the iteration, starting from 0
+	Value    interface{}   // the page decoded into the value created by PaginationConfig.NewPage
+	Items    []interface{} // items of the page, see PaginationConfig.Items
+	Metadata ResponseMetadata
+}
+
+/* PaginationStrategy decides how the pages of a list endpoint are requested. Implement it for pagination styles
+other than LinkPagination, CursorPagination, OffsetPagination and PageNumberPagination */
+type PaginationStrategy interface {
+	/* First returns the builder of the first page out of the builder given to Paginate */
+	First(builder HttpRequestBuilder) HttpRequestBuilder
+	/* Next returns the builder of the page after page, which was requested with builder, false if page is the last one */
+	Next(builder HttpRequestBuilder, page *Page) (HttpRequestBuilder, bool, error)
+}
+
+/* PaginationConfig holds the configuration of a PageIterator.
+- Strategy: PaginationStrategy requesting the pages
+- NewPage: returns a new pointer to decode each page into e.g. func() interface{} { return &[]User{} }, defaults to a
+  pointer to an interface{}
+- Items: optional function returning the slice of items of a decoded page, defaults to the page itself if it is a slice
+- Prefetch: optionally fetch the next page concurrently while the current one is processed */
+type PaginationConfig struct {
+	Strategy PaginationStrategy
+	NewPage  func() interface{}
+	Items    func(page interface{}) interface{}
+	Prefetch bool
+}
+
+/* PageIterator iterates the pages of a list endpoint, create it with Paginate. Call Next until it returns false,
+then check Err. Close it to stop early */
+type PageIterator struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	config PaginationConfig
+
+	next     *HttpRequestBuilder // builder of the page to fetch next, nil after the last page
+	pending  chan pageResult     // prefetched next page, nil if it is not prefetched
+	page     *Page
+	number   int
+	err      RequestError
+	finished bool
+}
+
+type pageResult struct {
+	page *Page
+	err  RequestError
+}
+
+/* Paginate returns a PageIterator over the pages requested with builder according to config. Pages are requested
+with GET and cancelled along with ctx */
+func Paginate(ctx context.Context, builder HttpRequestBuilder, config PaginationConfig) *PageIterator {
+	ctx, cancel := context.WithCancel(ctx)
+	if config.NewPage == nil {
+		config.NewPage = func() interface{} {
+			return new(interface{})
+		}
+	}
+	first := config.Strategy.First(detachRequest(builder))
+	return &PageIterator{ctx: ctx, cancel: cancel, config: config, next: &first}
+}
+
+/* Next fetches the next page, it returns false once there are no more pages, an error occurs or the iterator is closed */
+func (pi *PageIterator) Next() bool {
+	if pi.finished {
+		return false
+	}
+	var result pageResult
+	if pi.pending != nil {
+		select {
+		case result = <-pi.pending:
+		case <-pi.ctx.Done():
+			result.err = cancelledError(pi.ctx, "Pagination")
+		}
+		pi.pending = nil
+	} else if pi.next != nil {
+		result = pi.fetch(*pi.next, pi.number)
+	} else {
+		pi.finish(nil)
+		return false
+	}
+	if result.err != nil {
+		pi.finish(result.err)
+		return false
+	}
+
+	next, more, err := pi.config.Strategy.Next(*pi.next, result.page)
+	if err != nil {
+		pi.finish(NewRequestBuildError(InvalidRequestErr, errors.Wrap(err, "Failed to request the next page")))
+		return false
+	}
+	pi.page, pi.next = result.page, nil
+	pi.number++
+	if more {
+		pi.next = &next
+		if pi.config.Prefetch {
+			pi.pending = make(chan pageResult, 1)
+			go func(pending chan pageResult, number int) {
+				pending <- pi.fetch(next, number)
+			}(pi.pending, pi.number)
+		}
+	}
+	return true
+}
+
+/* Page returns the current page */
+func (pi *PageIterator) Page() *Page {
+	return pi.page
+}
+
+/* Value returns the decoded value of the current page */
+func (pi *PageIterator) Value() interface{} {
+	if pi.page == nil {
+		return nil
+	}
+	return pi.page.Value
+}
+
+/* Err returns the error that stopped the iteration, nil if it stopped after the last page or was closed */
+func (pi *PageIterator) Err() RequestError {
+	return pi.err
+}
+
+/* Close stops the iteration and cancels the prefetch of the next page */
+func (pi *PageIterator) Close() {
+	pi.finish(nil)
+}
+
+/* Items returns an ItemIterator over the items of the pages of the iterator, starting from the current page */
+func (pi *PageIterator) Items() *ItemIterator {
+	return &ItemIterator{pages: pi, index: -1}
+}
+
+func (pi *PageIterator) finish(err RequestError) {
+	if !pi.finished {
+		pi.finished, pi.err, pi.page = true, err, nil
+		pi.cancel()
+	}
+}
+
+/* fetch requests a page with builder and decodes it */
+func (pi *PageIterator) fetch(builder HttpRequestBuilder, number int) pageResult {
+	if pi.ctx.Err() != nil {
+		return pageResult{err: cancelledError(pi.ctx, "Pagination")}
+	}
+	page := &Page{Number: number, Value: pi.config.NewPage()}
+	request, reqErr := builder.ResponseReference(page.Value).ResponseMetadata(&page.Metadata).Build()
+	if reqErr != nil {
+		return pageResult{err: reqErr}
+	}
+	request.request = request.request.WithContext(pi.ctx)
+	if reqErr = request.Get(); reqErr != nil {
+		if pi.ctx.Err() != nil {
+			reqErr = cancelledError(pi.ctx, "Pagination")
+		}
+		return pageResult{err: reqErr}
+	}
+
+	items := page.Value
+	if pi.config.Items != nil {
+		items = pi.config.Items(page.Value)
+	}
+	itemsValue := reflect.ValueOf(items)
+	for (itemsValue.Kind() == reflect.Ptr || itemsValue.Kind() == reflect.Interface) && !itemsValue.IsNil() {
+		itemsValue = itemsValue.Elem()
+	}
+	if itemsValue.Kind() == reflect.Slice || itemsValue.Kind() == reflect.Array {
+		page.Items = make([]interface{}, itemsValue.Len())
+		for i := range page.Items {
+			page.Items[i] = itemsValue.Index(i).Interface()
+		}
+	}
+	return pageResult{page: page}
+}
+
+/* ItemIterator iterates the items of the pages of a PageIterator, call Next until it returns false, then check Err */
+type ItemIterator struct {
+	pages *PageIterator
+	index int
+}
+
+/* Next moves to the next item, fetching the next page once the items of the current one are used up */
+func (ii *ItemIterator) Next() bool {
+	ii.index++
+	for ii.pages.page == nil || ii.index >= len(ii.pages.page.Items) {
+		if !ii.pages.Next() {
+			return false
+		}
+		ii.index = 0
+	}
+	return true
+}
+
+/* Value returns the current item */
+func (ii *ItemIterator) Value() interface{} {
+	if ii.pages.page == nil || ii.index >= len(ii.pages.page.Items) {
+		return nil
+	}
+	return ii.pages.page.Items[ii.index]
+}
+
+/* Page returns the page of the current item */
+func (ii *ItemIterator) Page() *Page {
+	return ii.pages.Page()
+}
+
+/* Err returns the error that stopped the iteration, nil if it stopped after the last item or was closed */
+func (ii *ItemIterator) Err() RequestError {
+	return ii.pages.Err()
+}
+
+/* Close stops the iteration */
+func (ii *ItemIterator) Close() {
+	ii.pages.Close()
+}
+
+/* LinkPagination follows the RFC 8288 Link header with rel="next" of each page, the last page has none. A next link
+to another origin fails the pagination, as the pages are requested with the credentials of the first one */
+type LinkPagination struct{}
+
+func (LinkPagination) First(builder HttpRequestBuilder) HttpRequestBuilder {
+	return builder
+}
+
+func (LinkPagination) Next(builder HttpRequestBuilder, page *Page) (HttpRequestBuilder, bool, error) {
+	next := linkTarget(page.Metadata.Header, "next")
+	if next == "" {
+		return builder, false, nil
+	}
+	nextURL, err := url.Parse(next)
+	if err != nil {
+		return builder, false, errors.Wrapf(err, "Invalid next link %s", next)
+	}
+	if page.Metadata.URL != nil {
+		nextURL = page.Metadata.URL.ResolveReference(nextURL)
+		if !sameOrigin(nextURL, page.Metadata.URL) {
+			return builder, false, errors.Errorf("Next link %s leads to another origin than %s", nextURL, page.Metadata.URL)
+		}
+	}
+	return builder.RawUrl(nextURL.String()), true, nil
+}
+
+/* CursorPagination sends the cursor found in each page by Cursor as the Param query parameter of the next page, the
+last page has an empty cursor */
+type CursorPagination struct {
+	Param  string
+	Cursor func(page *Page) (string, error)
+}
+
+func (cp CursorPagination) First(builder HttpRequestBuilder) HttpRequestBuilder {
+	return builder
+}
+
+func (cp CursorPagination) Next(builder HttpRequestBuilder, page *Page) (HttpRequestBuilder, bool, error) {
+	cursor, err := cp.Cursor(page)
+	if err != nil || cursor == "" {
+		return builder, false, err
+	}
+	return setQueryParam(builder, cp.Param, cursor), true, nil
+}
+
+/* OffsetPagination sends the offset of the first item of each page as the OffsetParam query parameter, starting from
+Start, and Limit as the optional LimitParam query parameter. The last page has less than Limit items, or none if
+Limit is not set */
+type OffsetPagination struct {
+	OffsetParam string
+	LimitParam  string
+	Start       int
+	Limit       int
+}
+
+func (op OffsetPagination) First(builder HttpRequestBuilder) HttpRequestBuilder {
+	builder = setQueryParam(builder, op.OffsetParam, strconv.Itoa(op.Start))
+	if op.LimitParam != "" && op.Limit > 0 {
+		builder = setQueryParam(builder, op.LimitParam, strconv.Itoa(op.Limit))
+	}
+	return builder
+}
+
+func (op OffsetPagination) Next(builder HttpRequestBuilder, page *Page) (HttpRequestBuilder, bool, error) {
+	if isLastPage(page, op.Limit) {
+		return builder, false, nil
+	}
+	offset, err := strconv.Atoi(queryParam(builder, op.OffsetParam))
+	if err != nil {
+		return builder, false, errors.Wrapf(err, "Invalid %s query parameter", op.OffsetParam)
+	}
+	return setQueryParam(builder, op.OffsetParam, strconv.Itoa(offset+len(page.Items))), true, nil
+}
+
+/* PageNumberPagination sends the number of each page as the Param query parameter, starting from Start, and Size as
+the optional SizeParam query parameter. Zero (0) Start means 1 unless ZeroBased is set for APIs whose first page is 0.
+The last page has less than Size items, or none if Size is not set */
+type PageNumberPagination struct {
+	Param     string
+	SizeParam string
+	Start     int
+	ZeroBased bool
+	Size      int
+}
+
+func (pp PageNumberPagination) First(builder HttpRequestBuilder) HttpRequestBuilder {
+	start := pp.Start
+	if start == 0 && !pp.ZeroBased {
+		start = 1
+	}
+	builder = setQueryParam(builder, pp.Param, strconv.Itoa(start))
+	if pp.SizeParam != "" && pp.Size > 0 {
+		builder = setQueryParam(builder, pp.SizeParam, strconv.Itoa(pp.Size))
+	}
+	return builder
+}
+
+func (pp PageNumberPagination) Next(builder HttpRequestBuilder, page *Page) (HttpRequestBuilder, bool, error) {
+	if isLastPage(page, pp.Size) {
+		return builder, false, nil
+	}
+	number, err := strconv.Atoi(queryParam(builder, pp.Param))
+	if err != nil {
+		return builder, false, errors.Wrapf(err, "Invalid %s query parameter", pp.Param)
+	}
+	return setQueryParam(builder, pp.Param, strconv.Itoa(number+1)), true, nil
+}
+
+/* isLastPage reports whether page is the last one of pages of size items, a page of unknown size is the last one once
+it is empty */
+func isLastPage(page *Page, size int) bool {
+	return len(page.Items) == 0 || (size > 0 && len(page.Items) < size)
+}
+
+/* detachRequest turns a builder of a pre-prepared http.Request into a builder of its URL and headers, so that each page
+is built into a request of its own */
+func detachRequest(builder HttpRequestBuilder) HttpRequestBuilder {
+	req := builder.hr.request
+	if req == nil {
+		return builder
+	}
+	builder.hr.request = nil
+	header := req.Header.Clone()
+	builder.ri.header = &header
+	return builder.RawUrl(req.URL.String())
+}
+
+/* setQueryParam returns a copy of builder with the query parameter name set to value */
+func setQueryParam(builder HttpRequestBuilder, name, value string) HttpRequestBuilder {
+	values := url.Values{}
+	if builder.ri.queryParams != nil {
+		for key, vs := range *builder.ri.queryParams {
+			values[key] = append([]string{}, vs...)
+		}
+	}
+	values.Set(name, value)
+	builder.ri.queryParams = &values
+	return builder
+}
+
+func queryParam(builder HttpRequestBuilder, name string) string {
+	if builder.ri.queryParams == nil {
+		return ""
+	}
+	return builder.ri.queryParams.Get(name)
+}
+
+/* linkTarget returns the target of the first RFC 8288 link in the Link headers with the relation type rel */
+func linkTarget(header http.Header, rel string) string {
+	for _, value := range header.Values("Link") {
+		for _, link := range splitOutsideQuotes(value, ',') {
+			link = strings.TrimSpace(link)
+			if !strings.HasPrefix(link, "<") || !strings.Contains(link, ">") {
+				continue
+			}
+			end := strings.Index(link, ">")
+			target := link[1:end]
+			for _, param := range splitOutsideQuotes(link[end+1:], ';') {
+				name, paramValue := param, ""
+				if i := strings.Index(param, "="); i >= 0 {
+					name, paramValue = param[:i], param[i+1:]
+				}
+				if !strings.EqualFold(strings.TrimSpace(name), "rel") {
+					continue
+				}
+				for _, relType := range strings.Fields(strings.Trim(strings.TrimSpace(paramValue), `"`)) {
+					if strings.EqualFold(relType, rel) {
+						return target
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+/* splitOutsideQuotes splits s at each sep that is not within a quoted string or a <target> */
+func splitOutsideQuotes(s string, sep byte) []string {
+	var parts []string
+	inQuotes, inTarget, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"' && !inTarget && (i == 0 || s[i-1] != '\\'):
+			inQuotes = !inQuotes
+		case s[i] == '<' && !inQuotes:
+			inTarget = true
+		case s[i] == '>' && !inQuotes:
+			inTarget = false
+		case s[i] == sep && !inQuotes && !inTarget:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,225 @@
+package restclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/* testCursorPage is a page of the cursor paginated endpoint of testPaginatedServer */
+type testCursorPage struct {
+	Items      []testRequestBody `json:"items"`
+	NextCursor string            `json:"nextCursor"`
+}
+
+/* testPaginatedServer serves 7 tasks with Link header, cursor, offset/limit and page number pagination */
+func testPaginatedServer(hits *int64) *httptest.Server {
+	tasks := make([]testRequestBody, 7)
+	for i := range tasks {
+		tasks[i] = testRequestBody{TestId: i + 1, TestName: fmt.Sprintf("task %d", i+1)}
+	}
+	window := func(start, size int) []testRequestBody {
+		if start > len(tasks) {
+			start = len(tasks)
+		}
+		end := start + size
+		if end > len(tasks) {
+			end = len(tasks)
+		}
+		return tasks[start:end]
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(hits, 1)
+		query := r.URL.Query()
+		switch r.URL.Path {
+		case "/link":
+			page, _ := strconv.Atoi(query.Get("page"))
+			if page*3+3 < len(tasks) {
+				w.Header().Add("Link", fmt.Sprintf(`</link?page=%d>; rel="next", </link?page=2>; rel="last"`, page+1))
+			}
+			_ = json.NewEncoder(w).Encode(window(page*3, 3))
+		case "/link-elsewhere":
+			w.Header().Add("Link", `<https://elsewhere.example.com/link?page=1>; rel="next"`)
+			_ = json.NewEncoder(w).Encode(window(0, 3))
+		case "/cursor":
+			start, _ := strconv.Atoi(query.Get("cursor"))
+			page := testCursorPage{Items: window(start, 3)}
+			if start+3 < len(tasks) {
+				page.NextCursor = strconv.Itoa(start + 3)
+			}
+			_ = json.NewEncoder(w).Encode(page)
+		case "/offset":
+			offset, _ := strconv.Atoi(query.Get("offset"))
+			limit, _ := strconv.Atoi(query.Get("limit"))
+			_ = json.NewEncoder(w).Encode(window(offset, limit))
+		case "/pages":
+			page, _ := strconv.Atoi(query.Get("page"))
+			_ = json.NewEncoder(w).Encode(window((page-1)*3, 3))
+		case "/zero-based-pages":
+			page, _ := strconv.Atoi(query.Get("page"))
+			_ = json.NewEncoder(w).Encode(window(page*3, 3))
+		case "/slow":
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestPagination(t *testing.T) {
+	var hits int64
+	ts := testPaginatedServer(&hits)
+	defer ts.Close()
+	newTasksPage := func() interface{} {
+		return &[]testRequestBody{}
+	}
+	collectItems := func(items *ItemIterator) []int {
+		var ids []int
+		for items.Next() {
+			ids = append(ids, items.Value().(testRequestBody).TestId)
+		}
+		return ids
+	}
+	allIds := []int{1, 2, 3, 4, 5, 6, 7}
+
+	Convey("TEST Link header pagination follows rel=next", t, func() {
+		pages := Paginate(context.Background(), RequestBuilder().RawUrl(ts.URL+"/link"), PaginationConfig{
+			Strategy: LinkPagination{},
+			NewPage:  newTasksPage,
+		})
+		var sizes []int
+		for pages.Next() {
+			sizes = append(sizes, len(*pages.Value().(*[]testRequestBody)))
+			So(pages.Page().Metadata.StatusCode, ShouldEqual, http.StatusOK)
+		}
+		So(pages.Err(), ShouldBeNil)
+		So(sizes, ShouldResemble, []int{3, 3, 1})
+	})
+
+	Convey("TEST Link header pagination does not follow next links to another origin", t, func() {
+		atomic.StoreInt64(&hits, 0)
+		pages := Paginate(context.Background(), RequestBuilder().RawUrl(ts.URL+"/link-elsewhere").
+			Auth(NewBearerTokenAuthenticator("token")), PaginationConfig{
+			Strategy: LinkPagination{},
+			NewPage:  newTasksPage,
+		})
+		So(pages.Next(), ShouldBeFalse)
+		So(pages.Err(), ShouldNotBeNil)
+		So(pages.Err().GetMessage(), ShouldContainSubstring, "another origin")
+		So(atomic.LoadInt64(&hits), ShouldEqual, 1)
+	})
+
+	Convey("TEST Cursor pagination sends the cursor extracted from each page", t, func() {
+		pages := Paginate(context.Background(), RequestBuilder().RawUrl(ts.URL+"/cursor"), PaginationConfig{
+			Strategy: CursorPagination{Param: "cursor", Cursor: func(page *Page) (string, error) {
+				return page.Value.(*testCursorPage).NextCursor, nil
+			}},
+			NewPage: func() interface{} {
+				return &testCursorPage{}
+			},
+			Items: func(page interface{}) interface{} {
+				return page.(*testCursorPage).Items
+			},
+		})
+		items := pages.Items()
+		So(collectItems(items), ShouldResemble, allIds)
+		So(items.Err(), ShouldBeNil)
+	})
+
+	Convey("TEST Offset pagination stops at a page with less than limit items", t, func() {
+		atomic.StoreInt64(&hits, 0)
+		pages := Paginate(context.Background(), RequestBuilder().RawUrl(ts.URL+"/offset"), PaginationConfig{
+			Strategy: OffsetPagination{OffsetParam: "offset", LimitParam: "limit", Limit: 2},
+			NewPage:  newTasksPage,
+		})
+		So(collectItems(pages.Items()), ShouldResemble, allIds)
+		So(atomic.LoadInt64(&hits), ShouldEqual, 4)
+	})
+
+	Convey("TEST Page number pagination stops at an empty page", t, func() {
+		atomic.StoreInt64(&hits, 0)
+		pages := Paginate(context.Background(), RequestBuilder().RawUrl(ts.URL+"/pages"), PaginationConfig{
+			Strategy: PageNumberPagination{Param: "page"},
+			NewPage:  newTasksPage,
+		})
+		So(collectItems(pages.Items()), ShouldResemble, allIds)
+		So(pages.Err(), ShouldBeNil)
+		So(atomic.LoadInt64(&hits), ShouldEqual, 4)
+	})
+
+	Convey("TEST Zero-based page number pagination starts from page 0", t, func() {
+		atomic.StoreInt64(&hits, 0)
+		pages := Paginate(context.Background(), RequestBuilder().RawUrl(ts.URL+"/zero-based-pages"), PaginationConfig{
+			Strategy: PageNumberPagination{Param: "page", ZeroBased: true, Size: 3},
+			NewPage:  newTasksPage,
+		})
+		So(collectItems(pages.Items()), ShouldResemble, allIds)
+		So(pages.Err(), ShouldBeNil)
+		So(atomic.LoadInt64(&hits), ShouldEqual, 3)
+	})
+
+	Convey("TEST Prefetch requests the next page while the current one is processed", t, func() {
+		atomic.StoreInt64(&hits, 0)
+		pages := Paginate(context.Background(), RequestBuilder().RawUrl(ts.URL+"/pages"), PaginationConfig{
+			Strategy: PageNumberPagination{Param: "page", Size: 3},
+			NewPage:  newTasksPage,
+			Prefetch: true,
+		})
+		So(pages.Next(), ShouldBeTrue)
+		time.Sleep(50 * time.Millisecond)
+		So(atomic.LoadInt64(&hits), ShouldEqual, 2)
+		So(collectItems(pages.Items()), ShouldResemble, allIds)
+		So(pages.Err(), ShouldBeNil)
+		So(atomic.LoadInt64(&hits), ShouldEqual, 3)
+	})
+
+	Convey("TEST Close stops the iteration", t, func() {
+		pages := Paginate(context.Background(), RequestBuilder().RawUrl(ts.URL+"/link"), PaginationConfig{
+			Strategy: LinkPagination{},
+			NewPage:  newTasksPage,
+			Prefetch: true,
+		})
+		So(pages.Next(), ShouldBeTrue)
+		pages.Close()
+		So(pages.Next(), ShouldBeFalse)
+		So(pages.Err(), ShouldBeNil)
+	})
+
+	Convey("TEST Pagination is cancelled along with its context", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		pages := Paginate(ctx, RequestBuilder().RawUrl(ts.URL+"/slow"), PaginationConfig{Strategy: LinkPagination{}})
+
+		So(pages.Next(), ShouldBeFalse)
+		So(pages.Err(), ShouldNotBeNil)
+		So(pages.Err().ConnectionError(), ShouldBeTrue)
+		So(pages.Err().GetUnderlyingError().Error(), ShouldContainSubstring, context.Canceled.Error())
+	})
+
+	Convey("TEST Errors stop the iteration", t, func() {
+		pages := Paginate(context.Background(), RequestBuilder().RawUrl(ts.URL+"/missing"), PaginationConfig{Strategy: LinkPagination{}})
+
+		So(pages.Next(), ShouldBeFalse)
+		So(pages.Err(), ShouldNotBeNil)
+		So(pages.Err().GetTopLevelError(), ShouldEqual, RecordNotFoundErr)
+	})
+}
+
+func TestLinkTarget(t *testing.T) {
+	Convey("TEST Link header targets are found by relation type", t, func() {
+		header := http.Header{"Link": []string{
+			`<https://example.com/items?page=1>; rel="prev first"`,
+			`<https://example.com/items?ids=1,2>; title="a, b; c"; rel="next"`,
+		}}
+		So(linkTarget(header, "first"), ShouldEqual, "https://example.com/items?page=1")
+		So(linkTarget(header, "next"), ShouldEqual, "https://example.com/items?ids=1,2")
+		So(linkTarget(header, "last"), ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,137 @@
+package restclient
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+/* RedirectMode decides what happens when a request is answered with a redirect */
+type RedirectMode int
+
+const (
+	RedirectFollow   RedirectMode = iota // Redirects are followed, the response of the last request is returned
+	RedirectDisabled                     // Redirects are not followed, a 3xx response fails the request with RedirectErr
+	RedirectReturn                       // Redirects are not followed, a 3xx response is returned without an error and is not decoded
+)
+
+/* RedirectPolicy configures how the redirects of a request are handled, a nil policy follows up to 10 redirects.
+- MaxRedirects: maximum number of redirects to follow, defaults to 10
+- SameHostOnly: only follow redirects to the host of the request, a redirect to another host fails with RedirectErr
+- ReplayBody: buffer a request body that cannot be re-read so that 307 and 308 redirects are followed with the same
+  method and body. Without it such redirects are only followed if the body can be re-read (e.g. BodyJson bodies)
+- SensitiveHeaders: headers dropped when a redirect goes to another origin (scheme, host and port), in addition to
+  Authorization, Proxy-Authorization, Cookie and the headers set by the Authenticator of the request, which are
+  always dropped. Cookies of a CookieJar are sent to the new origin according to the cookie rules */
+type RedirectPolicy struct {
+	Mode             RedirectMode
+	MaxRedirects     int
+	SameHostOnly     bool
+	ReplayBody       bool
+	SensitiveHeaders []string
+}
+
+/* RedirectHop is a redirect followed by a request */
+type RedirectHop struct {
+	StatusCode int      // status code of the redirect response
+	Method     string   // method of the request that was redirected
+	URL        *url.URL // URL of the request that was redirected
+	Location   *url.URL // URL the request was redirected to
+}
+
+/* crossOriginSensitiveHeaders are always dropped when a redirect goes to another origin */
+var crossOriginSensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+/* redirectPolicyError is the error returned by CheckRedirect once the policy refuses to follow a redirect */
+type redirectPolicyError struct {
+	statusCode int
+	err        error
+}
+
+func (e *redirectPolicyError) Error() string {
+	return e.err.Error()
+}
+
+/* checkRedirect returns the http.Client CheckRedirect function of rp (which may be nil). Headers in authHeaders and
+the sensitive headers are dropped when the redirect goes to another origin, router then authenticates the redirected
+request for its new host */
+func (rp *RedirectPolicy) checkRedirect(authHeaders *sync.Map, router *AuthRouter) func(*http.Request, []*http.Request) error {
+	policy := RedirectPolicy{}
+	if rp != nil {
+		policy = *rp
+	}
+	if policy.MaxRedirects <= 0 {
+		policy.MaxRedirects = maxRedirects
+	}
+	return func(redirected *http.Request, via []*http.Request) error {
+		statusCode := 0
+		if redirected.Response != nil {
+			statusCode = redirected.Response.StatusCode
+		}
+		if policy.Mode != RedirectFollow {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > policy.MaxRedirects {
+			return &redirectPolicyError{statusCode, errors.Errorf("stopped after %d redirects", policy.MaxRedirects)}
+		}
+		if policy.SameHostOnly && !strings.EqualFold(redirected.URL.Hostname(), via[0].URL.Hostname()) {
+			return &redirectPolicyError{statusCode, errors.Errorf("redirect to another host %s is not allowed", redirected.URL.Host)}
+		}
+		// http.Client copies the headers of the first request to each redirected one
+		if sameOrigin(redirected.URL, via[0].URL) {
+			return nil
+		}
+
+		for _, name := range append(crossOriginSensitiveHeaders, policy.SensitiveHeaders...) {
+			redirected.Header.Del(name)
+		}
+		authHeaders.Range(func(name, _ interface{}) bool {
+			redirected.Header.Del(name.(string))
+			return true
+		})
+		if router != nil {
+			return router.Apply(redirected)
+		}
+		return nil
+	}
+}
+
+/* sameOrigin reports whether a and b have the same scheme, host and port */
+func sameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Hostname(), b.Hostname()) && urlPort(a) == urlPort(b)
+}
+
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if strings.EqualFold(u.Scheme, "https") {
+		return "443"
+	}
+	return "80"
+}
+
+/* redirectChain returns the redirects followed to get response, in order */
+func redirectChain(response *http.Response) []RedirectHop {
+	var hops []RedirectHop
+	for req := response.Request; req != nil && req.Response != nil && req.Response.Request != nil; req = req.Response.Request {
+		hops = append([]RedirectHop{{
+			StatusCode: req.Response.StatusCode,
+			Method:     req.Response.Request.Method,
+			URL:        req.Response.Request.URL,
+			Location:   req.URL,
+		}}, hops...)
+	}
+	return hops
+}
+
+/* isRedirect reports whether response is a redirect to another location (304 Not Modified is not) */
+func isRedirect(response *http.Response) bool {
+	switch response.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package restclient
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRedirectPolicy(t *testing.T) {
+	var received []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, fmt.Sprintf("%s %s [%s] [%s] [%s]", r.Method, r.URL.Path,
+			r.Header.Get("Authorization"), r.Header.Get("Cookie"), r.Header.Get("X-Tenant")))
+		_ = json.NewEncoder(w).Encode(testHttpResponse{StatusCode: http.StatusOK, Data: testSuccess})
+	}))
+	defer other.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/hop/"):
+			n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hop/"))
+			if n > 0 {
+				http.Redirect(w, r, fmt.Sprintf("/hop/%d", n-1), http.StatusFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(testHttpResponse{StatusCode: http.StatusOK, Data: testSuccess})
+		case r.URL.Path == "/temporary":
+			http.Redirect(w, r, "/echo", http.StatusTemporaryRedirect)
+		case r.URL.Path == "/echo":
+			body, _ := ioutil.ReadAll(r.Body)
+			_, _ = w.Write([]byte(fmt.Sprintf(`{"statusCode": 200, "data": "%s %s"}`, r.Method, body)))
+		case r.URL.Path == "/other":
+			http.Redirect(w, r, strings.Replace(other.URL, "127.0.0.1", "localhost", 1)+"/landing", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+
+	doRequest := func(method, path string, body io.Reader, policy *RedirectPolicy) (testHttpResponse, ResponseMetadata, RequestError) {
+		var testResponse testHttpResponse
+		var metadata ResponseMetadata
+		req, reqErr := RequestBuilder().
+			RawUrl(ts.URL + path).
+			Header(&http.Header{"Authorization": []string{"Bearer token"}, "Cookie": []string{"session=abc"}, "X-Tenant": []string{"acme"}}).
+			Body(body).
+			Redirects(policy).
+			ResponseMetadata(&metadata).
+			ResponseReference(&testResponse).
+			Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+		if method == http.MethodPost {
+			return testResponse, metadata, req.Post()
+		}
+		return testResponse, metadata, req.Get()
+	}
+
+	Convey("TEST redirect chain is recorded in the response metadata", t, func() {
+		testResponse, metadata, reqErr := doRequest(http.MethodGet, "/hop/3", nil, nil)
+
+		Convey("Every hop should be recorded in order", func() {
+			So(reqErr, ShouldBeNil)
+			So(testResponse.Data, ShouldEqual, testSuccess)
+			So(metadata.StatusCode, ShouldEqual, http.StatusOK)
+			So(metadata.URL.Path, ShouldEqual, "/hop/0")
+			So(len(metadata.Redirects), ShouldEqual, 3)
+			So(metadata.Redirects[0].StatusCode, ShouldEqual, http.StatusFound)
+			So(metadata.Redirects[0].Method, ShouldEqual, http.MethodGet)
+			So(metadata.Redirects[0].URL.Path, ShouldEqual, "/hop/3")
+			So(metadata.Redirects[0].Location.Path, ShouldEqual, "/hop/2")
+			So(metadata.Redirects[2].Location.Path, ShouldEqual, "/hop/0")
+		})
+	})
+
+	Convey("TEST number of hops is capped", t, func() {
+		_, _, reqErr := doRequest(http.MethodGet, "/hop/3", nil, &RedirectPolicy{MaxRedirects: 2})
+		_, metadata, allowedErr := doRequest(http.MethodGet, "/hop/2", nil, &RedirectPolicy{MaxRedirects: 2})
+
+		Convey("Request should fail once the cap is exceeded", func() {
+			So(reqErr, ShouldNotBeNil)
+			So(reqErr.GetTopLevelError(), ShouldEqual, RedirectErr)
+			So(reqErr.GetStatusCode(), ShouldEqual, http.StatusFound)
+			So(reqErr.GetMessage(), ShouldContainSubstring, "stopped after 2 redirects")
+			So(allowedErr, ShouldBeNil)
+			So(len(metadata.Redirects), ShouldEqual, 2)
+		})
+	})
+
+	Convey("TEST disabled redirects and redirects returned as responses", t, func() {
+		_, _, disabledErr := doRequest(http.MethodGet, "/hop/1", nil, &RedirectPolicy{Mode: RedirectDisabled})
+		testResponse, metadata, returnErr := doRequest(http.MethodGet, "/hop/1", nil, &RedirectPolicy{Mode: RedirectReturn})
+
+		Convey("Disabled redirect should fail with RedirectErr", func() {
+			So(disabledErr, ShouldNotBeNil)
+			So(disabledErr.GetTopLevelError(), ShouldEqual, RedirectErr)
+			So(disabledErr.GetStatusCode(), ShouldEqual, http.StatusFound)
+			So(disabledErr.GetMessage(), ShouldContainSubstring, "/hop/0")
+		})
+
+		Convey("Returned redirect should be available in the metadata and not decoded", func() {
+			So(returnErr, ShouldBeNil)
+			So(testResponse, ShouldResemble, testHttpResponse{})
+			So(metadata.StatusCode, ShouldEqual, http.StatusFound)
+			So(metadata.Header.Get("Location"), ShouldEqual, "/hop/0")
+			So(metadata.Redirects, ShouldBeEmpty)
+		})
+	})
+
+	Convey("TEST 307 redirects keep the method and a body that cannot be re-read", t, func() {
+		body := func() io.Reader { return io.MultiReader(strings.NewReader("streamed body")) }
+		testResponse, _, reqErr := doRequest(http.MethodPost, "/temporary", body(), &RedirectPolicy{ReplayBody: true})
+		_, metadata, _ := doRequest(http.MethodPost, "/temporary", body(), nil)
+
+		Convey("Redirected request should be sent with the same method and body", func() {
+			So(reqErr, ShouldBeNil)
+			So(testResponse.Data, ShouldEqual, "POST streamed body")
+		})
+
+		Convey("Without ReplayBody the redirect should not be followed", func() {
+			So(metadata.StatusCode, ShouldEqual, http.StatusTemporaryRedirect)
+		})
+	})
+
+	Convey("TEST redirects to another host", t, func() {
+		received = nil
+		_, _, sameHostErr := doRequest(http.MethodGet, "/other", nil, &RedirectPolicy{SameHostOnly: true})
+		_, _, followErr := doRequest(http.MethodGet, "/other", nil, &RedirectPolicy{SensitiveHeaders: []string{"X-Tenant"}})
+		_, _, defaultErr := doRequest(http.MethodGet, "/other", nil, nil)
+
+		Convey("SameHostOnly should refuse to follow them", func() {
+			So(sameHostErr, ShouldNotBeNil)
+			So(sameHostErr.GetTopLevelError(), ShouldEqual, RedirectErr)
+		})
+
+		Convey("Authorization, cookies and sensitive headers should be dropped", func() {
+			So(followErr, ShouldBeNil)
+			So(defaultErr, ShouldBeNil)
+			So(received, ShouldResemble, []string{"GET /landing [] [] []", "GET /landing [] [] [acme]"})
+		})
+	})
+}
@@ -0,0 +1,80 @@
+package restclient
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+/* RefreshGroup de-duplicates concurrent refreshes of a RefreshableAuthenticator. Embed it in the Authenticator, so
+that the requests sharing the Authenticator share its refreshes. Without it every rejected request refreshes on its
+own. generation is the number of completed refreshes so that requests rejected with outdated credentials can skip
+refreshing again */
+type RefreshGroup struct {
+	mu         sync.Mutex
+	generation uint64
+	inFlight   *refreshCall
+}
+
+type refreshCall struct {
+	done chan struct{}
+	err  error
+}
+
+/* refreshGrouper is implemented by the Authenticators embedding a RefreshGroup */
+type refreshGrouper interface {
+	refreshGroup() *RefreshGroup
+}
+
+func (rs *RefreshGroup) refreshGroup() *RefreshGroup {
+	return rs
+}
+
+/* refreshGroupOf returns the RefreshGroup embedded in auth, or a fresh one if it has none */
+func refreshGroupOf(auth RefreshableAuthenticator) *RefreshGroup {
+	if grouper, ok := auth.(refreshGrouper); ok {
+		if group := grouper.refreshGroup(); group != nil {
+			return group
+		}
+	}
+	return &RefreshGroup{}
+}
+
+func (rs *RefreshGroup) currentGeneration() uint64 {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.generation
+}
+
+/* refresh calls auth.Refresh unless the credentials were already refreshed after appliedGeneration. Callers arriving
+while a refresh is in flight wait for it and share its result */
+func (rs *RefreshGroup) refresh(ctx context.Context, auth RefreshableAuthenticator, response *http.Response, appliedGeneration uint64) error {
+	rs.mu.Lock()
+	if rs.generation > appliedGeneration {
+		rs.mu.Unlock()
+		return nil
+	}
+	if call := rs.inFlight; call != nil {
+		rs.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	rs.inFlight = call
+	rs.mu.Unlock()
+
+	call.err = auth.Refresh(ctx, response)
+
+	rs.mu.Lock()
+	rs.inFlight = nil
+	if call.err == nil {
+		rs.generation++
+	}
+	rs.mu.Unlock()
+	close(call.done)
+	return call.err
+}
@@ -0,0 +1,120 @@
+package restclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshableAuthenticator(t *testing.T) {
+	var validToken atomic.Value
+	var received int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+		if r.Header.Get("Authorization") != "Bearer "+validToken.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	newCountingSource := func(fetches *int32) *CachingTokenSource {
+		return NewCachingTokenSource(func(ctx context.Context) (string, time.Time, error) {
+			n := atomic.AddInt32(fetches, 1)
+			return fmt.Sprintf("token-%d", n), time.Now().Add(time.Hour), nil
+		})
+	}
+	doGet := func(auth Authenticator) RequestError {
+		req, reqErr := RequestBuilder().
+			RawUrl(ts.URL).
+			Auth(auth).
+			Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+		return req.Get()
+	}
+
+	Convey("TEST concurrent requests with an expired token share a single refresh", t, func() {
+		validToken.Store("token-2")
+		atomic.StoreInt32(&received, 0)
+		var fetches int32
+		auth := NewRefreshableBearerAuthenticator(newCountingSource(&fetches))
+
+		var wg sync.WaitGroup
+		reqErrs := make([]RequestError, 10)
+		for i := range reqErrs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				reqErrs[i] = doGet(auth)
+			}(i)
+		}
+		wg.Wait()
+
+		Convey("All requests should succeed after the token is fetched once and refreshed once", func() {
+			for _, reqErr := range reqErrs {
+				So(reqErr, ShouldBeNil)
+			}
+			So(atomic.LoadInt32(&fetches), ShouldEqual, 2)
+			So(atomic.LoadInt32(&received), ShouldBeBetweenOrEqual, 10, 20)
+		})
+	})
+
+	Convey("TEST authenticators without a RefreshGroup refresh on their own", t, func() {
+		validToken.Store("token-2")
+		atomic.StoreInt32(&received, 0)
+		var fetches int32
+		reqErr := doGet(RefreshableBearerAuthenticator{Source: newCountingSource(&fetches)})
+
+		Convey("Request should succeed after a refresh", func() {
+			So(reqErr, ShouldBeNil)
+			So(atomic.LoadInt32(&received), ShouldEqual, 2)
+			So(atomic.LoadInt32(&fetches), ShouldEqual, 2)
+		})
+	})
+
+	Convey("TEST refreshed credentials that are still rejected are not retried again", t, func() {
+		validToken.Store("never-issued")
+		atomic.StoreInt32(&received, 0)
+		var fetches int32
+		reqErr := doGet(NewRefreshableBearerAuthenticator(newCountingSource(&fetches)))
+
+		Convey("Request should fail with UnauthorizedErr after a single retry", func() {
+			So(reqErr, ShouldNotBeNil)
+			So(reqErr.GetTopLevelError(), ShouldEqual, UnauthorizedErr)
+			So(atomic.LoadInt32(&received), ShouldEqual, 2)
+			So(atomic.LoadInt32(&fetches), ShouldEqual, 2)
+		})
+	})
+
+	Convey("TEST failing refresh is reported as UnauthorizedErr", t, func() {
+		validToken.Store("never-issued")
+		atomic.StoreInt32(&received, 0)
+		calls := 0
+		source := NewCachingTokenSource(func(ctx context.Context) (string, time.Time, error) {
+			calls++
+			if calls > 1 {
+				return "", time.Time{}, errors.New("identity provider is down")
+			}
+			return "token-1", time.Time{}, nil
+		})
+		reqErr := doGet(NewRefreshableBearerAuthenticator(source))
+
+		Convey("Refresh error should be wrapped and the request should not be retried", func() {
+			So(reqErr, ShouldNotBeNil)
+			So(reqErr.GetTopLevelError(), ShouldEqual, UnauthorizedErr)
+			So(reqErr.GetMessage(), ShouldContainSubstring, "identity provider is down")
+			So(atomic.LoadInt32(&received), ShouldEqual, 1)
+		})
+	})
+}
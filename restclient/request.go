@@ -1,6 +1,8 @@
 package restclient
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -9,19 +11,37 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 )
 
-const defaultTimeoutDuration = 60 * time.Second
+const (
+	defaultTimeoutDuration = 60 * time.Second
+	maxRedirects           = 10
+)
 
 /* HttpRequest is exported request object that contains all the necessary things to perform an HttpRequest,
 can be created using HttpRequestBuilder  */
 type HttpRequest struct {
-	request        *http.Request // internal http.Request object
-	auth           Authenticator // Custom Authentication Strategy to apply to the request
-	respReference  interface{}   // Object reference to map the response of the request
-	timeout        time.Duration // timeout value to be used for the request
-	loggingEnabled bool          // log the result of the request if loggingEnabled
+	request          *http.Request                   // internal http.Request object
+	auth             Authenticator                   // Custom Authentication Strategy to apply to the request
+	respReference    interface{}                     // Object reference to map the response of the request
+	timeout          time.Duration                   // timeout value to be used for the request
+	loggingEnabled   bool                            // log the result of the request if loggingEnabled
+	limiter          *ConcurrencyLimiter             // Optional limiter to cap the number of in-flight requests per destination
+	hedging          *HedgingPolicy                  // Optional policy to hedge idempotent requests against tail latency
+	verifier         ResponseVerifier                // Optional verifier to authenticate the response before it is decoded
+	jar              http.CookieJar                  // Optional jar to send cookies from and store the cookies of the response in
+	redirects        *RedirectPolicy                 // Optional policy deciding how redirects are followed
+	metadata         *ResponseMetadata               // Optional reference to fill with the metadata of the response
+	cache            *ResponseCache                  // Optional cache to serve GET requests from
+	etags            *ETagTracker                    // Optional tracker of the ETags to send as If-Match
+	group            *RequestGroup                   // Optional group to de-duplicate concurrent identical requests in
+	stream           *JSONStream                     // Optional stream to decode the response body record by record with
+	consume          func(resp *http.Response) error // Optional consumer of the response in place of decoding it e.g. of an EventSource
+	uploadProgress   ProgressFunc                    // Optional function to report the progress of sending the request body to
+	downloadProgress ProgressFunc                    // Optional function to report the progress of reading the response body to
+	bandwidth        *BandwidthLimiter               // Optional limiter to throttle the request and response bodies with
 }
 
 func newHttpClient(timeout time.Duration) *http.Client {
@@ -46,42 +66,54 @@ func (hr HttpRequest) YieldRequest() *http.Request {
 	return hr.request
 }
 
+/* Clone returns a deep copy of the HttpRequest whose underlying *http.Request can be sent independently of the
+original one. The request body is buffered in memory if it cannot be re-read otherwise */
+func (hr HttpRequest) Clone() (*HttpRequest, RequestError) {
+	cloned, err := cloneRequest(hr.request.Context(), hr.request)
+	if err != nil {
+		return nil, NewRequestBuildError(InvalidRequestErr, errors.Wrap(err, "Failed to clone request"))
+	}
+	hr.request = cloned
+	return &hr, nil
+}
+
 /* Get performs an HTTP GET request using the provided HttpRequest fields. Applies HttpRequest.auth directly to the resulting
 request on it (nil auth means no auth). Decodes any response into HttpRequest.respReference. Also uses HttpRequest.timeout value
 as the request timeout value, Zero (0) means no timeout. Returns a RequestError implying the result of the call */
 func (hr HttpRequest) Get() RequestError {
-	return doRequest(hr.request, http.MethodGet, hr.auth, hr.respReference, hr.loggingEnabled, hr.timeout)
+	return doRequest(hr, http.MethodGet)
 }
 
 /* Post performs an HTTP GET request using the provided HttpRequest fields. Applies HttpRequest.auth directly to the resulting
 request on it (nil auth means no auth). Decodes any response into HttpRequest.respReference. Also uses HttpRequest.timeout value
 as the request timeout value, Zero (0) means no timeout. Returns a RequestError implying the result of the call */
 func (hr HttpRequest) Post() RequestError {
-	return doRequest(hr.request, http.MethodPost, hr.auth, hr.respReference, hr.loggingEnabled, hr.timeout)
+	return doRequest(hr, http.MethodPost)
 }
 
 /* Put performs an HTTP GET request using the provided HttpRequest fields. Applies HttpRequest.auth directly to the resulting
 request on it (nil auth means no auth). Decodes any response into HttpRequest.respReference. Also uses HttpRequest.timeout value
 as the request timeout value, Zero (0) means no timeout. Returns a RequestError implying the result of the call */
 func (hr HttpRequest) Put() RequestError {
-	return doRequest(hr.request, http.MethodPut, hr.auth, hr.respReference, hr.loggingEnabled, hr.timeout)
+	return doRequest(hr, http.MethodPut)
 }
 
 /* Patch performs an HTTP GET request using the provided HttpRequest fields. Applies HttpRequest.auth directly to the resulting
 request on it (nil auth means no auth). Decodes any response into HttpRequest.respReference. Also uses HttpRequest.timeout value
 as the request timeout value, Zero (0) means no timeout. Returns a RequestError implying the result of the call */
 func (hr HttpRequest) Patch() RequestError {
-	return doRequest(hr.request, http.MethodPatch, hr.auth, hr.respReference, hr.loggingEnabled, hr.timeout)
+	return doRequest(hr, http.MethodPatch)
 }
 
 /* Delete performs an HTTP GET request using the provided HttpRequest fields. Applies HttpRequest.auth directly to the resulting
 request on it (nil auth means no auth). Decodes any response into HttpRequest.respReference. Also uses HttpRequest.timeout value
 as the request timeout value, Zero (0) means no timeout. Returns a RequestError implying the result of the call */
 func (hr HttpRequest) Delete() RequestError {
-	return doRequest(hr.request, http.MethodDelete, hr.auth, hr.respReference, hr.loggingEnabled, hr.timeout)
+	return doRequest(hr, http.MethodDelete)
 }
 
-func doRequest(req *http.Request, method string, auth Authenticator, respRef interface{}, loggingEnabled bool, timeout time.Duration) RequestError {
+func doRequest(hr HttpRequest, method string) (reqErr RequestError) {
+	req, auth, respRef, loggingEnabled, timeout := hr.request, hr.auth, hr.respReference, hr.loggingEnabled, hr.timeout
 
 	setHeaderIfNotSetAlready := func(key, value string) {
 		if req.Header.Get(key) == "" && value != "" {
@@ -90,6 +122,9 @@ func doRequest(req *http.Request, method string, auth Authenticator, respRef int
 	}
 
 	// Set universal headers
+	if hr.stream != nil && hr.stream.Format == StreamNDJSON {
+		setHeaderIfNotSetAlready("Accept", "application/x-ndjson")
+	}
 	setHeaderIfNotSetAlready("Accept", "application/json")
 	req.Method = method
 	switch method {
@@ -97,16 +132,122 @@ func doRequest(req *http.Request, method string, auth Authenticator, respRef int
 		setHeaderIfNotSetAlready("Content-Type", "application/json")
 	}
 
-	// Set Authorization header by applying specified authenticator's strategy if exists
+	// Send the remembered ETag of the resource with writes
+	if hr.etags != nil {
+		hr.etags.applyIfMatch(req)
+	}
+
+	// Pick the authenticator routed for the request URL, the routed one is used as if it was set on the request
+	router, _ := auth.(*AuthRouter)
+	auth, err := resolveAuthenticator(auth, req.URL)
+	if err != nil {
+		return NewRequestBuildError(InvalidRequestErr, errors.Wrap(err, "cannot route authentication information to request"))
+	}
+
+	// Remember the refresh generation of the credentials to be applied, so that a 401 can be answered with a refresh
+	refreshableAuth, isRefreshableAuth := auth.(RefreshableAuthenticator)
+	var refresher *RefreshGroup
+	var appliedGeneration uint64
+	if isRefreshableAuth {
+		refresher = refreshGroupOf(refreshableAuth)
+		appliedGeneration = refresher.currentGeneration()
+	}
+
+	// Set Authorization header by applying specified authenticator's strategy if exists, remember the headers it set
+	// so that they are not sent along if the request is redirected to another host
+	var authHeaders sync.Map
 	if auth != nil {
-		err := auth.Apply(req)
+		err := applyAuthenticator(auth, req, &authHeaders)
 		if err != nil {
 			return NewRequestBuildError(InvalidRequestErr, errors.Wrap(err, "cannot apply authentication information to request"))
 		}
 	}
 
+	// Challenge-response and refreshable authenticators may resend the request, make sure its body can be sent again.
+	// So do redirect policies that follow 307 and 308 redirects with the same body
+	challengeAuth, isChallengeAuth := auth.(ChallengeAuthenticator)
+	if isChallengeAuth || isRefreshableAuth || (hr.redirects != nil && hr.redirects.ReplayBody) {
+		if err := makeBodyReplayable(req); err != nil {
+			return NewRequestBuildError(InvalidRequestErr, errors.Wrap(err, "cannot prepare request body for authentication retries"))
+		}
+	}
+
+	// Report the progress of the request body and throttle it, retries and hedged requests send a fresh copy of it
+	defer trackRequestBody(req, hr.bandwidth, hr.uploadProgress)()
+
+	// Wait for a free slot if the request is concurrency limited, the slot is held until the response is consumed
+	if hr.limiter != nil {
+		permit, limitErr := hr.limiter.acquire(req, timeout)
+		if limitErr != nil {
+			if loggingEnabled {
+				errorLogger.Printf("Request could not be scheduled, [url]: %s, [err]: %v", req.URL.String(), limitErr)
+			}
+			return limitErr
+		}
+		defer func() {
+			permit.release(isOverloadSignal(reqErr))
+		}()
+	}
+
 	// Setup HttpClient
 	httpClient := newHttpClient(timeout)
+	// http.Client adds the cookies of its jar to the Cookie header of req, remember the header to resend req without them
+	var cookieHeader []string
+	if hr.jar != nil {
+		httpClient.Jar = hr.jar
+		cookieHeader = append(cookieHeader, req.Header.Values("Cookie")...)
+	}
+	httpClient.CheckRedirect = hr.redirects.checkRedirect(&authHeaders, router)
+	toConnectionError := func(err error) RequestError {
+		var policyErr *redirectPolicyError
+		if errors.As(err, &policyErr) {
+			return NewRequestError(RedirectErr, errors.Wrap(policyErr.err, "Redirect policy"), policyErr.statusCode)
+		}
+		if urlError, ok := err.(*url.Error); ok && urlError.Timeout() {
+			return NewRequestTimeoutError(HttpClientErr, errors.Wrap(err, "Connection Error, Request Timed out"))
+		}
+		return NewRequestConnectionError(HttpClientErr, errors.Wrap(err, "Connection Error"))
+	}
+
+	cancelHedging := func() {}
+	var cached cacheStatus
+	var shared bool
+	// Groups and caches read the whole body into memory, bodies that are streamed or consumed bypass them
+	bodyStreamed := hr.stream != nil || hr.consume != nil
+	sendRequest := func() (*http.Response, error) {
+		send := func() (*http.Response, error) {
+			if hr.hedging == nil || !isIdempotentMethod(method) {
+				return httpClient.Do(req)
+			}
+			resp, cancel, err := hr.hedging.do(req, hr.limiter, httpClient.Do)
+			cancelHedging = cancel
+			return resp, err
+		}
+		if hr.group != nil && !bodyStreamed {
+			sendOnce := send
+			send = func() (*http.Response, error) {
+				resp, isShared, err := hr.group.do(req, hr.group.key(req, credentialIdentity(req, auth, &authHeaders, hr.jar)), httpClient.Timeout, sendOnce)
+				shared = isShared
+				return resp, err
+			}
+		}
+		if hr.cache == nil || bodyStreamed {
+			return send()
+		}
+		resp, status, err := hr.cache.do(req, cacheSender{
+			send:             send,
+			sendInBackground: httpClient.Do,
+			requestError: func(resp *http.Response, err error) RequestError {
+				if err != nil {
+					return toConnectionError(err)
+				}
+				return prepareResponseError(resp)
+			},
+			identity: credentialIdentity(req, auth, &authHeaders, hr.jar),
+		})
+		cached = status
+		return resp, err
+	}
 	doRequestAndTimeIfEnabled := func() (*http.Response, int64, error) {
 		var err error
 		var duration int64
@@ -114,10 +255,10 @@ func doRequest(req *http.Request, method string, auth Authenticator, respRef int
 
 		if loggingEnabled {
 			startTime := time.Now()
-			resp, err = httpClient.Do(req)
+			resp, err = sendRequest()
 			duration = int64(time.Since(startTime) / time.Millisecond)
 		} else {
-			resp, err = httpClient.Do(req)
+			resp, err = sendRequest()
 		}
 		return resp, duration, err
 	}
@@ -133,17 +274,84 @@ func doRequest(req *http.Request, method string, auth Authenticator, respRef int
 		}
 	}
 
+	// resendWithNewCredentials discards the previous response, re-applies auth and sends the request once more
+	resendWithNewCredentials := func(previous *http.Response) (*http.Response, RequestError) {
+		_ = previous.Body.Close()
+		cancelHedging()
+		if hr.jar != nil {
+			req.Header.Del("Cookie")
+			for _, cookie := range cookieHeader {
+				req.Header.Add("Cookie", cookie)
+			}
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, NewRequestBuildError(InvalidRequestErr, errors.Wrap(err, "Failed to rewind request body for retry"))
+			}
+			req.Body = body
+		}
+		if err := applyAuthenticator(auth, req, &authHeaders); err != nil {
+			return nil, NewRequestBuildError(InvalidRequestErr, errors.Wrap(err, "cannot apply authentication information to request"))
+		}
+		resp, duration, err := doRequestAndTimeIfEnabled()
+		if err != nil {
+			logRequestIfEnabled(0, duration, err)
+			return nil, toConnectionError(err)
+		}
+		logRequestIfEnabled(resp.StatusCode, duration, nil)
+		return resp, nil
+	}
+
 	// Do Request (Time and Log it if enabled)
 	resp, duration, err := doRequestAndTimeIfEnabled()
+	defer func() {
+		cancelHedging()
+	}()
 	if err != nil {
 		logRequestIfEnabled(0, duration, err)
-		urlError := err.(*url.Error)
-		if urlError.Timeout() {
-			return NewRequestTimeoutError(HttpClientErr, errors.Wrap(err, "Connection Error, Request Timed out"))
-		}
-		return NewRequestConnectionError(HttpClientErr, errors.Wrap(err, "Connection Error"))
+		return toConnectionError(err)
 	}
 	logRequestIfEnabled(resp.StatusCode, duration, nil)
+
+	// Let challenge-response authenticators inspect the response, they can ask for a single retry
+	retried := false
+	if isChallengeAuth {
+		retry, challengeErr := challengeAuth.Challenge(req, resp)
+		if challengeErr != nil {
+			_ = resp.Body.Close()
+			return NewRequestBuildError(InvalidRequestErr, errors.Wrap(challengeErr, "cannot handle authentication challenge"))
+		}
+		if retry {
+			retried = true
+			var retryErr RequestError
+			if resp, retryErr = resendWithNewCredentials(resp); retryErr != nil {
+				return retryErr
+			}
+			// The retried response is only shown to the authenticator, a request is never retried twice
+			if _, challengeErr = challengeAuth.Challenge(req, resp); challengeErr != nil {
+				_ = resp.Body.Close()
+				return NewRequestBuildError(InvalidRequestErr, errors.Wrap(challengeErr, "cannot handle authentication challenge"))
+			}
+		}
+	}
+
+	// Refresh rejected credentials and retry once, a retried request is never refreshed again
+	detector, isRejectionDetector := auth.(RejectionDetector)
+	if isRefreshableAuth && !retried && (resp.StatusCode == http.StatusUnauthorized || (isRejectionDetector && detector.Rejected(resp))) {
+		if refreshErr := refresher.refresh(req.Context(), refreshableAuth, resp, appliedGeneration); refreshErr != nil {
+			_ = resp.Body.Close()
+			return NewRequestError(UnauthorizedErr, errors.Wrap(refreshErr, "Failed to refresh credentials"), http.StatusUnauthorized)
+		}
+		var retryErr RequestError
+		if resp, retryErr = resendWithNewCredentials(resp); retryErr != nil {
+			return retryErr
+		}
+	}
+	if isRejectionDetector && resp.StatusCode != http.StatusUnauthorized && detector.Rejected(resp) {
+		_ = resp.Body.Close()
+		return NewRequestError(UnauthorizedErr, errors.New("Credentials were rejected"), resp.StatusCode)
+	}
 	defer func() {
 		errBodyClose := resp.Body.Close()
 		if errBodyClose != nil {
@@ -156,13 +364,72 @@ func doRequest(req *http.Request, method string, auth Authenticator, respRef int
 		}
 	}()
 
+	if hr.metadata != nil {
+		*hr.metadata = ResponseMetadata{
+			StatusCode:  resp.StatusCode,
+			Header:      resp.Header,
+			URL:         resp.Request.URL,
+			ETag:        resp.Header.Get("ETag"),
+			Redirects:   redirectChain(resp),
+			FromCache:   cached.fromCache,
+			Revalidated: cached.revalidated,
+			Stale:       cached.stale,
+			Shared:      shared,
+			StaleErr:    cached.staleErr,
+		}
+	}
+
 	// Handle Response Status Code
-	reqErr := prepareResponseError(resp)
+	reqErr = prepareResponseError(resp)
 	if reqErr != nil {
 		return reqErr
 	}
+	if hr.redirects != nil && isRedirect(resp) {
+		switch hr.redirects.Mode {
+		case RedirectDisabled:
+			return NewRequestError(RedirectErr, errors.Errorf("Redirected to %s", resp.Header.Get("Location")), resp.StatusCode)
+		case RedirectReturn:
+			return nil
+		}
+	}
+
+	// Authenticate the response before it is decoded
+	if hr.verifier != nil {
+		if verifyErr := hr.verifier.Verify(req, resp); verifyErr != nil {
+			return NewRequestError(ResponseVerificationErr, errors.Wrap(verifyErr, "Failed to verify response"), resp.StatusCode)
+		}
+	}
+	if hr.etags != nil {
+		hr.etags.record(req, resp)
+	}
+
+	// Report the progress of the response body and throttle it unless it is served from the cache, the body of a
+	// protocol switch is the connection itself
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		bandwidth := hr.bandwidth
+		if cached.fromCache {
+			bandwidth = nil
+		}
+		resp.Body = newTransferReader(req.Context(), resp.Body, resp.ContentLength, bandwidth, hr.downloadProgress)
+	}
 
-	// Read the body into respRef
+	// Hand the body to its consumer, stream it record by record, or read it into respRef
+	if hr.consume != nil {
+		if err = hr.consume(resp); err != nil {
+			return NewRequestResponseParseError(InvalidResponseBodyErr, errors.Wrap(err, "Failed to consume response body"))
+		}
+		return nil
+	}
+	if hr.stream != nil {
+		err = hr.stream.decode(req.Context(), resp.Body)
+		if req.Context().Err() != nil {
+			return cancelledError(req.Context(), "Stream")
+		}
+		if err != nil {
+			return NewRequestResponseParseError(InvalidResponseBodyErr, errors.Wrap(err, "Failed to stream response body"))
+		}
+		return nil
+	}
 	if respRef != nil {
 		err = unmarshalResponseBody(resp, respRef)
 		if err != nil {
@@ -173,6 +440,32 @@ func doRequest(req *http.Request, method string, auth Authenticator, respRef int
 	return nil
 }
 
+/* applyAuthenticator applies auth to req and adds the names of the headers it set or changed to applied */
+func applyAuthenticator(auth Authenticator, req *http.Request, applied *sync.Map) error {
+	before := req.Header.Clone()
+	if err := auth.Apply(req); err != nil {
+		return err
+	}
+	for name, values := range req.Header {
+		if !equalHeaderValues(before[name], values) {
+			applied.Store(name, true)
+		}
+	}
+	return nil
+}
+
+func equalHeaderValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func prepareResponseError(response *http.Response) RequestError {
 	if response.StatusCode < 400 {
 		return nil
@@ -196,6 +489,8 @@ func prepareResponseError(response *http.Response) RequestError {
 		topLevelErr = TooManyRequestErr
 	case http.StatusUnprocessableEntity:
 		topLevelErr = UnprocessableEntityErr
+	case http.StatusPreconditionFailed:
+		topLevelErr = PreconditionFailedErr
 	case http.StatusInternalServerError:
 		topLevelErr = InternalServerErr
 	case http.StatusServiceUnavailable:
@@ -206,6 +501,57 @@ func prepareResponseError(response *http.Response) RequestError {
 	return NewRequestError(topLevelErr, errors.New(responseMessage), response.StatusCode)
 }
 
+/* cloneRequest returns a copy of req bound to ctx with a fresh body. If the body of req cannot be re-read, it is
+buffered in memory and req.GetBody is set so that both req and its clones can be sent */
+func cloneRequest(ctx context.Context, req *http.Request) (*http.Request, error) {
+	if err := makeBodyReplayable(req); err != nil {
+		return nil, err
+	}
+
+	cloned := req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to get a fresh copy of request body")
+		}
+		cloned.Body = body
+	}
+	return cloned, nil
+}
+
+/* makeBodyReplayable buffers the body of req in memory and sets req.GetBody unless it can already be re-read */
+func makeBodyReplayable(req *http.Request) error {
+	if req.GetBody != nil || req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	buffered, err := readerToByte(req.Body)
+	if err != nil {
+		return errors.Wrap(err, "Failed to buffer request body")
+	}
+	_ = req.Body.Close()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buffered)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return nil
+}
+
+/* peekRequestBody returns the content of the request body without consuming the body that is going to be sent */
+func peekRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if err := makeBodyReplayable(req); err != nil {
+		return nil, err
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get a fresh copy of request body")
+	}
+	defer body.Close()
+	return readerToByte(body)
+}
+
 func getFailedResponseBody(response *http.Response) (string, error) {
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
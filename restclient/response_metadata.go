@@ -0,0 +1,21 @@
+package restclient
+
+import (
+	"net/http"
+	"net/url"
+)
+
+/* ResponseMetadata describes the response of a request, set it with HttpRequestBuilder.ResponseMetadata to have it
+filled once a response is received, error responses included */
+type ResponseMetadata struct {
+	StatusCode  int
+	Header      http.Header
+	ETag        string        // ETag of the response, see ETagTracker for optimistic concurrency control
+	URL         *url.URL      // URL of the request that received the response, after redirects
+	Redirects   []RedirectHop // redirects followed in order, empty if the request was not redirected
+	FromCache   bool          // response was served by the ResponseCache of the request
+	Revalidated bool          // response was served by the ResponseCache after the server confirmed it is unchanged
+	Stale       bool          // response was served by the ResponseCache although it is stale, see ResponseCache
+	StaleErr    RequestError  // error of the request a stale response was served in place of, nil if it was not
+	Shared      bool          // response of an identical request in flight was shared, see RequestGroup
+}
@@ -0,0 +1,213 @@
+package restclient
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/* ResponseVerifier authenticates the responses of a request before they are decoded into the ResponseReference.
+ * Implement this interface to check response signatures, responses failing Verify end with ResponseVerificationErr */
+type ResponseVerifier interface {
+	/* Verify is called with every successful response of the request it is set on, the response body can be read
+	 * as long as it is replaced with a body that yields the same content */
+	Verify(request *http.Request, response *http.Response) error
+}
+
+/* MessageSignatureVerifier verifies HTTP Message Signatures (RFC 9421) of responses, and of requests on the server side.
+- Label and KeyID: the signature to verify is the first one in Signature-Input whose label and keyid match the ones
+  that are set. The alg parameter must match Algorithm if it is present
+- PublicKey: ed25519.PublicKey, *ecdsa.PublicKey or *rsa.PublicKey verifying the signatures, Secret for hmac-sha256
+- RequiredComponents: components that must be covered by the signature e.g. "@status" or "content-digest"
+- MaxAge: maximum age of the signature by its created parameter, zero means no limit. Expired signatures are always rejected
+- Now: used to check the signature age, defaults to time.Now
+If "content-digest" is covered, the body is read and checked against the Content-Digest header (sha-256 or sha-512),
+otherwise the body is not authenticated by the signature */
+type MessageSignatureVerifier struct {
+	Label              string
+	KeyID              string
+	Algorithm          MessageSignatureAlgorithm
+	PublicKey          crypto.PublicKey
+	Secret             []byte
+	RequiredComponents []string
+	MaxAge             time.Duration
+	Now                func() time.Time
+}
+
+func NewMessageSignatureVerifier(keyID string, algorithm MessageSignatureAlgorithm, publicKey crypto.PublicKey) *MessageSignatureVerifier {
+	return &MessageSignatureVerifier{
+		KeyID:     keyID,
+		Algorithm: algorithm,
+		PublicKey: publicKey,
+	}
+}
+
+func NewHMACMessageSignatureVerifier(keyID string, secret []byte) *MessageSignatureVerifier {
+	return &MessageSignatureVerifier{
+		KeyID:     keyID,
+		Algorithm: MessageSignatureHMACSHA256,
+		Secret:    secret,
+	}
+}
+
+/* Verify verifies the signature of response, components with the req parameter are taken from request */
+func (mv MessageSignatureVerifier) Verify(request *http.Request, response *http.Response) error {
+	return mv.verify(request, response)
+}
+
+/* VerifyRequest verifies the signature of an incoming request e.g. in a server handler */
+func (mv MessageSignatureVerifier) VerifyRequest(request *http.Request) error {
+	return mv.verify(request, nil)
+}
+
+func (mv MessageSignatureVerifier) verify(request *http.Request, response *http.Response) error {
+	header, body := request.Header, &request.Body
+	if response != nil {
+		header, body = response.Header, &response.Body
+	}
+
+	inputs, err := parseSFDictionary(strings.Join(header.Values("Signature-Input"), ", "))
+	if err != nil {
+		return errors.Wrap(err, "Invalid Signature-Input header")
+	}
+	signatures, err := parseSFDictionary(strings.Join(header.Values("Signature"), ", "))
+	if err != nil {
+		return errors.Wrap(err, "Invalid Signature header")
+	}
+
+	var input *sfMember
+	for i, candidate := range inputs {
+		keyID, _ := candidate.params.get("keyid")
+		if candidate.isInnerList && (mv.Label == "" || candidate.name == mv.Label) && (mv.KeyID == "" || keyID == mv.KeyID) {
+			input = &inputs[i]
+			break
+		}
+	}
+	if input == nil {
+		return errors.Errorf("No message signature found with label %q and keyid %q", mv.Label, mv.KeyID)
+	}
+	var signature []byte
+	for _, candidate := range signatures {
+		if candidate.name == input.name {
+			signature, _ = candidate.item.value.([]byte)
+		}
+	}
+	if signature == nil {
+		return errors.Errorf("Signature header has no signature labeled %q", input.name)
+	}
+	if alg, ok := input.params.get("alg"); ok && alg != string(mv.Algorithm) {
+		return errors.Errorf("Message signature algorithm %v does not match %s", alg, mv.Algorithm)
+	}
+
+	if err = mv.checkCoverage(input.innerList); err != nil {
+		return err
+	}
+	if err = mv.checkAge(input.params); err != nil {
+		return err
+	}
+
+	base, err := signatureBase(input.innerList, input.params, request, response)
+	if err != nil {
+		return err
+	}
+	if err = verifyMessage(mv.Algorithm, mv.PublicKey, mv.Secret, base, signature); err != nil {
+		return err
+	}
+
+	for _, component := range input.innerList {
+		if _, fromRequest := component.params.get("req"); component.value == "content-digest" && !fromRequest {
+			return verifyContentDigest(header, body)
+		}
+	}
+	return nil
+}
+
+func (mv MessageSignatureVerifier) checkCoverage(covered []sfItem) error {
+	required, err := parseSignatureComponents(mv.RequiredComponents)
+	if err != nil {
+		return err
+	}
+	for _, r := range required {
+		found := false
+		for _, c := range covered {
+			found = found || serializeSFItem(c) == serializeSFItem(r)
+		}
+		if !found {
+			return errors.Errorf("Required component %s is not covered by the message signature", serializeSFItem(r))
+		}
+	}
+	return nil
+}
+
+func (mv MessageSignatureVerifier) checkAge(params sfParams) error {
+	now := time.Now()
+	if mv.Now != nil {
+		now = mv.Now()
+	}
+	if expires, ok := params.get("expires"); ok {
+		if unix, isInt := expires.(int64); !isInt || now.Unix() > unix {
+			return errors.New("Message signature is expired")
+		}
+	}
+	if mv.MaxAge > 0 {
+		created, ok := params.get("created")
+		unix, isInt := created.(int64)
+		if !ok || !isInt {
+			return errors.New("Message signature has no created parameter to check its age")
+		}
+		if now.Sub(time.Unix(unix, 0)) > mv.MaxAge {
+			return errors.Errorf("Message signature is older than %v", mv.MaxAge)
+		}
+	}
+	return nil
+}
+
+/* verifyContentDigest checks body against the Content-Digest header, the body is buffered and replaced so that it
+can still be read. At least one of the digests must use a supported algorithm, all supported ones must match */
+func verifyContentDigest(header http.Header, body *io.ReadCloser) error {
+	digests, err := parseSFDictionary(strings.Join(header.Values("Content-Digest"), ", "))
+	if err != nil {
+		return errors.Wrap(err, "Invalid Content-Digest header")
+	}
+
+	var content []byte
+	if *body != nil && *body != http.NoBody {
+		if content, err = readerToByte(*body); err != nil {
+			return errors.Wrap(err, "Failed to read body to verify content digest")
+		}
+		_ = (*body).Close()
+		*body = ioutil.NopCloser(bytes.NewReader(content))
+	}
+
+	verified := false
+	for _, digest := range digests {
+		var sum []byte
+		switch digest.name {
+		case "sha-256":
+			s := sha256.Sum256(content)
+			sum = s[:]
+		case "sha-512":
+			s := sha512.Sum512(content)
+			sum = s[:]
+		default:
+			continue
+		}
+		expected, _ := digest.item.value.([]byte)
+		if subtle.ConstantTimeCompare(sum, expected) != 1 {
+			return errors.Errorf("Content-Digest %s does not match the body", digest.name)
+		}
+		verified = true
+	}
+	if !verified {
+		return errors.New("Content-Digest header has no sha-256 or sha-512 digest")
+	}
+	return nil
+}
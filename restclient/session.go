@@ -0,0 +1,33 @@
+package restclient
+
+import (
+	"net/http"
+)
+
+/* Session shares a cookie jar between the requests built by its RequestBuilder, so that cookies set by a response
+(e.g. a login) are sent with the following requests according to their domain, path and expiry */
+type Session struct {
+	Jar       http.CookieJar
+	Bandwidth *BandwidthLimiter // Optional limiter to throttle the transfers of all the requests of the session together
+}
+
+/* NewSession creates a Session with an in-memory CookieJar. The jar has no public suffix list, so a site can set
+cookies for its public suffix (Domain=co.uk) which are then sent to unrelated sites. Use NewSessionWithJar with a
+NewCookieJarWithOptions jar and a PublicSuffixList if the session visits sites that are not trusted */
+func NewSession() *Session {
+	return &Session{
+		Jar: NewCookieJar(),
+	}
+}
+
+/* NewSessionWithJar creates a Session with the given jar e.g. a CookieJar created by NewFileCookieJar */
+func NewSessionWithJar(jar http.CookieJar) *Session {
+	return &Session{
+		Jar: jar,
+	}
+}
+
+/* RequestBuilder returns a HttpRequestBuilder whose requests use the cookie jar and the bandwidth limiter of the session */
+func (s *Session) RequestBuilder() HttpRequestBuilder {
+	return RequestBuilder().CookieJar(s.Jar).BandwidthLimit(s.Bandwidth)
+}
@@ -0,0 +1,175 @@
+package restclient
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const defaultCSRFHeader = "X-CSRF-Token"
+
+/* SessionAuthenticator authenticates requests with the session cookie of a form login. It logs in lazily by POSTing
+Form to LoginURL, the session cookie is kept in the jar of Session, so the requests must be built with its
+RequestBuilder. A session is considered expired when a request is answered with 401 or redirected to the login page,
+it logs in again and the request is retried once, see RefreshableAuthenticator.
+- SessionCookie: if set, the login fails unless it sets this cookie. Without it any non-error response is a success
+- CSRFCookie and CSRFResponseHeader: the CSRF token is taken from this cookie (for the request URL) or from this
+  header of the login response, and is sent in CSRFHeader (defaults to X-CSRF-Token) with POST, PUT, PATCH and DELETE
+  requests
+- PrefetchLoginPage: GET the login page before logging in, for servers that set the CSRF cookie on the login page and
+  require it to log in */
+type SessionAuthenticator struct {
+	LoginURL                       string
+	Form                           url.Values
+	Session                        *Session
+	SessionCookie                  string
+	CSRFCookie, CSRFResponseHeader string
+	CSRFHeader                     string
+	PrefetchLoginPage              bool
+
+	mu        sync.Mutex
+	loggedIn  bool
+	csrfToken string // CSRF token received in CSRFResponseHeader
+	RefreshGroup
+}
+
+/* NewSessionAuthenticator creates a SessionAuthenticator with a new in-memory Session */
+func NewSessionAuthenticator(loginURL string, form url.Values) *SessionAuthenticator {
+	return &SessionAuthenticator{
+		LoginURL: loginURL,
+		Form:     form,
+		Session:  NewSession(),
+	}
+}
+
+/* RequestBuilder returns a HttpRequestBuilder whose requests use the session and are authenticated by sa */
+func (sa *SessionAuthenticator) RequestBuilder() HttpRequestBuilder {
+	return sa.Session.RequestBuilder().Auth(sa)
+}
+
+/* Apply logs in if there is no session yet and sets the CSRF header of state-changing requests */
+func (sa *SessionAuthenticator) Apply(request *http.Request) error {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	if !sa.loggedIn {
+		if err := sa.loginLocked(request.Context()); err != nil {
+			return err
+		}
+	}
+	switch request.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		if token := sa.csrfTokenLocked(request.URL); token != "" {
+			request.Header.Set(sa.csrfHeader(), token)
+		}
+	}
+	return nil
+}
+
+/* Refresh logs in again once the session is rejected */
+func (sa *SessionAuthenticator) Refresh(ctx context.Context, response *http.Response) error {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	sa.loggedIn = false
+	return sa.loginLocked(ctx)
+}
+
+/* Rejected reports whether the response is a redirect to the login page, followed or not */
+func (sa *SessionAuthenticator) Rejected(response *http.Response) bool {
+	loginURL, err := url.Parse(sa.LoginURL)
+	if err != nil {
+		return false
+	}
+	isLoginPage := func(u *url.URL) bool {
+		return u != nil && strings.EqualFold(u.Host, loginURL.Host) && u.Path == loginURL.Path
+	}
+	if response.StatusCode >= 300 && response.StatusCode < 400 {
+		location, err := response.Location()
+		return err == nil && isLoginPage(location)
+	}
+	return response.Request != nil && isLoginPage(response.Request.URL)
+}
+
+func (sa *SessionAuthenticator) loginLocked(ctx context.Context) error {
+	if sa.Session == nil || sa.Session.Jar == nil {
+		return errors.New("SessionAuthenticator requires a Session with a cookie jar")
+	}
+	loginURL, err := url.Parse(sa.LoginURL)
+	if err != nil {
+		return errors.Wrap(err, "Invalid login URL")
+	}
+
+	if sa.PrefetchLoginPage {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, sa.LoginURL, nil)
+		if err != nil {
+			return errors.Wrap(err, "Failed to construct login page request")
+		}
+		hr, reqErr := sa.Session.RequestBuilder().
+			Request(req).
+			Header(&http.Header{"Accept": []string{"text/html"}}).
+			Build()
+		if reqErr == nil {
+			reqErr = hr.Get()
+		}
+		if reqErr != nil {
+			return errors.Wrap(reqErr, "Failed to get login page")
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sa.LoginURL, strings.NewReader(sa.Form.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "Failed to construct login request")
+	}
+	header := http.Header{"Content-Type": []string{"application/x-www-form-urlencoded"}, "Accept": []string{"*/*"}}
+	if token := sa.csrfTokenLocked(loginURL); token != "" {
+		header.Set(sa.csrfHeader(), token)
+	}
+	var loginResponse ResponseMetadata
+	hr, reqErr := sa.Session.RequestBuilder().
+		Request(req).
+		Header(&header).
+		ResponseMetadata(&loginResponse).
+		Build()
+	if reqErr == nil {
+		reqErr = hr.Post()
+	}
+	if reqErr != nil {
+		return errors.Wrap(reqErr, "Login failed")
+	}
+
+	if sa.SessionCookie != "" && !hasCookie(sa.Session.Jar.Cookies(loginURL), sa.SessionCookie) {
+		return errors.Errorf("Login failed, session cookie %q was not set", sa.SessionCookie)
+	}
+	if sa.CSRFResponseHeader != "" && loginResponse.Header != nil {
+		sa.csrfToken = loginResponse.Header.Get(sa.CSRFResponseHeader)
+	}
+	sa.loggedIn = true
+	return nil
+}
+
+/* csrfTokenLocked returns the CSRF token to send to u, the CSRF cookie takes precedence over the login response header */
+func (sa *SessionAuthenticator) csrfTokenLocked(u *url.URL) string {
+	if sa.CSRFCookie != "" {
+		for _, cookie := range sa.Session.Jar.Cookies(u) {
+			if cookie.Name == sa.CSRFCookie {
+				return cookie.Value
+			}
+		}
+	}
+	return sa.csrfToken
+}
+
+func (sa *SessionAuthenticator) csrfHeader() string {
+	return headerOrDefault(sa.CSRFHeader, defaultCSRFHeader)
+}
+
+func hasCookie(cookies []*http.Cookie, name string) bool {
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return true
+		}
+	}
+	return false
+}
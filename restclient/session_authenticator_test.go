@@ -0,0 +1,148 @@
+package restclient
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+/* testFormLoginServer is an admin tool with a form login, a CSRF cookie set on the login page and session cookies */
+type testFormLoginServer struct {
+	mu       sync.Mutex
+	session  string
+	logins   int
+	received []string
+}
+
+func (s *testFormLoginServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	csrfValid := func() bool {
+		cookie, err := r.Cookie("csrftoken")
+		return err == nil && r.Header.Get("X-CSRFToken") == cookie.Value
+	}
+	sessionValid := func() bool {
+		cookie, err := r.Cookie("sessionid")
+		return err == nil && s.session != "" && cookie.Value == s.session
+	}
+
+	switch r.URL.Path {
+	case "/login":
+		if r.Method == http.MethodGet {
+			http.SetCookie(w, &http.Cookie{Name: "csrftoken", Value: "csrf-1", Path: "/"})
+			_, _ = w.Write([]byte("<form>login</form>"))
+			return
+		}
+		_ = r.ParseForm()
+		if !csrfValid() || r.PostForm.Get("username") != "admin" || r.PostForm.Get("password") != "0123" {
+			_, _ = w.Write([]byte("<form>wrong credentials</form>"))
+			return
+		}
+		s.logins++
+		s.session = fmt.Sprintf("session-%d", s.logins)
+		http.SetCookie(w, &http.Cookie{Name: "sessionid", Value: s.session, Path: "/", HttpOnly: true})
+		http.Redirect(w, r, "/home", http.StatusFound)
+	case "/home":
+		_, _ = w.Write([]byte("<p>home</p>"))
+	case "/api/strict":
+		if !sessionValid() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.received = append(s.received, r.Method+" "+r.URL.Path)
+		_ = json.NewEncoder(w).Encode(testHttpResponse{StatusCode: http.StatusOK, Data: testSuccess})
+	default:
+		if !sessionValid() {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.Path), http.StatusFound)
+			return
+		}
+		if r.Method != http.MethodGet && !csrfValid() {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		s.received = append(s.received, r.Method+" "+r.URL.Path)
+		_ = json.NewEncoder(w).Encode(testHttpResponse{StatusCode: http.StatusOK, Data: testSuccess})
+	}
+}
+
+func TestSessionAuthenticator(t *testing.T) {
+	server := &testFormLoginServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	newAuth := func(password string) *SessionAuthenticator {
+		auth := NewSessionAuthenticator(ts.URL+"/login", url.Values{"username": {"admin"}, "password": {password}})
+		auth.SessionCookie = "sessionid"
+		auth.CSRFCookie, auth.CSRFHeader = "csrftoken", "X-CSRFToken"
+		auth.PrefetchLoginPage = true
+		return auth
+	}
+	auth := newAuth("0123")
+	doRequest := func(auth *SessionAuthenticator, method, path string) (testHttpResponse, RequestError) {
+		var testResponse testHttpResponse
+		builder := auth.RequestBuilder().RawUrl(ts.URL + path).ResponseReference(&testResponse)
+		if method != http.MethodGet {
+			builder = builder.BodyJson(testRequestBody{TestId: 123, TestName: "Testing Request Body"})
+		}
+		req, reqErr := builder.Build()
+		if reqErr != nil {
+			log.Fatalf("failed to construct testRequest, %v", reqErr)
+		}
+		if method == http.MethodGet {
+			return testResponse, req.Get()
+		}
+		return testResponse, req.Post()
+	}
+
+	Convey("TEST session authenticator logs in lazily and sends the CSRF token with state-changing requests", t, func() {
+		getResponse, getErr := doRequest(auth, http.MethodGet, "/api/tasks")
+		_, postErr := doRequest(auth, http.MethodPost, "/api/tasks")
+
+		Convey("Login should happen once and both requests should succeed", func() {
+			So(getErr, ShouldBeNil)
+			So(getResponse.Data, ShouldEqual, testSuccess)
+			So(postErr, ShouldBeNil)
+			So(server.logins, ShouldEqual, 1)
+			So(server.received, ShouldResemble, []string{"GET /api/tasks", "POST /api/tasks"})
+		})
+	})
+
+	Convey("TEST expired session is detected from a redirect to the login page", t, func() {
+		server.session, server.received = "", nil
+		testResponse, reqErr := doRequest(auth, http.MethodPost, "/api/tasks")
+
+		Convey("Session authenticator should log in again and retry the request", func() {
+			So(reqErr, ShouldBeNil)
+			So(testResponse.Data, ShouldEqual, testSuccess)
+			So(server.logins, ShouldEqual, 2)
+			So(server.received, ShouldResemble, []string{"POST /api/tasks"})
+		})
+	})
+
+	Convey("TEST expired session is detected from a 401", t, func() {
+		server.session, server.received = "", nil
+		_, reqErr := doRequest(auth, http.MethodGet, "/api/strict")
+
+		Convey("Session authenticator should log in again and retry the request", func() {
+			So(reqErr, ShouldBeNil)
+			So(server.logins, ShouldEqual, 3)
+			So(server.received, ShouldResemble, []string{"GET /api/strict"})
+		})
+	})
+
+	Convey("TEST wrong credentials fail the login", t, func() {
+		_, reqErr := doRequest(newAuth("WRONG"), http.MethodGet, "/api/tasks")
+
+		Convey("Request should not be sent", func() {
+			So(reqErr, ShouldNotBeNil)
+			So(reqErr.RequestBuildError(), ShouldBeTrue)
+			So(reqErr.GetMessage(), ShouldContainSubstring, "sessionid")
+		})
+	})
+}
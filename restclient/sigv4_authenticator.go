@@ -0,0 +1,269 @@
+package restclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm       = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat      = "20060102T150405Z"
+	sigV4DateFormat      = "20060102"
+	sigV4UnsignedPayload = "UNSIGNED-PAYLOAD"
+	sigV4MaxPresignAge   = 7 * 24 * time.Hour
+)
+
+/* sigV4IgnoredHeaders are never signed since they may be altered by proxies or the http.Client itself */
+var sigV4IgnoredHeaders = map[string]bool{
+	"authorization":   true,
+	"user-agent":      true,
+	"x-amzn-trace-id": true,
+	"expect":          true,
+	"connection":      true,
+}
+
+/* SigV4Credentials are the AWS credentials used by SigV4Authenticator. SessionToken is only set for temporary credentials */
+type SigV4Credentials struct {
+	AccessKeyID, SecretAccessKey, SessionToken string
+}
+
+/* SigV4Authenticator signs requests with AWS Signature Version 4 (https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html).
+- Region and Service are used in the credential scope e.g. "us-east-1" and "s3"
+- UnsignedPayload skips hashing the body and signs the "UNSIGNED-PAYLOAD" literal instead (S3 only)
+- Now is used to get the signing time, defaults to time.Now
+The X-Amz-Content-Sha256 header is always set for the "s3" service, as S3 requires it */
+type SigV4Authenticator struct {
+	Credentials     SigV4Credentials
+	Region, Service string
+	UnsignedPayload bool
+	Now             func() time.Time
+}
+
+func NewSigV4Authenticator(credentials SigV4Credentials, region, service string) Authenticator {
+	return &SigV4Authenticator{
+		Credentials: credentials,
+		Region:      region,
+		Service:     service,
+	}
+}
+
+/* Identity is made of the access key ID and the fingerprint of the secret key, the signature headers change with every
+request */
+func (sa SigV4Authenticator) Identity() string {
+	return "sigv4 " + sa.Credentials.AccessKeyID + " " + fingerprint([]byte(sa.Credentials.SecretAccessKey))
+}
+
+/* Apply signs the request by setting the X-Amz-Date, X-Amz-Security-Token (for temporary credentials) and
+Authorization headers. It must be the last step that modifies the request since any header set afterwards is not signed */
+func (sa SigV4Authenticator) Apply(request *http.Request) error {
+	signTime := sa.now()
+	request.Header.Set("X-Amz-Date", signTime.Format(sigV4TimeFormat))
+	if sa.Credentials.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", sa.Credentials.SessionToken)
+	}
+
+	payloadHash, err := sa.payloadHash(request)
+	if err != nil {
+		return err
+	}
+	if sa.Service == "s3" || sa.UnsignedPayload {
+		request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	signedHeaders, canonicalHeaders := sigV4CanonicalHeaders(request)
+	canonicalRequest := sa.canonicalRequest(request, request.URL.Query(), canonicalHeaders, signedHeaders, payloadHash)
+	signature := sa.sign(signTime, canonicalRequest)
+
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, sa.Credentials.AccessKeyID, sa.credentialScope(signTime), signedHeaders, signature))
+	return nil
+}
+
+/* Presign returns a presigned URL for the request that is valid for expires (at most 7 days). The headers of the
+request that are set at the time of presigning are signed as well, so they must be sent along with the URL */
+func (sa SigV4Authenticator) Presign(request *http.Request, expires time.Duration) (*url.URL, error) {
+	if expires <= 0 || expires > sigV4MaxPresignAge {
+		return nil, errors.Errorf("Presigned URL expiry must be in (0, %v], got %v", sigV4MaxPresignAge, expires)
+	}
+	signTime := sa.now()
+	signedHeaders, canonicalHeaders := sigV4CanonicalHeaders(request)
+
+	query := request.URL.Query()
+	query.Set("X-Amz-Algorithm", sigV4Algorithm)
+	query.Set("X-Amz-Credential", sa.Credentials.AccessKeyID+"/"+sa.credentialScope(signTime))
+	query.Set("X-Amz-Date", signTime.Format(sigV4TimeFormat))
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	query.Set("X-Amz-SignedHeaders", signedHeaders)
+	if sa.Credentials.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", sa.Credentials.SessionToken)
+	}
+
+	payloadHash := sigV4UnsignedPayload
+	if sa.Service != "s3" && !sa.UnsignedPayload {
+		var err error
+		if payloadHash, err = sa.payloadHash(request); err != nil {
+			return nil, err
+		}
+	}
+
+	canonicalRequest := sa.canonicalRequest(request, query, canonicalHeaders, signedHeaders, payloadHash)
+	query.Set("X-Amz-Signature", sa.sign(signTime, canonicalRequest))
+
+	presigned := *request.URL
+	presigned.RawQuery = sigV4CanonicalQuery(query)
+	return &presigned, nil
+}
+
+func (sa SigV4Authenticator) now() time.Time {
+	if sa.Now != nil {
+		return sa.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (sa SigV4Authenticator) credentialScope(signTime time.Time) string {
+	return strings.Join([]string{signTime.Format(sigV4DateFormat), sa.Region, sa.Service, "aws4_request"}, "/")
+}
+
+/* payloadHash returns the hex encoded SHA-256 of the request body without consuming it, or the unsigned payload literal */
+func (sa SigV4Authenticator) payloadHash(request *http.Request) (string, error) {
+	if sa.UnsignedPayload {
+		return sigV4UnsignedPayload, nil
+	}
+	body, err := peekRequestBody(request)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to read request body to compute payload hash")
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (sa SigV4Authenticator) canonicalRequest(request *http.Request, query url.Values, canonicalHeaders, signedHeaders, payloadHash string) string {
+	return strings.Join([]string{
+		request.Method,
+		sa.canonicalURI(request.URL),
+		sigV4CanonicalQuery(query),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+}
+
+/* canonicalURI returns the URI encoded path. S3 paths are encoded once, other services' paths are encoded twice */
+func (sa SigV4Authenticator) canonicalURI(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	if sa.Service == "s3" {
+		return sigV4Escape(u.Path, false)
+	}
+	return sigV4Escape(u.EscapedPath(), false)
+}
+
+/* sign derives the signing key for the day of signTime and returns the hex encoded signature of canonicalRequest */
+func (sa SigV4Authenticator) sign(signTime time.Time, canonicalRequest string) string {
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		signTime.Format(sigV4TimeFormat),
+		sa.credentialScope(signTime),
+		hex.EncodeToString(hashedRequest[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+sa.Credentials.SecretAccessKey), signTime.Format(sigV4DateFormat))
+	key = hmacSHA256(key, sa.Region)
+	key = hmacSHA256(key, sa.Service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+/* sigV4CanonicalHeaders returns the signed header list and the canonical headers block of the request. Header names
+are lowercased and sorted, values are trimmed and sequential spaces are collapsed */
+func sigV4CanonicalHeaders(request *http.Request) (string, string) {
+	values := map[string][]string{"host": {requestHost(request)}}
+	for name, vs := range request.Header {
+		lowered := strings.ToLower(name)
+		if sigV4IgnoredHeaders[lowered] || lowered == "host" {
+			continue
+		}
+		values[lowered] = append(values[lowered], vs...)
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonical := strings.Builder{}
+	for _, name := range names {
+		trimmed := make([]string, len(values[name]))
+		for i, v := range values[name] {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		canonical.WriteString(name + ":" + strings.Join(trimmed, ",") + "\n")
+	}
+	return strings.Join(names, ";"), canonical.String()
+}
+
+/* sigV4CanonicalQuery encodes query sorted by key and then value using the SigV4 URI encoding */
+func sigV4CanonicalQuery(query url.Values) string {
+	type pair struct{ key, value string }
+	pairs := make([]pair, 0, len(query))
+	for key, vs := range query {
+		for _, v := range vs {
+			pairs = append(pairs, pair{key: sigV4Escape(key, true), value: sigV4Escape(v, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].key != pairs[j].key {
+			return pairs[i].key < pairs[j].key
+		}
+		return pairs[i].value < pairs[j].value
+	})
+
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = p.key + "=" + p.value
+	}
+	return strings.Join(encoded, "&")
+}
+
+/* sigV4Escape percent encodes every byte except the unreserved characters (A-Z, a-z, 0-9, '-', '.', '_', '~'),
+slashes are kept as they are unless encodeSlash is set */
+func sigV4Escape(s string, encodeSlash bool) string {
+	escaped := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '.' || c == '_' || c == '~' || (c == '/' && !encodeSlash) {
+			escaped.WriteByte(c)
+			continue
+		}
+		escaped.WriteString(fmt.Sprintf("%%%02X", c))
+	}
+	return escaped.String()
+}
+
+/* requestHost returns the host the request is going to be sent to */
+func requestHost(request *http.Request) string {
+	if request.Host != "" {
+		return request.Host
+	}
+	return request.URL.Host
+}
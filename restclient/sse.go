@@ -0,0 +1,317 @@
+package restclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultEventSourceRetryDelay    = 3 * time.Second
+	defaultEventSourceMaxRetryDelay = time.Minute
+)
+
+/* Event is an event received by an EventSource */
+type Event struct {
+	ID    string // last event ID of the stream when the event was received
+	Event string // type of the event, "message" unless the server set it
+	Data  string
+}
+
+/* EventSourceConfig holds the configuration of an EventSource.
+- RetryDelay: delay before reconnecting, defaults to 3 seconds, the server can change it with the retry field
+- MaxRetryDelay: cap of the delay, which doubles with each connection that fails in a row, defaults to 1 minute
+- MaxReconnects: optional maximum number of reconnects in a row without receiving an event, Zero (0) means no maximum
+- LastEventID: optional ID of the last event received before, to resume the stream from */
+type EventSourceConfig struct {
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	MaxReconnects int
+	LastEventID   string
+}
+
+/* EventSource is a Server-Sent Events (text/event-stream) client, create it with NewEventSource. It connects with a
+GET request built out of its builder, so that the authenticator, headers and TLS settings of the builder apply, and
+reconnects with Last-Event-ID whenever the stream ends or the connection fails. Reconnecting stops on a 204 No Content
+response or an error response other than 408, 429 and 5xx. Call Next until it returns false, then check Err. Close it,
+or cancel its context, to stop */
+type EventSource struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	config EventSourceConfig
+
+	events chan Event
+	done   chan struct{} // closed once the EventSource stopped, err is set by then
+	event  Event
+	err    RequestError
+	closed bool
+
+	mu          sync.Mutex
+	lastEventID string
+	retryDelay  time.Duration
+}
+
+/* eventConnection is the outcome of a single connection of an EventSource */
+type eventConnection struct {
+	received bool  // an event was received
+	finished bool  // the server asked not to reconnect
+	fatal    error // the response is not an event stream
+}
+
+/* NewEventSource connects to the event stream requested with builder and returns the EventSource receiving its
+events. The next event is only read once the current one is consumed. Builder timeouts do not apply to the stream,
+cancel ctx instead */
+func NewEventSource(ctx context.Context, builder HttpRequestBuilder, config EventSourceConfig) *EventSource {
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = defaultEventSourceRetryDelay
+	}
+	if config.MaxRetryDelay <= 0 {
+		config.MaxRetryDelay = defaultEventSourceMaxRetryDelay
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	es := &EventSource{
+		ctx:         ctx,
+		cancel:      cancel,
+		config:      config,
+		events:      make(chan Event),
+		done:        make(chan struct{}),
+		lastEventID: config.LastEventID,
+		retryDelay:  config.RetryDelay,
+	}
+	go es.run(detachRequest(builder).Timeout(0))
+	return es
+}
+
+/* Next waits for the next event, it returns false once the EventSource stopped */
+func (es *EventSource) Next() bool {
+	select {
+	case es.event = <-es.events:
+		// The ID is committed once the caller got the event, so that a reconnect never skips an event it has not seen
+		es.mu.Lock()
+		es.lastEventID = es.event.ID
+		es.mu.Unlock()
+		return true
+	case <-es.done:
+		es.event = Event{}
+		return false
+	}
+}
+
+/* Event returns the current event */
+func (es *EventSource) Event() Event {
+	return es.event
+}
+
+/* Err returns the error that stopped the EventSource, nil if the server asked not to reconnect or it was closed.
+Call it once Next returned false */
+func (es *EventSource) Err() RequestError {
+	if es.closed {
+		return nil
+	}
+	select {
+	case <-es.done:
+		return es.err
+	default:
+		return nil
+	}
+}
+
+/* Close stops the EventSource and closes its connection */
+func (es *EventSource) Close() {
+	es.closed = true
+	es.cancel()
+	<-es.done
+}
+
+/* LastEventID returns the ID of the last event received, which is sent as Last-Event-ID when reconnecting */
+func (es *EventSource) LastEventID() string {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.lastEventID
+}
+
+/* run connects and reconnects until the EventSource stops */
+func (es *EventSource) run(builder HttpRequestBuilder) {
+	defer close(es.done)
+	failures := 0
+	for {
+		conn, reqErr := es.connect(builder)
+		if es.ctx.Err() != nil {
+			es.err = cancelledError(es.ctx, "Event stream")
+			return
+		}
+		if conn.finished {
+			return
+		}
+		if conn.fatal != nil {
+			es.err = NewRequestResponseParseError(InvalidResponseBodyErr, conn.fatal)
+			return
+		}
+		if reqErr != nil && !isTransientError(reqErr) {
+			es.err = reqErr
+			return
+		}
+
+		if conn.received {
+			failures = 0
+		} else {
+			failures++
+		}
+		if es.config.MaxReconnects > 0 && failures > es.config.MaxReconnects {
+			es.err = reqErr
+			if es.err == nil {
+				es.err = NewRequestConnectionError(HttpClientErr, errors.Errorf("Event stream ended %d times in a row without an event", failures))
+			}
+			return
+		}
+		timer := time.NewTimer(es.reconnectDelay(failures))
+		select {
+		case <-timer.C:
+		case <-es.ctx.Done():
+			timer.Stop()
+			es.err = cancelledError(es.ctx, "Event stream")
+			return
+		}
+	}
+}
+
+/* reconnectDelay returns the delay before reconnecting after failures connections failed in a row */
+func (es *EventSource) reconnectDelay(failures int) time.Duration {
+	es.mu.Lock()
+	delay := es.retryDelay
+	es.mu.Unlock()
+	for i := 1; i < failures && delay < es.config.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > es.config.MaxRetryDelay {
+		delay = es.config.MaxRetryDelay
+	}
+	return delay
+}
+
+/* connect receives the events of a single connection to the event stream */
+func (es *EventSource) connect(builder HttpRequestBuilder) (*eventConnection, RequestError) {
+	conn := &eventConnection{}
+	builder.hr.consume = func(resp *http.Response) error {
+		if resp.StatusCode == http.StatusNoContent {
+			conn.finished = true
+			return nil
+		}
+		if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/event-stream" {
+			conn.fatal = errors.Errorf("Expected an event stream, got Content-Type %q", resp.Header.Get("Content-Type"))
+			return conn.fatal
+		}
+		return es.receive(resp.Body, conn)
+	}
+	request, reqErr := builder.Build()
+	if reqErr != nil {
+		return conn, reqErr
+	}
+	request.request = request.request.WithContext(es.ctx)
+	if request.request.Header.Get("Accept") == "" {
+		request.request.Header.Set("Accept", "text/event-stream")
+	}
+	request.request.Header.Set("Cache-Control", "no-cache")
+	if lastEventID := es.LastEventID(); lastEventID != "" {
+		request.request.Header.Set("Last-Event-ID", lastEventID)
+	}
+	return conn, request.Get()
+}
+
+/* receive parses the event stream read from body and hands its events to Next */
+func (es *EventSource) receive(body io.Reader, conn *eventConnection) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, 1<<20)
+	scanner.Split(scanEventLines)
+	var eventType string
+	var data bytes.Buffer
+	// An id only becomes the last event ID once its event is dispatched, a connection dropped in between resumes before it
+	eventID := es.LastEventID()
+	for first := true; scanner.Scan(); first = false {
+		line := scanner.Text()
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line == "" {
+			// A blank line dispatches the event, Next commits its ID. An event without data only sets the ID
+			if data.Len() == 0 {
+				es.mu.Lock()
+				es.lastEventID = eventID
+				es.mu.Unlock()
+			} else {
+				event := Event{ID: eventID, Event: eventType, Data: strings.TrimSuffix(data.String(), "\n")}
+				if event.Event == "" {
+					event.Event = "message"
+				}
+				select {
+				case es.events <- event:
+					conn.received = true
+				case <-es.ctx.Done():
+					return es.ctx.Err()
+				}
+			}
+			eventType = ""
+			data.Reset()
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				eventID = value
+			}
+		case "retry":
+			if millis, err := strconv.ParseUint(value, 10, 32); err == nil {
+				es.mu.Lock()
+				es.retryDelay = time.Duration(millis) * time.Millisecond
+				es.mu.Unlock()
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+/* scanEventLines is a bufio.SplitFunc splitting an event stream into lines ending with CRLF, LF or CR */
+func scanEventLines(data []byte, atEOF bool) (int, []byte, error) {
+	for i, b := range data {
+		switch b {
+		case '\n':
+			return i + 1, data[:i], nil
+		case '\r':
+			if i+1 < len(data) {
+				if data[i+1] == '\n' {
+					return i + 2, data[:i], nil
+				}
+				return i + 1, data[:i], nil
+			}
+			if !atEOF {
+				// A LF may follow
+				return 0, nil, nil
+			}
+			return i + 1, data[:i], nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,162 @@
+package restclient
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventSource(t *testing.T) {
+	var mu sync.Mutex
+	var connections int
+	var lastEventIDs, authorizations []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		connections++
+		connection := connections
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		switch r.URL.Path {
+		case "/events":
+			switch connection {
+			case 1:
+				_, _ = w.Write([]byte("\ufeff: connected\nretry: 10\n\nid: 1\ndata: first\n\nid: 2\nevent: update\ndata:second\ndata:  line\n\n"))
+			case 2:
+				_, _ = w.Write([]byte("id: 3\r\ndata: third\r\n\r\ndata: without id\rid\r\r: incomplete event at the end\ndata: dropped"))
+			default:
+				w.WriteHeader(http.StatusNoContent)
+			}
+		case "/dropped":
+			if r.Header.Get("Last-Event-ID") == "" {
+				_, _ = w.Write([]byte("id: 1\ndata: first\n\nid: 2\ndata: cut off"))
+			} else {
+				w.WriteHeader(http.StatusNoContent)
+			}
+		case "/pending":
+			_, _ = w.Write([]byte("id: 1\ndata: first\n\nid: 2\ndata: second\n\n"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte("{}"))
+		case "/endless":
+			for r.Context().Err() == nil {
+				if _, err := w.Write([]byte("data: tick\n\n")); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+				time.Sleep(5 * time.Millisecond)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	reset := func() {
+		mu.Lock()
+		connections, lastEventIDs, authorizations = 0, nil, nil
+		mu.Unlock()
+	}
+
+	Convey("TEST Events are received across reconnects with Last-Event-ID", t, func() {
+		reset()
+		events := NewEventSource(context.Background(),
+			RequestBuilder().RawUrl(ts.URL+"/events").Auth(NewBearerTokenAuthenticator("token")),
+			EventSourceConfig{RetryDelay: time.Hour})
+		var received []Event
+		for events.Next() {
+			received = append(received, events.Event())
+		}
+
+		So(events.Err(), ShouldBeNil)
+		So(received, ShouldResemble, []Event{
+			{ID: "1", Event: "message", Data: "first"},
+			{ID: "2", Event: "update", Data: "second\n line"},
+			{ID: "3", Event: "message", Data: "third"},
+			{ID: "", Event: "message", Data: "without id"},
+		})
+		So(events.LastEventID(), ShouldEqual, "")
+		So(lastEventIDs, ShouldResemble, []string{"", "2", ""})
+		So(authorizations, ShouldResemble, []string{"Bearer token", "Bearer token", "Bearer token"})
+	})
+
+	Convey("TEST The id of an event dropped before its dispatch is not sent as Last-Event-ID", t, func() {
+		reset()
+		events := NewEventSource(context.Background(), RequestBuilder().RawUrl(ts.URL+"/dropped"),
+			EventSourceConfig{RetryDelay: time.Millisecond})
+		var received []Event
+		for events.Next() {
+			received = append(received, events.Event())
+		}
+
+		So(events.Err(), ShouldBeNil)
+		So(received, ShouldResemble, []Event{{ID: "1", Event: "message", Data: "first"}})
+		So(events.LastEventID(), ShouldEqual, "1")
+		So(lastEventIDs, ShouldResemble, []string{"", "1"})
+	})
+
+	Convey("TEST The ID of an event that was not taken by Next is not committed", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		events := NewEventSource(ctx, RequestBuilder().RawUrl(ts.URL+"/pending"), EventSourceConfig{})
+		So(events.Next(), ShouldBeTrue)
+		So(events.Event().ID, ShouldEqual, "1")
+		// Let the second event wait for Next until the context is cancelled
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		<-events.done
+
+		So(events.LastEventID(), ShouldEqual, "1")
+	})
+
+	Convey("TEST Failing connections are retried up to MaxReconnects", t, func() {
+		reset()
+		events := NewEventSource(context.Background(), RequestBuilder().RawUrl(ts.URL+"/unavailable"),
+			EventSourceConfig{RetryDelay: time.Millisecond, MaxReconnects: 2, LastEventID: "41"})
+
+		So(events.Next(), ShouldBeFalse)
+		So(events.Err(), ShouldNotBeNil)
+		So(events.Err().GetTopLevelError(), ShouldEqual, ServiceUnavailableErr)
+		So(connections, ShouldEqual, 3)
+		So(lastEventIDs, ShouldResemble, []string{"41", "41", "41"})
+	})
+
+	Convey("TEST Client errors and other content types stop the EventSource", t, func() {
+		reset()
+		missing := NewEventSource(context.Background(), RequestBuilder().RawUrl(ts.URL+"/missing"), EventSourceConfig{})
+		So(missing.Next(), ShouldBeFalse)
+		So(missing.Err().GetTopLevelError(), ShouldEqual, RecordNotFoundErr)
+
+		notStream := NewEventSource(context.Background(), RequestBuilder().RawUrl(ts.URL+"/json"), EventSourceConfig{})
+		So(notStream.Next(), ShouldBeFalse)
+		So(notStream.Err().GetTopLevelError(), ShouldEqual, InvalidResponseBodyErr)
+		So(notStream.Err().GetMessage(), ShouldContainSubstring, "Expected an event stream")
+		So(connections, ShouldEqual, 2)
+	})
+
+	Convey("TEST The EventSource stops once closed or its context is done", t, func() {
+		closed := NewEventSource(context.Background(), RequestBuilder().RawUrl(ts.URL+"/endless"), EventSourceConfig{})
+		So(closed.Next(), ShouldBeTrue)
+		So(closed.Event().Data, ShouldEqual, "tick")
+		closed.Close()
+		So(closed.Next(), ShouldBeFalse)
+		So(closed.Err(), ShouldBeNil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelled := NewEventSource(ctx, RequestBuilder().RawUrl(ts.URL+"/endless"), EventSourceConfig{})
+		So(cancelled.Next(), ShouldBeTrue)
+		cancel()
+		for cancelled.Next() {
+		}
+		So(cancelled.Err(), ShouldNotBeNil)
+		So(cancelled.Err().ConnectionError(), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,201 @@
+package restclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+)
+
+/* StopStream can be returned by JSONStream.Handle to stop streaming without an error */
+var StopStream = errors.New("Stream stopped")
+
+/* StreamFormat is the format of a streamed response body */
+type StreamFormat int
+
+const (
+	StreamJSONArray StreamFormat = iota // a top-level JSON array, streamed element by element
+	StreamNDJSON                        // newline delimited JSON (JSON Lines), streamed record by record
+)
+
+/* JSONStream decodes a response body record by record instead of reading it into memory at once, set it with
+HttpRequestBuilder.Stream. Each record is decoded into a new value created by NewRecord and handed to Handle before
+the next one is read, so that a slow Handle holds back reading the body and memory use stays bounded by the size of a
+record. Streaming stops at the first error of Handle, StopStream stops it without an error, or once the context of
+the request is done.
+- Format: StreamJSONArray or StreamNDJSON
+- NewRecord: returns a new pointer to decode each record into e.g. func() interface{} { return &User{} }, defaults to
+  a pointer to an interface{}
+- Handle: called with each decoded record in order, required */
+type JSONStream struct {
+	Format    StreamFormat
+	NewRecord func() interface{}
+	Handle    func(record interface{}) error
+}
+
+/* decode decodes body record by record, it returns the error of Handle wrapped */
+func (js *JSONStream) decode(ctx context.Context, body io.Reader) error {
+	newRecord := js.NewRecord
+	if newRecord == nil {
+		newRecord = func() interface{} {
+			return new(interface{})
+		}
+	}
+	handle := func(decode func(record interface{}) error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record := newRecord()
+		if err := decode(record); err != nil {
+			return err
+		}
+		if err := js.Handle(record); err != nil {
+			if err == StopStream {
+				return err
+			}
+			return errors.Wrap(err, "Stream handler failed")
+		}
+		return nil
+	}
+
+	var err error
+	switch js.Format {
+	case StreamNDJSON:
+		err = decodeNDJSON(body, handle)
+	default:
+		err = decodeJSONArray(body, handle)
+	}
+	if err == StopStream {
+		return nil
+	}
+	return err
+}
+
+/* decodeJSONArray calls handle for each element of the top-level JSON array read from body */
+func decodeJSONArray(body io.Reader, handle func(decode func(record interface{}) error) error) error {
+	decoder := json.NewDecoder(body)
+	token, err := decoder.Token()
+	if err != nil {
+		return errors.Wrap(err, "Failed to read the start of the array")
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return errors.Errorf("Expected a JSON array, got %v", token)
+	}
+	for index := 0; decoder.More(); index++ {
+		if err = handle(decoder.Decode); err != nil {
+			if err == StopStream {
+				return err
+			}
+			return errors.Wrapf(err, "Failed to stream array element %d", index)
+		}
+	}
+	if _, err = decoder.Token(); err != nil {
+		return errors.Wrap(err, "Failed to read the end of the array")
+	}
+	return nil
+}
+
+/* decodeNDJSON calls handle for each line of body that is not blank */
+func decodeNDJSON(body io.Reader, handle func(decode func(record interface{}) error) error) error {
+	reader := bufio.NewReader(body)
+	for line := 1; ; line++ {
+		content, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return errors.Wrapf(err, "Failed to read line %d", line)
+		}
+		if record := bytes.TrimSpace(content); len(record) > 0 {
+			decodeLine := func(v interface{}) error {
+				return json.Unmarshal(record, v)
+			}
+			if handleErr := handle(decodeLine); handleErr != nil {
+				if handleErr == StopStream {
+					return handleErr
+				}
+				return errors.Wrapf(handleErr, "Failed to stream line %d", line)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+/* RecordIterator iterates the records of a streamed response body, create it with StreamRecords. Call Next until it
+returns false, then check Err. Close it to stop early */
+type RecordIterator struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	records chan interface{}
+	done    chan struct{} // closed once the request is over, err is set by then
+	record  interface{}
+	err     RequestError
+	closed  bool
+}
+
+/* StreamRecords sends a GET request built out of builder and returns a RecordIterator over the records of its
+response body in format, each decoded into a new value created by newRecord. The next record is only read once the
+current one is consumed. Builder timeouts do not apply to the stream, the request is cancelled along with ctx instead */
+func StreamRecords(ctx context.Context, builder HttpRequestBuilder, format StreamFormat, newRecord func() interface{}) *RecordIterator {
+	ctx, cancel := context.WithCancel(ctx)
+	ri := &RecordIterator{ctx: ctx, cancel: cancel, records: make(chan interface{}), done: make(chan struct{})}
+	go func() {
+		defer close(ri.done)
+		request, reqErr := builder.Timeout(0).Stream(&JSONStream{Format: format, NewRecord: newRecord, Handle: func(record interface{}) error {
+			select {
+			case ri.records <- record:
+				return nil
+			case <-ctx.Done():
+				return StopStream
+			}
+		}}).Build()
+		if reqErr == nil {
+			request.request = request.request.WithContext(ctx)
+			reqErr = request.Get()
+		}
+		if ctx.Err() != nil {
+			reqErr = cancelledError(ctx, "Stream")
+		}
+		ri.err = reqErr
+	}()
+	return ri
+}
+
+/* Next waits for the next record, it returns false once there are no more records, an error occurs or the iterator
+is closed */
+func (ri *RecordIterator) Next() bool {
+	select {
+	case ri.record = <-ri.records:
+		return true
+	case <-ri.done:
+		ri.record = nil
+		return false
+	}
+}
+
+/* Value returns the current record */
+func (ri *RecordIterator) Value() interface{} {
+	return ri.record
+}
+
+/* Err returns the error that stopped the iteration, nil if it stopped after the last record or was closed. Call it
+once Next returned false */
+func (ri *RecordIterator) Err() RequestError {
+	if ri.closed {
+		return nil
+	}
+	select {
+	case <-ri.done:
+		return ri.err
+	default:
+		return nil
+	}
+}
+
+/* Close stops the iteration and cancels the request */
+func (ri *RecordIterator) Close() {
+	ri.closed = true
+	ri.cancel()
+	<-ri.done
+}
@@ -0,0 +1,199 @@
+package restclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONStream(t *testing.T) {
+	var accept string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept = r.Header.Get("Accept")
+		switch r.URL.Path {
+		case "/array":
+			encoder := json.NewEncoder(w)
+			_, _ = w.Write([]byte("["))
+			for i := 1; i <= 1000; i++ {
+				if i > 1 {
+					_, _ = w.Write([]byte(","))
+				}
+				_ = encoder.Encode(testRequestBody{TestId: i, TestName: fmt.Sprintf("task %d", i)})
+			}
+			_, _ = w.Write([]byte("]"))
+		case "/ndjson":
+			_, _ = w.Write([]byte("{\"test_id\": 1}\n\n{\"test_id\": 2}\r\n{\"test_id\": 3}"))
+		case "/malformed":
+			_, _ = w.Write([]byte("[{\"test_id\": 1}, {\"test_id\": ]"))
+		case "/object":
+			_, _ = w.Write([]byte("{\"test_id\": 1}"))
+		case "/endless":
+			// Writes records until the client goes away, reading it at once would never end
+			for i := 1; r.Context().Err() == nil; i++ {
+				if _, err := fmt.Fprintf(w, "{\"test_id\": %d}\n", i); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	defer ts.Close()
+
+	var ids []int
+	collect := func(record interface{}) error {
+		ids = append(ids, record.(*testRequestBody).TestId)
+		return nil
+	}
+	newTask := func() interface{} {
+		return &testRequestBody{}
+	}
+
+	Convey("TEST A JSON array is streamed element by element", t, func() {
+		ids = nil
+		request, _ := RequestBuilder().RawUrl(ts.URL + "/array").
+			Stream(&JSONStream{Format: StreamJSONArray, NewRecord: newTask, Handle: collect}).Build()
+		reqErr := request.Get()
+
+		So(reqErr, ShouldBeNil)
+		So(len(ids), ShouldEqual, 1000)
+		So(ids[0], ShouldEqual, 1)
+		So(ids[999], ShouldEqual, 1000)
+		So(accept, ShouldEqual, "application/json")
+	})
+
+	Convey("TEST NDJSON is streamed line by line", t, func() {
+		ids = nil
+		request, _ := RequestBuilder().RawUrl(ts.URL + "/ndjson").
+			Stream(&JSONStream{Format: StreamNDJSON, NewRecord: newTask, Handle: collect}).Build()
+		reqErr := request.Get()
+
+		So(reqErr, ShouldBeNil)
+		So(ids, ShouldResemble, []int{1, 2, 3})
+		So(accept, ShouldEqual, "application/x-ndjson")
+	})
+
+	Convey("TEST StopStream stops streaming without an error", t, func() {
+		ids = nil
+		request, _ := RequestBuilder().RawUrl(ts.URL + "/endless").
+			Stream(&JSONStream{Format: StreamNDJSON, NewRecord: newTask, Handle: func(record interface{}) error {
+				_ = collect(record)
+				if len(ids) == 5 {
+					return StopStream
+				}
+				return nil
+			}}).Build()
+		reqErr := request.Get()
+
+		So(reqErr, ShouldBeNil)
+		So(ids, ShouldResemble, []int{1, 2, 3, 4, 5})
+	})
+
+	Convey("TEST Streamed requests bypass de-duplication and caching", t, func() {
+		ids = nil
+		var metadata ResponseMetadata
+		request, _ := RequestBuilder().RawUrl(ts.URL + "/endless").
+			Dedup(NewRequestGroup()).Cache(NewResponseCache(nil)).ResponseMetadata(&metadata).
+			Stream(&JSONStream{Format: StreamNDJSON, NewRecord: newTask, Handle: func(record interface{}) error {
+				_ = collect(record)
+				if len(ids) == 3 {
+					return StopStream
+				}
+				return nil
+			}}).Build()
+		reqErr := request.Get()
+
+		So(reqErr, ShouldBeNil)
+		So(ids, ShouldResemble, []int{1, 2, 3})
+		So(metadata.Shared, ShouldBeFalse)
+		So(metadata.FromCache, ShouldBeFalse)
+	})
+
+	Convey("TEST Errors of the handler and malformed records stop streaming", t, func() {
+		ids = nil
+		request, _ := RequestBuilder().RawUrl(ts.URL + "/array").
+			Stream(&JSONStream{NewRecord: newTask, Handle: func(record interface{}) error {
+				return errors.New("disk full")
+			}}).Build()
+		handlerErr := request.Get()
+		So(handlerErr, ShouldNotBeNil)
+		So(handlerErr.ResponseParseError(), ShouldBeTrue)
+		So(handlerErr.GetMessage(), ShouldContainSubstring, "disk full")
+
+		request, _ = RequestBuilder().RawUrl(ts.URL + "/malformed").
+			Stream(&JSONStream{NewRecord: newTask, Handle: collect}).Build()
+		malformedErr := request.Get()
+		So(malformedErr, ShouldNotBeNil)
+		So(malformedErr.GetTopLevelError(), ShouldEqual, InvalidResponseBodyErr)
+		So(malformedErr.GetMessage(), ShouldContainSubstring, "array element 1")
+		So(ids, ShouldResemble, []int{1})
+
+		request, _ = RequestBuilder().RawUrl(ts.URL + "/object").
+			Stream(&JSONStream{NewRecord: newTask, Handle: collect}).Build()
+		objectErr := request.Get()
+		So(objectErr, ShouldNotBeNil)
+		So(objectErr.GetMessage(), ShouldContainSubstring, "Expected a JSON array")
+	})
+
+	Convey("TEST Streams without a handler are not built", t, func() {
+		_, reqErr := RequestBuilder().RawUrl(ts.URL + "/array").Stream(&JSONStream{}).Build()
+		So(reqErr, ShouldNotBeNil)
+		So(reqErr.RequestBuildError(), ShouldBeTrue)
+	})
+
+	Convey("TEST StreamRecords iterates the records one at a time", t, func() {
+		records := StreamRecords(context.Background(), RequestBuilder().RawUrl(ts.URL+"/array"), StreamJSONArray, newTask)
+		count := 0
+		for records.Next() {
+			count++
+			So(records.Value().(*testRequestBody).TestId, ShouldEqual, count)
+		}
+		So(records.Err(), ShouldBeNil)
+		So(count, ShouldEqual, 1000)
+	})
+
+	Convey("TEST StreamRecords is not cut off by the timeout of the builder", t, func() {
+		records := StreamRecords(context.Background(), RequestBuilder().RawUrl(ts.URL+"/endless").Timeout(50*time.Millisecond),
+			StreamNDJSON, newTask)
+		defer records.Close()
+		start := time.Now()
+		streaming := true
+		for streaming && time.Since(start) < 200*time.Millisecond {
+			streaming = records.Next()
+		}
+		So(records.Err(), ShouldBeNil)
+		So(streaming, ShouldBeTrue)
+	})
+
+	Convey("TEST StreamRecords stops reading once closed", t, func() {
+		records := StreamRecords(context.Background(), RequestBuilder().RawUrl(ts.URL+"/endless"), StreamNDJSON, newTask)
+		var seen []int
+		for len(seen) < 3 && records.Next() {
+			seen = append(seen, records.Value().(*testRequestBody).TestId)
+		}
+		records.Close()
+
+		So(seen, ShouldResemble, []int{1, 2, 3})
+		So(records.Next(), ShouldBeFalse)
+		So(records.Err(), ShouldBeNil)
+	})
+
+	Convey("TEST StreamRecords is cancelled along with its context", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		records := StreamRecords(ctx, RequestBuilder().RawUrl(ts.URL+"/endless"), StreamNDJSON, newTask)
+		So(records.Next(), ShouldBeTrue)
+		time.AfterFunc(20*time.Millisecond, cancel)
+		for records.Next() {
+		}
+
+		So(records.Err(), ShouldNotBeNil)
+		So(records.Err().ConnectionError(), ShouldBeTrue)
+		So(strings.Contains(records.Err().GetMessage(), context.Canceled.Error()), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,309 @@
+package restclient
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+/* Minimal Structured Field Values (RFC 8941) support for the Signature-Input, Signature and Content-Digest headers.
+Decimals are not supported */
+
+/* sfToken is a structured field token, it is serialized without quotes unlike strings */
+type sfToken string
+
+/* sfParam is a single parameter of an item or inner list, value is one of int64, string, sfToken, []byte or bool */
+type sfParam struct {
+	name  string
+	value interface{}
+}
+
+type sfParams []sfParam
+
+/* sfItem is a bare item with its parameters, value is one of int64, string, sfToken, []byte or bool */
+type sfItem struct {
+	value  interface{}
+	params sfParams
+}
+
+/* sfMember is a member of a dictionary, either an item or an inner list. The parameters of an inner list are kept in params */
+type sfMember struct {
+	name        string
+	item        sfItem
+	innerList   []sfItem
+	isInnerList bool
+	params      sfParams
+}
+
+func (p sfParams) get(name string) (interface{}, bool) {
+	for _, param := range p {
+		if param.name == name {
+			return param.value, true
+		}
+	}
+	return nil, false
+}
+
+type sfParser struct {
+	s string
+	i int
+}
+
+/* parseSFDictionary parses a dictionary header value e.g. `sig1=("@method" "@path");created=1618884473` */
+func parseSFDictionary(value string) ([]sfMember, error) {
+	p := &sfParser{s: value}
+	p.skip(" ")
+	var members []sfMember
+	for !p.eof() {
+		name, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		member := sfMember{name: name}
+		if p.peek() == '=' {
+			p.i++
+			if p.peek() == '(' {
+				member.isInnerList = true
+				if member.innerList, member.params, err = p.parseInnerList(); err != nil {
+					return nil, err
+				}
+			} else if member.item, err = p.parseItem(); err != nil {
+				return nil, err
+			}
+		} else {
+			member.item.value = true
+			if member.item.params, err = p.parseParams(); err != nil {
+				return nil, err
+			}
+		}
+		members = append(members, member)
+
+		p.skip(" \t")
+		if p.eof() {
+			break
+		}
+		if p.peek() != ',' {
+			return nil, p.errorf("expected ','")
+		}
+		p.i++
+		p.skip(" \t")
+		if p.eof() {
+			return nil, p.errorf("trailing ','")
+		}
+	}
+	return members, nil
+}
+
+/* parseSFItem parses a single item with its parameters e.g. `"@query-param";name="id"` */
+func parseSFItem(value string) (sfItem, error) {
+	p := &sfParser{s: value}
+	p.skip(" ")
+	item, err := p.parseItem()
+	if err != nil {
+		return sfItem{}, err
+	}
+	p.skip(" ")
+	if !p.eof() {
+		return sfItem{}, p.errorf("unexpected trailing characters")
+	}
+	return item, nil
+}
+
+func (p *sfParser) eof() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *sfParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *sfParser) skip(chars string) {
+	for !p.eof() && strings.IndexByte(chars, p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+func (p *sfParser) errorf(format string, args ...interface{}) error {
+	return errors.Errorf("Invalid structured field %q at %d: %s", p.s, p.i, fmt.Sprintf(format, args...))
+}
+
+func (p *sfParser) parseInnerList() ([]sfItem, sfParams, error) {
+	p.i++ // '('
+	var items []sfItem
+	for !p.eof() {
+		p.skip(" ")
+		if p.peek() == ')' {
+			p.i++
+			params, err := p.parseParams()
+			return items, params, err
+		}
+		item, err := p.parseItem()
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, item)
+		if c := p.peek(); c != ' ' && c != ')' {
+			return nil, nil, p.errorf("expected ' ' or ')'")
+		}
+	}
+	return nil, nil, p.errorf("unterminated inner list")
+}
+
+func (p *sfParser) parseItem() (sfItem, error) {
+	value, err := p.parseBareItem()
+	if err != nil {
+		return sfItem{}, err
+	}
+	params, err := p.parseParams()
+	if err != nil {
+		return sfItem{}, err
+	}
+	return sfItem{value: value, params: params}, nil
+}
+
+func (p *sfParser) parseParams() (sfParams, error) {
+	var params sfParams
+	for p.peek() == ';' {
+		p.i++
+		p.skip(" ")
+		name, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		var value interface{} = true
+		if p.peek() == '=' {
+			p.i++
+			if value, err = p.parseBareItem(); err != nil {
+				return nil, err
+			}
+		}
+		params = append(params, sfParam{name: name, value: value})
+	}
+	return params, nil
+}
+
+func (p *sfParser) parseKey() (string, error) {
+	start := p.i
+	if c := p.peek(); !(c >= 'a' && c <= 'z') && c != '*' {
+		return "", p.errorf("expected key")
+	}
+	for !p.eof() {
+		c := p.s[p.i]
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && strings.IndexByte("_-.*", c) < 0 {
+			break
+		}
+		p.i++
+	}
+	return p.s[start:p.i], nil
+}
+
+func (p *sfParser) parseBareItem() (interface{}, error) {
+	c := p.peek()
+	switch {
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.i
+		p.i++
+		for !p.eof() && p.s[p.i] >= '0' && p.s[p.i] <= '9' {
+			p.i++
+		}
+		n, err := strconv.ParseInt(p.s[start:p.i], 10, 64)
+		if err != nil || p.peek() == '.' {
+			return nil, p.errorf("invalid integer")
+		}
+		return n, nil
+	case c == '"':
+		value := strings.Builder{}
+		for p.i++; !p.eof(); p.i++ {
+			switch p.s[p.i] {
+			case '\\':
+				p.i++
+				if p.eof() || (p.s[p.i] != '"' && p.s[p.i] != '\\') {
+					return nil, p.errorf("invalid escape")
+				}
+				value.WriteByte(p.s[p.i])
+			case '"':
+				p.i++
+				return value.String(), nil
+			default:
+				value.WriteByte(p.s[p.i])
+			}
+		}
+		return nil, p.errorf("unterminated string")
+	case c == ':':
+		end := strings.IndexByte(p.s[p.i+1:], ':')
+		if end < 0 {
+			return nil, p.errorf("unterminated byte sequence")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(p.s[p.i+1 : p.i+1+end])
+		if err != nil {
+			return nil, p.errorf("invalid byte sequence")
+		}
+		p.i += end + 2
+		return decoded, nil
+	case c == '?':
+		if p.i+1 < len(p.s) && (p.s[p.i+1] == '0' || p.s[p.i+1] == '1') {
+			p.i += 2
+			return p.s[p.i-1] == '1', nil
+		}
+		return nil, p.errorf("invalid boolean")
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '*':
+		start := p.i
+		for !p.eof() && isSFTokenChar(p.s[p.i]) {
+			p.i++
+		}
+		return sfToken(p.s[start:p.i]), nil
+	}
+	return nil, p.errorf("unexpected character")
+}
+
+func isSFTokenChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		strings.IndexByte("!#$%&'*+-.^_`|~:/", c) >= 0
+}
+
+func serializeSFItem(item sfItem) string {
+	return serializeSFBareItem(item.value) + serializeSFParams(item.params)
+}
+
+func serializeSFInnerList(items []sfItem, params sfParams) string {
+	serialized := make([]string, len(items))
+	for i, item := range items {
+		serialized[i] = serializeSFItem(item)
+	}
+	return "(" + strings.Join(serialized, " ") + ")" + serializeSFParams(params)
+}
+
+func serializeSFParams(params sfParams) string {
+	serialized := strings.Builder{}
+	for _, param := range params {
+		serialized.WriteString(";" + param.name)
+		if b, ok := param.value.(bool); !ok || !b {
+			serialized.WriteString("=" + serializeSFBareItem(param.value))
+		}
+	}
+	return serialized.String()
+}
+
+func serializeSFBareItem(value interface{}) string {
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case string:
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+	case sfToken:
+		return string(v)
+	case []byte:
+		return ":" + base64.StdEncoding.EncodeToString(v) + ":"
+	case bool:
+		if v {
+			return "?1"
+		}
+		return "?0"
+	}
+	return ""
+}
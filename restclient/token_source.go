@@ -0,0 +1,82 @@
+package restclient
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+/* defaultTokenExpiryLeeway is how long before its expiry a cached token is considered expired */
+const defaultTokenExpiryLeeway = 10 * time.Second
+
+/* TokenSource provides the credential value (token, API key etc.) that an Authenticator applies to requests. Token
+is called for each request, implement it to rotate the value at runtime */
+type TokenSource interface {
+	Token() (string, error)
+}
+
+/* RefreshableTokenSource is a TokenSource whose token can be refreshed on demand e.g. after it is rejected */
+type RefreshableTokenSource interface {
+	TokenSource
+	Refresh(ctx context.Context) error
+}
+
+/* StaticTokenSource is a TokenSource that always returns the same value */
+type StaticTokenSource string
+
+func (s StaticTokenSource) Token() (string, error) {
+	return string(s), nil
+}
+
+/* TokenSourceFunc is an adapter to use ordinary functions as TokenSource */
+type TokenSourceFunc func() (string, error)
+
+func (f TokenSourceFunc) Token() (string, error) {
+	return f()
+}
+
+/* TokenFetcher fetches a new token along with its expiry time, zero expiry means the token does not expire */
+type TokenFetcher func(ctx context.Context) (token string, expiresAt time.Time, err error)
+
+/* CachingTokenSource is a RefreshableTokenSource that caches the token fetched by its TokenFetcher until it is
+about to expire or it is refreshed explicitly. Concurrent callers share a single fetch */
+type CachingTokenSource struct {
+	fetch     TokenFetcher
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func NewCachingTokenSource(fetch TokenFetcher) *CachingTokenSource {
+	return &CachingTokenSource{fetch: fetch}
+}
+
+/* Token returns the cached token, fetching a new one if there is none or it is about to expire */
+func (cs *CachingTokenSource) Token() (string, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.token != "" && (cs.expiresAt.IsZero() || time.Now().Add(defaultTokenExpiryLeeway).Before(cs.expiresAt)) {
+		return cs.token, nil
+	}
+	if err := cs.fetchLocked(context.Background()); err != nil {
+		return "", err
+	}
+	return cs.token, nil
+}
+
+/* Refresh fetches a new token regardless of the expiry of the cached one */
+func (cs *CachingTokenSource) Refresh(ctx context.Context) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.fetchLocked(ctx)
+}
+
+func (cs *CachingTokenSource) fetchLocked(ctx context.Context) error {
+	token, expiresAt, err := cs.fetch(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Failed to fetch token")
+	}
+	cs.token, cs.expiresAt = token, expiresAt
+	return nil
+}
@@ -0,0 +1,152 @@
+package restclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const maxBandwidthChunk = 32 << 10
+
+/* Progress is the state of a request or response body transfer reported to a ProgressFunc */
+type Progress struct {
+	Transferred int64   // number of bytes transferred so far
+	Total       int64   // size of the body, -1 if unknown
+	Rate        float64 // average rate of the transfer in bytes per second
+}
+
+/* ProgressFunc is called as a request body is sent or a response body is read. It is called from the goroutine
+reading the body, keep it short */
+type ProgressFunc func(progress Progress)
+
+/* BandwidthLimiter caps the rate at which request and response bodies are transferred. A single BandwidthLimiter is
+meant to be shared by all the requests whose transfers should be limited together e.g. the requests of a Session, set
+it on requests using HttpRequestBuilder.BandwidthLimit */
+type BandwidthLimiter struct {
+	bytesPerSecond float64
+	chunk          int
+
+	mu     sync.Mutex
+	tokens float64 // bytes that can be transferred right away, negative once transfers are ahead of the limit
+	last   time.Time
+}
+
+/* NewBandwidthLimiter creates a BandwidthLimiter allowing bytesPerSecond bytes per second in total */
+func NewBandwidthLimiter(bytesPerSecond int64) *BandwidthLimiter {
+	if bytesPerSecond < 1 {
+		bytesPerSecond = 1
+	}
+	chunk := maxBandwidthChunk
+	if bytesPerSecond < int64(chunk) {
+		chunk = int(bytesPerSecond)
+	}
+	return &BandwidthLimiter{
+		bytesPerSecond: float64(bytesPerSecond),
+		chunk:          chunk,
+		tokens:         float64(chunk),
+		last:           time.Now(),
+	}
+}
+
+/* take accounts n transferred bytes and waits until the transfers are back within the limit */
+func (bl *BandwidthLimiter) take(ctx context.Context, n int) error {
+	bl.mu.Lock()
+	now := time.Now()
+	bl.tokens += now.Sub(bl.last).Seconds() * bl.bytesPerSecond
+	if bl.tokens > float64(bl.chunk) {
+		bl.tokens = float64(bl.chunk)
+	}
+	bl.last = now
+	bl.tokens -= float64(n)
+	delay := time.Duration(-bl.tokens / bl.bytesPerSecond * float64(time.Second))
+	bl.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/* transferReader reports the progress of the body it reads and throttles it to the rate of its limiter */
+type transferReader struct {
+	body     io.ReadCloser
+	ctx      context.Context
+	limiter  *BandwidthLimiter
+	progress ProgressFunc
+	total    int64
+
+	start       time.Time
+	transferred int64
+}
+
+/* newTransferReader wraps body, it returns body as it is unless a limiter or a progress function is given */
+func newTransferReader(ctx context.Context, body io.ReadCloser, total int64, limiter *BandwidthLimiter, progress ProgressFunc) io.ReadCloser {
+	if body == nil || body == http.NoBody || (limiter == nil && progress == nil) {
+		return body
+	}
+	return &transferReader{body: body, ctx: ctx, limiter: limiter, progress: progress, total: total}
+}
+
+func (tr *transferReader) Read(p []byte) (int, error) {
+	if tr.start.IsZero() {
+		tr.start = time.Now()
+	}
+	if tr.limiter != nil && len(p) > tr.limiter.chunk {
+		p = p[:tr.limiter.chunk]
+	}
+	n, err := tr.body.Read(p)
+	if n > 0 {
+		if tr.limiter != nil {
+			if waitErr := tr.limiter.take(tr.ctx, n); waitErr != nil {
+				return n, waitErr
+			}
+		}
+		tr.transferred += int64(n)
+		if tr.progress != nil {
+			progress := Progress{Transferred: tr.transferred, Total: tr.total}
+			if elapsed := time.Since(tr.start).Seconds(); elapsed > 0 {
+				progress.Rate = float64(tr.transferred) / elapsed
+			}
+			tr.progress(progress)
+		}
+	}
+	return n, err
+}
+
+func (tr *transferReader) Close() error {
+	return tr.body.Close()
+}
+
+/* trackRequestBody wraps the body of req, and the bodies req.GetBody returns for retries, to report upload progress
+and throttle it. The returned function restores the bodies of req once it was sent */
+func trackRequestBody(req *http.Request, limiter *BandwidthLimiter, progress ProgressFunc) func() {
+	if req.Body == nil || req.Body == http.NoBody || (limiter == nil && progress == nil) {
+		return func() {}
+	}
+	body, getBody := req.Body, req.GetBody
+	total := req.ContentLength
+	if total <= 0 {
+		total = -1
+	}
+	req.Body = newTransferReader(req.Context(), body, total, limiter, progress)
+	if getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			fresh, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return newTransferReader(req.Context(), fresh, total, limiter, progress), nil
+		}
+	}
+	return func() {
+		req.Body, req.GetBody = body, getBody
+	}
+}
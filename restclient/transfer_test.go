@@ -0,0 +1,97 @@
+package restclient
+
+import (
+	"bytes"
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTransferProgressAndBandwidth(t *testing.T) {
+	var received int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = len(body)
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		if r.URL.Query().Get("chunked") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+		}
+		_, _ = w.Write(bytes.Repeat([]byte("a"), size))
+	}))
+	defer ts.Close()
+	discardBody := func(resp *http.Response) error {
+		_, err := ioutil.ReadAll(resp.Body)
+		return err
+	}
+
+	Convey("TEST Upload and download progress is reported", t, func() {
+		var uploads, downloads []Progress
+		request, _ := RequestBuilder().RawUrl(ts.URL + "?size=100000").
+			Body(bytes.NewReader(make([]byte, 50000))).
+			UploadProgress(func(progress Progress) { uploads = append(uploads, progress) }).
+			DownloadProgress(func(progress Progress) { downloads = append(downloads, progress) }).
+			Build()
+		request.consume = discardBody
+		So(request.Post(), ShouldBeNil)
+		So(received, ShouldEqual, 50000)
+
+		So(len(uploads), ShouldBeGreaterThan, 0)
+		last := uploads[len(uploads)-1]
+		So(last.Transferred, ShouldEqual, 50000)
+		So(last.Total, ShouldEqual, 50000)
+		So(last.Rate, ShouldBeGreaterThan, 0)
+
+		So(len(downloads), ShouldBeGreaterThan, 1)
+		So(downloads[0].Transferred, ShouldBeLessThan, 100000)
+		last = downloads[len(downloads)-1]
+		So(last.Transferred, ShouldEqual, 100000)
+		So(last.Total, ShouldEqual, 100000)
+	})
+
+	Convey("TEST The total of a response without Content-Length is unknown", t, func() {
+		var last Progress
+		request, _ := RequestBuilder().RawUrl(ts.URL + "?size=100000&chunked=1").
+			DownloadProgress(func(progress Progress) { last = progress }).
+			Build()
+		request.consume = discardBody
+		So(request.Get(), ShouldBeNil)
+		So(last.Transferred, ShouldEqual, 100000)
+		So(last.Total, ShouldEqual, -1)
+	})
+
+	Convey("TEST Transfers sharing a BandwidthLimiter are throttled together", t, func() {
+		session := NewSession()
+		session.Bandwidth = NewBandwidthLimiter(20000)
+		request, _ := session.RequestBuilder().RawUrl(ts.URL + "?size=20000").
+			Body(bytes.NewReader(make([]byte, 10000))).
+			Build()
+		request.consume = discardBody
+
+		start := time.Now()
+		So(request.Post(), ShouldBeNil)
+		So(received, ShouldEqual, 10000)
+		// The first 20000 bytes are a burst, the remaining 10000 take half a second
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 450*time.Millisecond)
+	})
+
+	Convey("TEST Throttled transfers stop along with their context", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		request, _ := RequestBuilder().RawUrl(ts.URL + "?size=100000").
+			BandwidthLimit(NewBandwidthLimiter(10000)).
+			Build()
+		request.request = request.request.WithContext(ctx)
+		request.consume = discardBody
+
+		start := time.Now()
+		reqErr := request.Get()
+		So(reqErr, ShouldNotBeNil)
+		So(reqErr.GetMessage(), ShouldContainSubstring, "context deadline exceeded")
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+	})
+}
@@ -0,0 +1,476 @@
+package restclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultWebSocketMaxMessageSize = 32 << 20
+	webSocketAcceptGUID            = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	webSocketCloseTimeout          = time.Second
+)
+
+/* WebSocketMessageType is the type of a WebSocket message */
+type WebSocketMessageType int
+
+const (
+	TextMessage   WebSocketMessageType = 1
+	BinaryMessage WebSocketMessageType = 2
+)
+
+/* WebSocket close codes of RFC 6455 */
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+/* WebSocket frame opcodes */
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+/* WebSocketCloseError is returned by WebSocket.ReadMessage once the server closed the connection */
+type WebSocketCloseError struct {
+	Code int
+	Text string
+}
+
+func (wce *WebSocketCloseError) Error() string {
+	return fmt.Sprintf("WebSocket closed with code %d %s", wce.Code, wce.Text)
+}
+
+/* WebSocketConfig holds the configuration of a WebSocket.
+- Subprotocols: optional subprotocols to offer in Sec-WebSocket-Protocol, the one chosen by the server is Subprotocol
+- PingInterval: optional interval to send pings at to keep the connection alive, Zero (0) means no pings
+- PongTimeout: time to wait for any frame after a ping before the connection is considered dead, defaults to PingInterval
+- MaxMessageSize: maximum size of a received message, defaults to 32 MiB */
+type WebSocketConfig struct {
+	Subprotocols   []string
+	PingInterval   time.Duration
+	PongTimeout    time.Duration
+	MaxMessageSize int64
+}
+
+/* WebSocket is a client WebSocket (RFC 6455) connection, dial it with HttpRequestBuilder.DialWebSocket. A single
+goroutine may read and any number of goroutines may write at a time. Pings of the server are answered and keepalive
+pongs are noticed while reading, so keep reading for the connection to stay alive */
+type WebSocket struct {
+	lastRead int64 // unix nanoseconds of the last frame read, first for 64-bit atomic alignment
+	reading  int32 // a ReadMessage is in progress
+
+	conn        io.ReadWriteCloser
+	reader      *bufio.Reader
+	config      WebSocketConfig
+	subprotocol string
+
+	writeMu   sync.Mutex
+	closeSent bool
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+/* DialWebSocket opens a WebSocket connection to the URL of the builder, whose scheme is ws, wss, http or https. The
+opening handshake is sent like a GET request of the builder, so its authenticator, headers, cookies and TLS settings
+apply, and has the timeout of the builder. The connection itself has no timeout, it is closed along with ctx */
+func (hrb HttpRequestBuilder) DialWebSocket(ctx context.Context, config WebSocketConfig) (*WebSocket, RequestError) {
+	handshakeTimeout := hrb.hr.timeout
+	if handshakeTimeout < 0 {
+		handshakeTimeout = defaultTimeoutDuration
+	}
+	request, reqErr := detachRequest(hrb).Timeout(0).Build()
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	req := request.request
+	switch req.URL.Scheme {
+	case "ws":
+		req.URL.Scheme = "http"
+	case "wss":
+		req.URL.Scheme = "https"
+	case "http", "https":
+	default:
+		return nil, NewRequestBuildError(InvalidRequestErr, errors.Errorf("Unsupported WebSocket scheme %s", req.URL.Scheme))
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, NewRequestBuildError(InvalidRequestErr, errors.Wrap(err, "Failed to generate WebSocket key"))
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req.Close = false
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(config.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(config.Subprotocols, ", "))
+	}
+
+	handshakeCtx, cancel := ctx, context.CancelFunc(func() {})
+	if handshakeTimeout > 0 {
+		handshakeCtx, cancel = context.WithTimeout(ctx, handshakeTimeout)
+	}
+	defer cancel()
+	request.request = req.WithContext(handshakeCtx)
+
+	var ws *WebSocket
+	request.consume = func(resp *http.Response) error {
+		if err := verifyWebSocketHandshake(resp, key); err != nil {
+			return err
+		}
+		conn, ok := resp.Body.(io.ReadWriteCloser)
+		if !ok {
+			return errors.New("Upgraded connection is not writable")
+		}
+		// The connection belongs to the WebSocket from now on, keep it from being closed along with the response
+		resp.Body = ioutil.NopCloser(strings.NewReader(""))
+		ws = newWebSocket(conn, config, resp.Header.Get("Sec-WebSocket-Protocol"))
+		return nil
+	}
+	if reqErr = request.Get(); reqErr != nil {
+		return nil, reqErr
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = ws.closeConn()
+		case <-ws.closed:
+		}
+	}()
+	if config.PingInterval > 0 {
+		go ws.keepAlive()
+	}
+	return ws, nil
+}
+
+/* verifyWebSocketHandshake verifies that resp accepts the WebSocket opening handshake sent with key */
+func verifyWebSocketHandshake(resp *http.Response, key string) error {
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return errors.Errorf("Expected 101 Switching Protocols, got %d", resp.StatusCode)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") || !headerContainsToken(resp.Header, "Connection", "upgrade") {
+		return errors.New("Server did not upgrade the connection to WebSocket")
+	}
+	sum := sha1.Sum([]byte(key + webSocketAcceptGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		return errors.New("Invalid Sec-WebSocket-Accept")
+	}
+	return nil
+}
+
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, element := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(element), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newWebSocket(conn io.ReadWriteCloser, config WebSocketConfig, subprotocol string) *WebSocket {
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = defaultWebSocketMaxMessageSize
+	}
+	if config.PongTimeout <= 0 {
+		config.PongTimeout = config.PingInterval
+	}
+	return &WebSocket{
+		conn:        conn,
+		reader:      bufio.NewReader(conn),
+		config:      config,
+		subprotocol: subprotocol,
+		closed:      make(chan struct{}),
+		lastRead:    time.Now().UnixNano(),
+	}
+}
+
+/* Subprotocol returns the subprotocol chosen by the server, empty if none */
+func (ws *WebSocket) Subprotocol() string {
+	return ws.subprotocol
+}
+
+/* ReadMessage reads the next data message, it returns a *WebSocketCloseError once the server closed the connection */
+func (ws *WebSocket) ReadMessage() (WebSocketMessageType, []byte, error) {
+	atomic.StoreInt32(&ws.reading, 1)
+	defer atomic.StoreInt32(&ws.reading, 0)
+	var messageType WebSocketMessageType
+	var message []byte
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case opPing:
+			if err = ws.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, ws.handleClose(payload)
+		case opText, opBinary:
+			if messageType != 0 {
+				return 0, nil, ws.fail(CloseProtocolError, "New message before the end of the fragmented one")
+			}
+			messageType = WebSocketMessageType(opcode)
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, ws.fail(CloseProtocolError, "Continuation frame without a message")
+			}
+		default:
+			return 0, nil, ws.fail(CloseProtocolError, fmt.Sprintf("Unknown opcode %d", opcode))
+		}
+
+		if int64(len(message)+len(payload)) > ws.config.MaxMessageSize {
+			return 0, nil, ws.fail(CloseMessageTooBig, "Message is too big")
+		}
+		message = append(message, payload...)
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(message) {
+				return 0, nil, ws.fail(CloseInvalidPayload, "Text message is not valid UTF-8")
+			}
+			return messageType, message, nil
+		}
+	}
+}
+
+/* WriteMessage sends data as a single message of messageType */
+func (ws *WebSocket) WriteMessage(messageType WebSocketMessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return errors.Errorf("Invalid message type %d", messageType)
+	}
+	return ws.writeFrame(byte(messageType), data)
+}
+
+/* ReadJSON reads the next message and decodes it into v */
+func (ws *WebSocket) ReadJSON(v interface{}) error {
+	_, message, err := ws.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(json.Unmarshal(message, v), "Failed to decode message")
+}
+
+/* WriteJSON sends v encoded as a text message */
+func (ws *WebSocket) WriteJSON(v interface{}) error {
+	message, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode message")
+	}
+	return ws.WriteMessage(TextMessage, message)
+}
+
+/* Close closes the connection with a normal closure */
+func (ws *WebSocket) Close() error {
+	return ws.CloseWithCode(CloseNormalClosure, "")
+}
+
+/* CloseWithCode sends a close frame with code and text, waits briefly for the close frame of the server and closes
+the connection */
+func (ws *WebSocket) CloseWithCode(code int, text string) error {
+	payload := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	err := ws.writeFrame(opClose, append(payload, text...))
+	if err == nil {
+		timer := time.AfterFunc(webSocketCloseTimeout, func() {
+			_ = ws.closeConn()
+		})
+		if atomic.LoadInt32(&ws.reading) == 1 {
+			// The ongoing ReadMessage receives the close frame of the server
+			<-ws.closed
+		} else {
+			for {
+				if _, opcode, _, readErr := ws.readFrame(); readErr != nil || opcode == opClose {
+					break
+				}
+			}
+		}
+		timer.Stop()
+	}
+	if closeErr := ws.closeConn(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+/* handleClose answers the close frame of the server and closes the connection */
+func (ws *WebSocket) handleClose(payload []byte) error {
+	closeErr := &WebSocketCloseError{Code: CloseNoStatusReceived}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+	}
+	reply := payload
+	if len(reply) >= 2 {
+		reply = reply[:2]
+	}
+	_ = ws.writeFrame(opClose, reply)
+	_ = ws.closeConn()
+	return closeErr
+}
+
+/* fail closes the connection with code after a protocol violation of the server */
+func (ws *WebSocket) fail(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	_ = ws.writeFrame(opClose, append(payload, reason...))
+	_ = ws.closeConn()
+	return errors.New(reason)
+}
+
+func (ws *WebSocket) closeConn() error {
+	var err error
+	ws.closeOnce.Do(func() {
+		close(ws.closed)
+		err = ws.conn.Close()
+	})
+	return err
+}
+
+/* keepAlive pings the server every PingInterval and closes the connection once nothing was read for PongTimeout
+after a ping */
+func (ws *WebSocket) keepAlive() {
+	ticker := time.NewTicker(ws.config.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ws.closed:
+			return
+		case <-ticker.C:
+		}
+		pinged := time.Now()
+		if err := ws.writeFrame(opPing, nil); err != nil {
+			return
+		}
+		select {
+		case <-ws.closed:
+			return
+		case <-time.After(ws.config.PongTimeout):
+		}
+		if atomic.LoadInt64(&ws.lastRead) < pinged.UnixNano() {
+			_ = ws.closeConn()
+			return
+		}
+	}
+}
+
+/* readFrame reads a frame, server frames must not be masked */
+func (ws *WebSocket) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(ws.reader, head[:]); err != nil {
+		return false, 0, nil, ws.readError(err)
+	}
+	atomic.StoreInt64(&ws.lastRead, time.Now().UnixNano())
+	fin, opcode := head[0]&0x80 != 0, head[0]&0x0F
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, ws.fail(CloseProtocolError, "Reserved bits are set")
+	}
+	if head[1]&0x80 != 0 {
+		return false, 0, nil, ws.fail(CloseProtocolError, "Server frame is masked")
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return false, 0, nil, ws.readError(err)
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(ws.reader, extended[:]); err != nil {
+			return false, 0, nil, ws.readError(err)
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if opcode >= opClose && (!fin || length > 125) {
+		return false, 0, nil, ws.fail(CloseProtocolError, "Invalid control frame")
+	}
+	if length > uint64(ws.config.MaxMessageSize) {
+		return false, 0, nil, ws.fail(CloseMessageTooBig, "Message is too big")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return false, 0, nil, ws.readError(err)
+	}
+	return fin, opcode, payload, nil
+}
+
+func (ws *WebSocket) readError(err error) error {
+	select {
+	case <-ws.closed:
+		return errors.Wrap(err, "WebSocket is closed")
+	default:
+		return errors.Wrap(err, "Failed to read WebSocket frame")
+	}
+}
+
+/* writeFrame writes a single masked frame, nothing is written after a close frame */
+func (ws *WebSocket) writeFrame(opcode byte, payload []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	if ws.closeSent {
+		return errors.New("WebSocket is closed")
+	}
+	if opcode == opClose {
+		ws.closeSent = true
+	}
+
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, 0x80|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, 0x80|126, byte(length>>8), byte(length))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = append(frame, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(frame[len(frame)-8:], uint64(length))
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return errors.Wrap(err, "Failed to generate frame mask")
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := ws.conn.Write(frame); err != nil {
+		return errors.Wrap(err, "Failed to write WebSocket frame")
+	}
+	return nil
+}
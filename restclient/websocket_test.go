@@ -0,0 +1,276 @@
+package restclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/* testWebSocketServer echoes the messages of its clients. It answers the text message "close" with a close frame,
+"ping" with a ping followed by "pong received" once the pong arrives, and "big" with a fragmented message. Its /plain
+and /private paths do not upgrade */
+type testWebSocketServer struct {
+	mu           sync.Mutex
+	header       http.Header
+	unmaskedSeen bool
+	pings        int
+	silent       bool // do not answer pings
+}
+
+func (s *testWebSocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.header = r.Header.Clone()
+	s.mu.Unlock()
+	switch r.URL.Path {
+	case "/plain":
+		_, _ = w.Write([]byte("{}"))
+		return
+	case "/private":
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + webSocketAcceptGUID))
+	_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n")
+	if strings.Contains(r.Header.Get("Sec-WebSocket-Protocol"), "chat.v2") {
+		_, _ = rw.WriteString("Sec-WebSocket-Protocol: chat.v2\r\n")
+	}
+	_, _ = rw.WriteString("\r\n")
+	_ = rw.Flush()
+
+	for {
+		opcode, payload, masked, err := readTestClientFrame(rw.Reader)
+		if err != nil {
+			return
+		}
+		if !masked {
+			s.mu.Lock()
+			s.unmaskedSeen = true
+			s.mu.Unlock()
+		}
+		switch {
+		case opcode == opClose:
+			writeTestServerFrame(rw.Writer, true, opClose, payload)
+			return
+		case opcode == opPing:
+			s.mu.Lock()
+			s.pings++
+			silent := s.silent
+			s.mu.Unlock()
+			if !silent {
+				writeTestServerFrame(rw.Writer, true, opPong, payload)
+			}
+		case opcode == opPong:
+			writeTestServerFrame(rw.Writer, true, opText, []byte("pong received"))
+		case string(payload) == "close":
+			writeTestServerFrame(rw.Writer, true, opClose, append([]byte{0x03, 0xE9}, "going away"...))
+		case string(payload) == "ping":
+			writeTestServerFrame(rw.Writer, true, opPing, []byte("are you there"))
+		case string(payload) == "big":
+			writeTestServerFrame(rw.Writer, false, opBinary, make([]byte, 70000))
+			writeTestServerFrame(rw.Writer, true, opPing, nil)
+			writeTestServerFrame(rw.Writer, true, opContinuation, []byte("end"))
+		default:
+			writeTestServerFrame(rw.Writer, true, opcode, payload)
+		}
+	}
+}
+
+func readTestClientFrame(r *bufio.Reader) (byte, []byte, bool, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, false, err
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		_, _ = io.ReadFull(r, extended[:])
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		_, _ = io.ReadFull(r, extended[:])
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	masked := head[1]&0x80 != 0
+	var mask [4]byte
+	if masked {
+		_, _ = io.ReadFull(r, mask[:])
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, false, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return head[0] & 0x0F, payload, masked, nil
+}
+
+func writeTestServerFrame(w *bufio.Writer, fin bool, opcode byte, payload []byte) {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	_ = w.WriteByte(first)
+	switch length := len(payload); {
+	case length <= 125:
+		_ = w.WriteByte(byte(length))
+	case length <= 0xFFFF:
+		_, _ = w.Write([]byte{126, byte(length >> 8), byte(length)})
+	default:
+		extended := make([]byte, 9)
+		extended[0] = 127
+		binary.BigEndian.PutUint64(extended[1:], uint64(length))
+		_, _ = w.Write(extended)
+	}
+	_, _ = w.Write(payload)
+	_ = w.Flush()
+}
+
+func TestWebSocket(t *testing.T) {
+	server := &testWebSocketServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/socket"
+
+	Convey("TEST WebSocket is dialed with the URL, auth and headers of the builder", t, func() {
+		ws, reqErr := RequestBuilder().RawUrl(wsURL).
+			Auth(NewBearerTokenAuthenticator("token")).
+			Header(&http.Header{"X-Tenant": []string{"a"}}).
+			DialWebSocket(context.Background(), WebSocketConfig{Subprotocols: []string{"chat.v1", "chat.v2"}})
+		So(reqErr, ShouldBeNil)
+		defer ws.Close()
+
+		So(ws.Subprotocol(), ShouldEqual, "chat.v2")
+		So(server.header.Get("Authorization"), ShouldEqual, "Bearer token")
+		So(server.header.Get("X-Tenant"), ShouldEqual, "a")
+		So(server.header.Get("Sec-WebSocket-Version"), ShouldEqual, "13")
+		So(server.header.Get("Sec-WebSocket-Protocol"), ShouldEqual, "chat.v1, chat.v2")
+	})
+
+	Convey("TEST Messages are exchanged as text, binary and JSON", t, func() {
+		ws, reqErr := RequestBuilder().RawUrl(wsURL).DialWebSocket(context.Background(), WebSocketConfig{})
+		So(reqErr, ShouldBeNil)
+		defer ws.Close()
+
+		So(ws.WriteMessage(TextMessage, []byte("hello")), ShouldBeNil)
+		messageType, message, err := ws.ReadMessage()
+		So(err, ShouldBeNil)
+		So(messageType, ShouldEqual, TextMessage)
+		So(string(message), ShouldEqual, "hello")
+
+		So(ws.WriteMessage(BinaryMessage, make([]byte, 300)), ShouldBeNil)
+		messageType, message, err = ws.ReadMessage()
+		So(err, ShouldBeNil)
+		So(messageType, ShouldEqual, BinaryMessage)
+		So(len(message), ShouldEqual, 300)
+
+		So(ws.WriteJSON(testRequestBody{TestId: 1, TestName: "task"}), ShouldBeNil)
+		var task testRequestBody
+		So(ws.ReadJSON(&task), ShouldBeNil)
+		So(task, ShouldResemble, testRequestBody{TestId: 1, TestName: "task"})
+
+		So(ws.WriteMessage(TextMessage, []byte("big")), ShouldBeNil)
+		messageType, message, err = ws.ReadMessage()
+		So(err, ShouldBeNil)
+		So(messageType, ShouldEqual, BinaryMessage)
+		So(len(message), ShouldEqual, 70003)
+
+		So(ws.WriteMessage(TextMessage, []byte("ping")), ShouldBeNil)
+		_, message, err = ws.ReadMessage()
+		So(err, ShouldBeNil)
+		So(string(message), ShouldEqual, "pong received")
+		So(server.unmaskedSeen, ShouldBeFalse)
+	})
+
+	Convey("TEST Messages larger than MaxMessageSize are refused", t, func() {
+		ws, reqErr := RequestBuilder().RawUrl(wsURL).DialWebSocket(context.Background(), WebSocketConfig{MaxMessageSize: 1000})
+		So(reqErr, ShouldBeNil)
+
+		So(ws.WriteMessage(TextMessage, []byte("big")), ShouldBeNil)
+		_, _, err := ws.ReadMessage()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "too big")
+	})
+
+	Convey("TEST Close frames of the server are returned as WebSocketCloseError", t, func() {
+		ws, reqErr := RequestBuilder().RawUrl(wsURL).DialWebSocket(context.Background(), WebSocketConfig{})
+		So(reqErr, ShouldBeNil)
+
+		So(ws.WriteMessage(TextMessage, []byte("close")), ShouldBeNil)
+		_, _, err := ws.ReadMessage()
+		closeErr, ok := err.(*WebSocketCloseError)
+		So(ok, ShouldBeTrue)
+		So(closeErr.Code, ShouldEqual, CloseGoingAway)
+		So(closeErr.Text, ShouldEqual, "going away")
+		So(ws.WriteMessage(TextMessage, []byte("hello")), ShouldNotBeNil)
+	})
+
+	Convey("TEST Close completes the closing handshake", t, func() {
+		ws, reqErr := RequestBuilder().RawUrl(wsURL).DialWebSocket(context.Background(), WebSocketConfig{})
+		So(reqErr, ShouldBeNil)
+
+		start := time.Now()
+		So(ws.Close(), ShouldBeNil)
+		So(time.Since(start), ShouldBeLessThan, webSocketCloseTimeout)
+		_, _, err := ws.ReadMessage()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("TEST Keepalive closes the connection once pings are not answered", t, func() {
+		server.mu.Lock()
+		server.pings, server.silent = 0, true
+		server.mu.Unlock()
+		ws, reqErr := RequestBuilder().RawUrl(wsURL).DialWebSocket(context.Background(), WebSocketConfig{PingInterval: 20 * time.Millisecond})
+		So(reqErr, ShouldBeNil)
+
+		_, _, err := ws.ReadMessage()
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "WebSocket is closed")
+		server.mu.Lock()
+		So(server.pings, ShouldEqual, 1)
+		server.silent = false
+		server.mu.Unlock()
+	})
+
+	Convey("TEST The connection is closed along with its context", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		ws, reqErr := RequestBuilder().RawUrl(wsURL).DialWebSocket(ctx, WebSocketConfig{})
+		So(reqErr, ShouldBeNil)
+		time.AfterFunc(20*time.Millisecond, cancel)
+
+		_, _, err := ws.ReadMessage()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("TEST Handshakes that are not upgraded fail", t, func() {
+		_, reqErr := RequestBuilder().RawUrl(ts.URL+"/plain").DialWebSocket(context.Background(), WebSocketConfig{})
+		So(reqErr, ShouldNotBeNil)
+		So(reqErr.GetTopLevelError(), ShouldEqual, InvalidResponseBodyErr)
+		So(reqErr.GetMessage(), ShouldContainSubstring, "Expected 101 Switching Protocols, got 200")
+
+		_, reqErr = RequestBuilder().RawUrl(ts.URL+"/private").DialWebSocket(context.Background(), WebSocketConfig{})
+		So(reqErr, ShouldNotBeNil)
+		So(reqErr.GetTopLevelError(), ShouldEqual, UnauthorizedErr)
+
+		_, reqErr = RequestBuilder().RawUrl("ftp://example.com/socket").DialWebSocket(context.Background(), WebSocketConfig{})
+		So(reqErr, ShouldNotBeNil)
+		So(reqErr.RequestBuildError(), ShouldBeTrue)
+	})
+}